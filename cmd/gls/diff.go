@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"go.sazak.io/gls/gui"
+	"go.sazak.io/gls/internal/diff"
+	"go.sazak.io/gls/internal/fs"
+	"go.sazak.io/gls/internal/snapshot"
+	"go.sazak.io/gls/internal/types"
+	"go.sazak.io/gls/log"
+)
+
+// runDiff implements "gls diff OLD NEW", which compares two scans of the same
+// path. Each of OLD and NEW is either a snapshot file, or a directory that is
+// scanned on the spot.
+func runDiff(args []string) {
+	fset := flag.NewFlagSet("diff", flag.ExitOnError)
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Usage: gls diff [flags] OLD NEW\n\nOLD and NEW are snapshot files or directories to scan.\n\n")
+		fset.PrintDefaults()
+	}
+	formatter := fset.String("fmt", "bytes", "size formatter, one of bytes, pow10 or none")
+	noGUI := fset.Bool("nogui", false, "print the changes instead of showing them in the TUI")
+	limit := fset.Int("n", 50, "number of changes to print in text mode, 0 for all")
+	jobs := fset.Int("jobs", fs.DefaultJobs, "maximum number of directories to scan concurrently")
+	fset.Parse(args)
+	if fset.NArg() != 2 {
+		fset.Usage()
+		os.Exit(2)
+	}
+	formatterFunc, ok := formatters[*formatter]
+	if !ok {
+		log.Errorf("Unknown formatter: %s", *formatter)
+		fset.Usage()
+		os.Exit(2)
+	}
+	old, err := loadScan(fset.Arg(0), *jobs)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", fset.Arg(0), err)
+	}
+	new, err := loadScan(fset.Arg(1), *jobs)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", fset.Arg(1), err)
+	}
+	d := diff.Compare(old.Root, new.Root)
+	if *noGUI {
+		printDiff(d, formatterFunc, *limit)
+		return
+	}
+	title := fmt.Sprintf("%s (%s) -> %s (%s)", fset.Arg(0), old.ScannedAt.Format(time.RFC1123), fset.Arg(1), new.ScannedAt.Format(time.RFC1123))
+	if err := gui.RunDiffView(d, title, formatterFunc); err != nil {
+		log.Fatalf("Error running GUI app: %v", err)
+	}
+}
+
+// loadScan loads the snapshot file at name, or scans name if it is a
+// directory.
+func loadScan(name string, jobs int) (*snapshot.Snapshot, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return snapshot.Load(name)
+	}
+	b := fs.NewFileTreeBuilder(name, fs.WithJobs(jobs))
+	if err := b.Build(); err != nil {
+		return nil, err
+	}
+	return &snapshot.Snapshot{Path: b.Path(), ScannedAt: b.ScannedAt(), Root: b.Root()}, nil
+}
+
+func printDiff(d *diff.Node, f types.SizeFormatter, limit int) {
+	fmt.Printf("%s: %s -> %s (%s)\n", d.Name, f(d.OldSize), f(d.NewSize), diff.FormatDelta(d.Delta(), f))
+	for i, c := range d.Changes() {
+		if limit > 0 && i == limit {
+			break
+		}
+		fmt.Printf("%12s  %-9s %s\n", diff.FormatDelta(c.Delta(), f), c.Kind, c.Path())
+	}
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"os/user"
+	"strconv"
 	"strings"
 	"sync"
+	"text/tabwriter"
+	"time"
 
 	"go.sazak.io/gls/gui"
 	"go.sazak.io/gls/internal"
@@ -17,17 +24,43 @@ import (
 )
 
 const (
-	logFile = "gls.log"
+	logFile          = "gls.log"
+	progressInterval = 200 * time.Millisecond
+	// logProgressInterval is how often the progress is printed when stderr
+	// is not a terminal, one line each time.
+	logProgressInterval = 10 * time.Second
 )
 
 var (
-	path          = flag.String("path", "", "path to run on (required)")
+	path          = flag.String("path", "", "path to run on (required unless -load is given)")
 	formatter     = flag.String("fmt", "bytes", "size formatter, one of bytes, pow10 or none")
 	noGUI         = flag.Bool("nogui", false, "text-only mode")
 	sort          = flag.Bool("sort", true, "sort nodes by size")
+	sortBy        = flag.String("sort-by", "size", "order to sort nodes in: size, name, files, dirs, depth, newest, oldest or largest (file); orders other than size turn -sort on")
 	sizeThreshold = flag.String("thresh", "", "size filter threshold, e.g. 10M, 100K, etc.")
 	ignoreFiles   = flag.String("ignore", "", "Comma-separated ignore files that specify which files/folders to exclude")
+	gitignore     = flag.Bool("gitignore", false, "also apply the rules of the .gitignore files in the scanned folders")
 	debug         = flag.Bool("debug", false, "Increase log verbosity")
+	followLinks   = flag.Bool("follow", false, "follow symbolic links, detecting link cycles")
+	oneFs         = flag.Bool("x", false, "stay on one file system, skipping directories other file systems are mounted on")
+	archives      = flag.Bool("archives", false, "list the contents of zip, tar and tar.gz archives as if they were folders")
+	watchFiles    = flag.Bool("watch", false, "keep the tree up to date with file changes (TUI only)")
+	watchPoll     = flag.Duration("watch-poll", 2*time.Second, "interval to poll for file changes at if the platform offers no file system notifications")
+	jobs          = flag.Int("jobs", fs.DefaultJobs, "maximum number of directories to scan concurrently")
+	saveFile      = flag.String("save", "", "save the scanned tree to a snapshot file, as JSON if the name ends in .json")
+	loadFile      = flag.String("load", "", "load the tree from a snapshot file instead of scanning path")
+	strict        = flag.Bool("strict", false, "exit with a non-zero status if any file or folder could not be scanned")
+	ignoreStats   = flag.Bool("ignore-stats", false, "print how many files and folders every ignore rule excluded, and their size (text mode)")
+	showHidden    = flag.Bool("show-hidden", false, "list the entries hidden by ignore rules or the size threshold one by one, instead of one summary per folder")
+	olderThan     = flag.String("older-than", "", "only show files last modified longer ago than this, e.g. 90d, 2w, 1y or 36h")
+	newerThan     = flag.String("newer-than", "", "only show files last modified more recently than this, e.g. 90d, 2w, 1y or 36h")
+	fileTypes     = flag.String("type", "", "only show files of the comma-separated types f (regular), l (symlink), p (pipe), s (socket), c (char device) or b (block device)")
+	owners        = flag.String("owner", "", "only show files owned by one of the comma-separated user names or ids")
+	minFiles      = flag.Int64("min-files", 0, "only show folders with at least this many files below them")
+	journalFile   = flag.String("journal", "", "journal file to record the file operations of the TUI in, to undo them, continuing it if it exists (default a new file per session under $XDG_STATE_HOME/gls/journal)")
+	permanent     = flag.Bool("permanent", false, "delete removed files and folders for good instead of moving them to the trash (TUI only)")
+	includes      patternList
+	excludes      patternList
 
 	formatters = map[string]types.SizeFormatter{
 		"bytes": types.SizeFormatterBytes,
@@ -36,9 +69,39 @@ var (
 	}
 )
 
+func init() {
+	flag.Var(&includes, "include", "only show files matching this ignore file style glob, or re: regex (repeatable)")
+	flag.Var(&excludes, "exclude", "exclude files/folders matching this ignore file style glob, or re: regex (repeatable)")
+}
+
+// patternList is a flag that can be given more than once, collecting the
+// patterns in order.
+type patternList []string
+
+func (l *patternList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *patternList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
 func main() {
+	// exitCode is the status to exit with once the other deferred calls,
+	// which close the log file, have run.
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		runDiff(os.Args[2:])
+		return
+	}
 	flag.Parse()
-	if *path == "" {
+	if *path == "" && *loadFile == "" {
 		flag.Usage()
 		return
 	}
@@ -64,6 +127,16 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to get ignore checker: %v", err)
 	}
+	sortKey, err := types.ParseSortKey(*sortBy)
+	if err != nil {
+		log.Errorf("Invalid sort order: %v", err)
+		return
+	}
+	filter, err := getFilter(time.Now())
+	if err != nil {
+		log.Errorf("Invalid filter: %v", err)
+		return
+	}
 	var sizeThreshBytes int64 = 0
 	if *sizeThreshold != "" {
 		byteSize, mult, err := internal.ParseByteSize(*sizeThreshold)
@@ -83,40 +156,109 @@ func main() {
 		log.Infof("Started gls with path: %s, log file: %s, formatter: %s, gui: %t", *path, logFile, *formatter, !*noGUI)
 	}
 	log.Debugf("Ignore checking rules:\n%s", ignoreChecker.Dump())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	var app *tview.Application
 	if !*noGUI {
-		app = gui.GetApp(*path, formatterFunc)
+		app = gui.GetApp(*path, formatterFunc, cancel)
+		gui.SetShowHidden(*showHidden)
+		gui.SetPermanentDelete(*permanent)
+		if err := gui.OpenJournal(*journalFile); err != nil {
+			log.Warningf("Could not open the journal, file operations cannot be undone: %v", err)
+		}
 	}
-	var wg sync.WaitGroup
+	var (
+		wg         sync.WaitGroup
+		scanErrors []fs.ScanError
+	)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		opts := []fs.FileTreeBuilderOption{
 			fs.WithSizeFormatter(formatterFunc),
 			fs.WithIgnoreChecker(ignoreChecker),
+			fs.WithJobs(*jobs),
 		}
-		if *sort {
-			opts = append(opts, fs.WithSortingBySize())
+		if *sort || sortKey != types.SortBySizeOnDisk {
+			opts = append(opts, fs.WithSortKey(sortKey))
 		}
 		if sizeThreshBytes > 0 {
 			opts = append(opts, fs.WithSizeThreshold(sizeThreshBytes))
 		}
+		if *followLinks {
+			opts = append(opts, fs.WithFollowSymlinks())
+		}
+		if *oneFs {
+			opts = append(opts, fs.WithOneFilesystem())
+		}
+		if *archives {
+			opts = append(opts, fs.WithArchives())
+		}
+		if filter.Active() {
+			opts = append(opts, fs.WithFilter(filter))
+		}
+		if *gitignore {
+			opts = append(opts, fs.WithIgnoreFiles(local.DefaultIgnoreFile, local.GitIgnoreFile))
+		}
+		if *noGUI {
+			inPlace, interval := isTerminal(os.Stderr), logProgressInterval
+			if inPlace {
+				interval = progressInterval
+			}
+			opts = append(opts, fs.WithProgress(interval, func(ev fs.ProgressEvent) {
+				printProgress(ev, formatterFunc, inPlace)
+			}))
+		} else {
+			opts = append(opts, fs.WithProgress(progressInterval, func(ev fs.ProgressEvent) {
+				gui.UpdateLoadingPage(app, ev)
+			}))
+		}
 		b := fs.NewFileTreeBuilder(*path, opts...)
-		if err := b.Build(); err != nil {
-			log.Fatalf("Failed to build file tree: %v", err)
-			return
+		if *loadFile != "" {
+			if err := b.Load(*loadFile); err != nil {
+				log.Fatalf("Failed to load snapshot: %v", err)
+				return
+			}
+			log.Infof("Loaded file tree of %s from snapshot %s", b.Path(), *loadFile)
+		} else {
+			if err := b.BuildContext(ctx); err != nil {
+				if errors.Is(err, context.Canceled) {
+					log.Info("Cancelled building file tree")
+					return
+				}
+				log.Fatalf("Failed to build file tree: %v", err)
+				return
+			}
+			log.Info("Finished building file tree")
+		}
+		scanErrors = b.ScanErrors()
+		if *saveFile != "" {
+			if err := b.Save(*saveFile); err != nil {
+				log.Errorf("Failed to save snapshot: %v", err)
+			} else {
+				log.Infof("Saved snapshot to %s", *saveFile)
+			}
 		}
-		log.Info("Finished building file tree")
 		if *noGUI {
-			if err := b.Print(); err != nil {
+			printOpts := types.PrintOptions{ShowHidden: *showHidden, Dim: isTerminal(os.Stdout)}
+			if err := b.Fprint(os.Stdout, printOpts); err != nil {
 				log.Fatalf("Error while printing the file tree: %v\n", err)
 			}
+			if *ignoreStats {
+				printIgnoreStats(b.IgnoreStats(), formatterFunc)
+			}
+			printScanErrors(scanErrors)
 			return
 		}
 		if !*noGUI {
 			log.Info("Loading tree view on GUI")
-			gui.LoadTreeView(app, b.Root(), *path)
+			gui.LoadTreeView(app, b, b.Path())
 			log.Info("Loaded the tree view on GUI")
+			if *watchFiles && !b.Offline() {
+				if err := gui.StartWatching(app, *watchPoll); err != nil {
+					log.Errorf("Could not watch for file changes: %v", err)
+				}
+			}
 		}
 	}()
 	if !*noGUI {
@@ -124,8 +266,65 @@ func main() {
 			log.Fatalf("Error running GUI app: %v", err)
 		}
 	}
-	if *noGUI {
-		wg.Wait()
+	// The scan is cancelled when the GUI is closed, so this does not wait
+	// long in GUI mode.
+	wg.Wait()
+	if *strict && len(scanErrors) > 0 {
+		log.Errorf("%d entries could not be scanned", len(scanErrors))
+		exitCode = 1
+	}
+}
+
+// printProgress prints the scan progress to stderr. If inPlace is set, it
+// overwrites the current line, leaving the last line in place once the scan
+// is done; otherwise every event gets a line of its own, since a file or a
+// pipe cannot overwrite anything.
+func printProgress(ev fs.ProgressEvent, f types.SizeFormatter, inPlace bool) {
+	line := fmt.Sprintf("Scanning: %d entries, %s, %d errors", ev.Entries, f(ev.Bytes), ev.Errors)
+	if !ev.Done && ev.CurrentDir != "" {
+		line += " - " + ev.CurrentDir
+	}
+	if !inPlace {
+		fmt.Fprintln(os.Stderr, line)
+		return
+	}
+	// \x1b[K clears the rest of a longer previous line.
+	fmt.Fprintf(os.Stderr, "\r%s\x1b[K", line)
+	if ev.Done {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// isTerminal reports whether f is a terminal, which understands ANSI escape
+// codes.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// printIgnoreStats prints a table of the ignore rules with what each of them
+// excluded.
+func printIgnoreStats(stats []fs.RuleStats, f types.SizeFormatter) {
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tFILE\tFILES\tFOLDERS\tSIZE ON DISK")
+	for _, rs := range stats {
+		fmt.Fprintf(w, "%s\t%s:%d\t%d\t%d\t%s\n", rs.Rule.Pattern, rs.Rule.File, rs.Rule.Line, rs.Files, rs.Dirs, f(rs.SizeOnDisk))
+	}
+	if len(stats) == 0 {
+		fmt.Fprintln(w, "(no ignore rules)")
+	}
+	w.Flush()
+}
+
+// printScanErrors lists the entries that could not be scanned to stderr.
+func printScanErrors(errs []fs.ScanError) {
+	if len(errs) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%d entries could not be scanned:\n", len(errs))
+	for _, e := range errs {
+		fmt.Fprintf(os.Stderr, "  %v\n", e)
 	}
 }
 
@@ -136,5 +335,69 @@ func getIgnoreChecker() (*local.IgnoreChecker, error) {
 			ignoreCheckerOpts = append(ignoreCheckerOpts, local.WithRuleFile(path))
 		}
 	}
+	if len(excludes) > 0 {
+		ignoreCheckerOpts = append(ignoreCheckerOpts, local.WithRules("-exclude", excludes...))
+	}
 	return local.NewIgnoreChecker(ignoreCheckerOpts...)
 }
+
+// getFilter returns the filter given by the command line, with ages counted
+// back from now.
+func getFilter(now time.Time) (*fs.Filter, error) {
+	filter := &fs.Filter{}
+	if len(includes) > 0 {
+		ic, err := local.CompileRules("-include", includes...)
+		if err != nil {
+			return nil, err
+		}
+		filter.Include = ic
+	}
+	if *olderThan != "" {
+		age, err := internal.ParseAge(*olderThan)
+		if err != nil {
+			return nil, err
+		}
+		filter.ModifiedBefore = now.Add(-age)
+	}
+	if *newerThan != "" {
+		age, err := internal.ParseAge(*newerThan)
+		if err != nil {
+			return nil, err
+		}
+		filter.ModifiedAfter = now.Add(-age)
+	}
+	if *fileTypes != "" {
+		types, err := fs.ParseFileTypes(*fileTypes)
+		if err != nil {
+			return nil, err
+		}
+		filter.Types = types
+	}
+	if *owners != "" {
+		for _, name := range strings.Split(*owners, ",") {
+			uid, err := lookupUID(strings.TrimSpace(name))
+			if err != nil {
+				return nil, err
+			}
+			filter.Owners = append(filter.Owners, uid)
+		}
+	}
+	filter.MinFiles = *minFiles
+	return filter, nil
+}
+
+// lookupUID returns the user id of the user with the given name or id.
+func lookupUID(name string) (uint32, error) {
+	if uid, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(uid), nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("user %s has no numeric user id: %s", name, u.Uid)
+	}
+	return uint32(uid), nil
+}
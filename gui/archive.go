@@ -0,0 +1,126 @@
+package gui
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/rivo/tview"
+
+	"go.sazak.io/gls/internal/archive"
+	"go.sazak.io/gls/internal/journal"
+	"go.sazak.io/gls/internal/types"
+	"go.sazak.io/gls/log"
+)
+
+// isUnexpandedArchive reports whether n is an archive file whose entries
+// can be listed on demand.
+func isUnexpandedArchive(n *types.Node) bool {
+	return !n.IsDir && !n.Virtual && n.Archive == "" && n.Mode.IsRegular() &&
+		archive.Format(n.Name) != "" && !currBuilder.Offline() && !n.IsHidden()
+}
+
+// expandArchiveNode reads the entries of the archive shown by tnode in the
+// background, and shows them as its children.
+func expandArchiveNode(app *tview.Application, tnode *tview.TreeNode) {
+	node := originalNode(tnode.GetReference().(*types.Node))
+	if node == nil {
+		setError("Could not find the hovered node in the scanned tree")
+		return
+	}
+	relPath := node.RelativePath(currPath)
+	setInfo(fmt.Sprintf("Reading archive %s...", relPath))
+	go func() {
+		err := currBuilder.ExpandArchive(context.Background(), node)
+		app.QueueUpdateDraw(func() {
+			if err != nil {
+				log.Errorf("Could not read archive %q: %v", relPath, err)
+				setError(fmt.Sprintf("Could not read archive %q: %v", relPath, err))
+				return
+			}
+			// The tree view may show a search result, whose nodes are copies.
+			ref := tnode.GetReference().(*types.Node)
+			ref.Archive, ref.UncompressedSize = node.Archive, node.UncompressedSize
+			tnode.SetText(ref.InfoWithSizeFormatter(currSizeFormatter))
+			tnode.ClearChildren()
+			for _, c := range node.Children {
+				tnode.AddChild(constructTViewTreeFromNodeWithFormatter(c, currSizeFormatter))
+			}
+			tnode.SetExpanded(true)
+			if currTreeView.GetCurrentNode() == tnode {
+				updateFileInfoTab(app, ref)
+			}
+			setInfo(fmt.Sprintf("Read %d files of archive %s", node.FileCount()-1, relPath))
+		})
+	}()
+}
+
+// showCreateArchiveForm asks for the path of a new archive, and writes the
+// marked files and folders, or the hovered one if nothing is marked, into
+// it in the background. The format follows the extension of the path.
+func showCreateArchiveForm(app *tview.Application) {
+	nodes := selectedNodes()
+	if len(nodes) == 0 {
+		showMessage(app, "Could not find the hovered node in the scanned tree", nil)
+		return
+	}
+	if isBusy(app) {
+		return
+	}
+	first := nodes[0].RelativePath(currPath)
+	name := "archive.zip"
+	if len(nodes) == 1 {
+		name = filepath.Base(first) + ".zip"
+	}
+	form := tview.NewForm().
+		AddInputField("Archive", filepath.Join(filepath.Dir(first), name), 40, nil, nil)
+	form.AddButton("Create", func() {
+		isFormInputActive = false
+		path := form.GetFormItem(0).(*tview.InputField).GetText()
+		if archive.Format(path) == "" {
+			showMessage(app, "The archive name has to end in .zip, .jar, .tar, .tar.gz or .tgz", nil)
+			return
+		}
+		app.SetRoot(currGrid, true).SetFocus(currGrid)
+		createArchive(app, nodes, path)
+	}).
+		AddButton("Cancel", func() {
+			isFormInputActive = false
+			app.SetRoot(currGrid, true).SetFocus(currGrid)
+		})
+	form.SetBorder(true).SetTitle(fmt.Sprintf(" Archive %s ", describeNodes(nodes)))
+	isFormInputActive = true
+	app.SetRoot(form, true).SetFocus(form)
+}
+
+// createArchive writes nodes into a new archive at path in the background,
+// and adds it to the tree if it is below the scanned folder.
+func createArchive(app *tview.Application, nodes []*types.Node, path string) {
+	srcs := make([]string, len(nodes))
+	for i, n := range nodes {
+		srcs[i] = n.RelativePath(currPath)
+	}
+	what := describeNodes(nodes)
+	unmarkAll(app)
+	busyWith = fmt.Sprintf("Creating archive %s", path)
+	setInfo(fmt.Sprintf("Creating archive %s of %s...", path, what))
+	go func() {
+		err := archive.Create(path, srcs)
+		app.QueueUpdateDraw(func() {
+			busyWith = ""
+			if err != nil {
+				log.Errorf("Could not create archive %q: %v", path, err)
+				setError(fmt.Sprintf("Could not create archive %q: %v", path, err))
+				showMessage(app, fmt.Sprintf("Could not create archive %q: %v", path, err), nil)
+				return
+			}
+			log.Infof("Created archive %s of %s", path, what)
+			recordOp(journal.Created(path))
+			if err := addPathToTree(path); err != nil {
+				log.Errorf("Could not add the archive %q to the tree: %v", path, err)
+			}
+			showChangedTree(app)
+			setInfo(fmt.Sprintf("Created archive %s of %s. Press z to undo", path, what))
+		})
+	}()
+}
@@ -0,0 +1,117 @@
+package gui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rivo/tview"
+
+	"go.sazak.io/gls/internal/types"
+	"go.sazak.io/gls/log"
+)
+
+// maxShownErrors is the number of errors listed after a file operation.
+// All of them are logged.
+const maxShownErrors = 5
+
+// busyWith describes the file operation running in the background, if any.
+// Only one runs at a time, so that they do not get in each other's way.
+var busyWith string
+
+// isBusy reports whether a file operation is running in the background, and
+// asks to wait for it if so.
+func isBusy(app *tview.Application) bool {
+	if busyWith == "" {
+		return false
+	}
+	showMessage(app, fmt.Sprintf("%s, please wait until it is done", busyWith), nil)
+	return true
+}
+
+// batchItem is a file or folder that a batch command acts on. The path is
+// read from the tree before the command runs in the background.
+type batchItem struct {
+	node  *types.Node
+	path  string
+	isDir bool
+}
+
+func batchItems(nodes []*types.Node) []batchItem {
+	items := make([]batchItem, len(nodes))
+	for i, n := range nodes {
+		items[i] = batchItem{node: n, path: n.RelativePath(currPath), isDir: n.IsDir}
+	}
+	return items
+}
+
+// runBatch runs do for each of items in the background, one after the
+// other, showing the progress in the log view. do returns a function that
+// brings the tree up to date, which is called on the UI goroutine, and may
+// be there even if do failed half way. The marks are cleared, since they
+// may not be there anymore, and at the end the changed tree is shown along
+// with done or the errors.
+func runBatch(app *tview.Application, doing string, items []batchItem, do func(it batchItem) (func(), error), done string) {
+	unmarkAll(app)
+	busyWith = fmt.Sprintf("%s %d files and folders", doing, len(items))
+	go func() {
+		var errs []string
+		for i, it := range items {
+			path := it.path
+			app.QueueUpdateDraw(func() {
+				setInfo(fmt.Sprintf("%s %s (%d of %d)...", doing, path, i+1, len(items)))
+			})
+			update, err := do(it)
+			if err != nil {
+				log.Errorf("%s %q failed: %v", doing, it.path, err)
+				errs = append(errs, fmt.Sprintf("%s: %v", it.path, err))
+			}
+			if update != nil {
+				app.QueueUpdateDraw(update)
+			}
+		}
+		app.QueueUpdateDraw(func() {
+			busyWith = ""
+			showChangedTree(app)
+			if len(errs) == 0 {
+				setInfo(done)
+				return
+			}
+			setError(fmt.Sprintf("%s failed for %d of %d files and folders", doing, len(errs), len(items)))
+			showMessage(app, errorsMessage("Failed for:", errs), nil)
+		})
+	}()
+}
+
+// errorsMessage lists the first errors of a file operation below title.
+func errorsMessage(title string, errs []string) string {
+	var sb strings.Builder
+	sb.WriteString(title + "\n")
+	for i, e := range errs {
+		if i == maxShownErrors {
+			fmt.Fprintf(&sb, "\n... and %d more, see the log file", len(errs)-i)
+			break
+		}
+		fmt.Fprintf(&sb, "\n%s", e)
+	}
+	return sb.String()
+}
+
+// makeDestDir creates the destination folder dstDir of a copy or a move if
+// it does not exist, and reports whether it is a folder.
+func makeDestDir(app *tview.Application, dstDir string) bool {
+	if dstDir == "" {
+		showMessage(app, "Destination folder cannot be empty", nil)
+		return false
+	}
+	if err := os.MkdirAll(dstDir, os.ModePerm); err != nil {
+		log.Errorf("Could not create the destination folder %q: %v", dstDir, err)
+		showMessage(app, fmt.Sprintf("Could not create the destination folder %q: %v", dstDir, err), nil)
+		return false
+	}
+	if fi, err := os.Stat(dstDir); err != nil || !fi.IsDir() {
+		showMessage(app, fmt.Sprintf("%q is not a folder", dstDir), nil)
+		return false
+	}
+	return true
+}
@@ -6,6 +6,9 @@ import (
 	"os"
 	"strconv"
 	"strings"
+
+	"go.sazak.io/gls/internal"
+	"go.sazak.io/gls/internal/cp"
 )
 
 type Shortcut struct {
@@ -26,8 +29,21 @@ var (
 	SearchFormTitleColor = tcell.ColorLightSkyBlue
 	UnmarkedFileColor    = tcell.ColorWhite
 	MarkedFileColor      = tcell.ColorRed
+	GrownColor           = tcell.ColorOrangeRed
+	ShrunkColor          = tcell.ColorGreenYellow
+	HiddenColor          = tcell.ColorDimGray
 
 	FileInfoTabAttrWidth = 20
+
+	// RemoveConfirmFiles and RemoveConfirmSize are the number of files and
+	// the size on disk from which the name of a folder has to be typed in to
+	// remove it. Zero turns the check off.
+	RemoveConfirmFiles int64 = 1000
+	RemoveConfirmSize  int64 = 1 << 30
+
+	// CopyConflict is what copying does by default with files and folders
+	// in the way, which can be changed in the copy form.
+	CopyConflict = cp.Rename
 )
 
 var (
@@ -70,7 +86,7 @@ var (
 		},
 		{
 			Key:     "p",
-			Command: "open++",
+			Command: "open marked with",
 		},
 		{
 			Key:     "BS",
@@ -100,6 +116,54 @@ var (
 			Key:     "d",
 			Command: "cp/paste marked files and folders",
 		},
+		{
+			Key:     "a",
+			Command: "archive marked",
+		},
+		{
+			Key:     "F2",
+			Command: "rename",
+		},
+		{
+			Key:     "F6",
+			Command: "move marked files and folders",
+		},
+		{
+			Key:     "f",
+			Command: "refresh directory",
+		},
+		{
+			Key:     "w",
+			Command: "scan errors",
+		},
+		{
+			Key:     "h",
+			Command: "show/summarize hidden",
+		},
+		{
+			Key:     "i",
+			Command: "ignore rule stats",
+		},
+		{
+			Key:     "b",
+			Command: "cycle sort order",
+		},
+		{
+			Key:     "t",
+			Command: "trash",
+		},
+		{
+			Key:     "z",
+			Command: "undo",
+		},
+		{
+			Key:     "y",
+			Command: "redo",
+		},
+		{
+			Key:     "j",
+			Command: "operation history",
+		},
 	}
 )
 
@@ -158,6 +222,15 @@ func readGLSRCFile(path string) error {
 		if strings.EqualFold(key, "MarkedFileColor") {
 			MarkedFileColor = tcell.GetColor(val)
 		}
+		if strings.EqualFold(key, "GrownColor") {
+			GrownColor = tcell.GetColor(val)
+		}
+		if strings.EqualFold(key, "ShrunkColor") {
+			ShrunkColor = tcell.GetColor(val)
+		}
+		if strings.EqualFold(key, "HiddenColor") {
+			HiddenColor = tcell.GetColor(val)
+		}
 		if strings.EqualFold(key, "FileInfoTabAttrWidth") {
 			fileInfoTabAttrWidth, err := strconv.Atoi(val)
 			if err != nil {
@@ -165,6 +238,27 @@ func readGLSRCFile(path string) error {
 			}
 			FileInfoTabAttrWidth = fileInfoTabAttrWidth
 		}
+		if strings.EqualFold(key, "RemoveConfirmFiles") {
+			removeConfirmFiles, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				continue
+			}
+			RemoveConfirmFiles = removeConfirmFiles
+		}
+		if strings.EqualFold(key, "RemoveConfirmSize") {
+			byteSize, mult, err := internal.ParseByteSize(val)
+			if err != nil {
+				continue
+			}
+			RemoveConfirmSize = int64(byteSize) * mult
+		}
+		if strings.EqualFold(key, "CopyConflict") {
+			conflict, err := cp.ParseConflict(val)
+			if err != nil {
+				continue
+			}
+			CopyConflict = conflict
+		}
 	}
 	return nil
 }
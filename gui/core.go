@@ -1,11 +1,13 @@
 package gui
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gdamore/tcell/v2"
@@ -14,34 +16,53 @@ import (
 	"github.com/rivo/tview"
 
 	"go.sazak.io/gls/internal"
+	"go.sazak.io/gls/internal/fs"
 	"go.sazak.io/gls/internal/info"
+	"go.sazak.io/gls/internal/journal"
 	"go.sazak.io/gls/internal/types"
+	"go.sazak.io/gls/internal/watch"
 	"go.sazak.io/gls/log"
 )
 
+const (
+	// watchDebounce is how long changes are collected before the tree is
+	// updated, so a burst of writes to a file only causes one update.
+	watchDebounce = 500 * time.Millisecond
+)
+
 var (
-	currGrid          *tview.Grid                  = nil
-	currTreeView      *tview.TreeView              = nil
-	currFileInfoTab   *tview.Table                 = nil
-	currLastLogView   *tview.TextView              = nil
-	currPath          string                       = ""
-	currSizeFormatter types.SizeFormatter          = nil
-	originalRootNode  *types.Node                  = nil
-	isFormInputActive bool                         = false
-	markedFiles       map[*tview.TreeNode]struct{} = make(map[*tview.TreeNode]struct{})
+	currGrid           *tview.Grid         = nil
+	currTreeView       *tview.TreeView     = nil
+	currFileInfoTab    *tview.Table        = nil
+	currLastLogView    *tview.TextView     = nil
+	currMarkStatusView *tview.TextView     = nil
+	currLoadingView    *tview.TextView     = nil
+	cancelScan         context.CancelFunc  = nil
+	currBuilder        *fs.FileTreeBuilder = nil
+	currPath           string              = ""
+	currSizeFormatter  types.SizeFormatter = nil
+	originalRootNode   *types.Node         = nil
+	isFormInputActive  bool                = false
 )
 
-func GetApp(path string, f types.SizeFormatter) *tview.Application {
+// GetApp creates the application showing the loading page. cancel is called
+// when the user quits, so that a still running scan is aborted.
+func GetApp(path string, f types.SizeFormatter, cancel context.CancelFunc) *tview.Application {
 	currPath = path
 	currSizeFormatter = f
+	cancelScan = cancel
 	app := tview.NewApplication()
 	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		if event.Key() == tcell.KeyCtrlC {
-			app.Stop()
+			stopApp(app)
 		}
 		if !isFormInputActive {
 			if event.Rune() == 'q' || event.Rune() == 'Q' || event.Key() == tcell.KeyEscape {
-				app.Stop()
+				stopApp(app)
+			}
+			// The tree view does not exist until the scan is finished.
+			if currTreeView == nil {
+				return event
 			}
 			if event.Rune() == 'c' || event.Rune() == 'C' {
 				currTreeView.GetRoot().CollapseAll()
@@ -64,6 +85,58 @@ func GetApp(path string, f types.SizeFormatter) *tview.Application {
 			if event.Rune() == 'u' || event.Rune() == 'U' {
 				unmarkAll(app)
 			}
+			if event.Rune() == 'w' || event.Rune() == 'W' {
+				showScanErrors(app)
+				return nil
+			}
+			if event.Rune() == 'h' || event.Rune() == 'H' {
+				toggleHidden(app)
+				return nil
+			}
+			if event.Rune() == 'i' || event.Rune() == 'I' {
+				showIgnoreStats(app)
+				return nil
+			}
+			if event.Rune() == 'b' || event.Rune() == 'B' {
+				cycleSortKey(app)
+				return nil
+			}
+			if event.Rune() == 'j' || event.Rune() == 'J' {
+				showHistory(app)
+				return nil
+			}
+			// Commands below here touch the file system, which the tree of
+			// a loaded snapshot need not match.
+			if currBuilder.Offline() {
+				if isFileSystemCommand(event) {
+					showMessage(app, "This command is not available on a tree loaded from a snapshot", nil)
+				}
+				return event
+			}
+			if event.Rune() == 't' || event.Rune() == 'T' {
+				showTrash(app)
+				return nil
+			}
+			if event.Rune() == 'z' || event.Rune() == 'Z' {
+				undoLastOp(app)
+				return nil
+			}
+			if event.Rune() == 'y' || event.Rune() == 'Y' {
+				redoLastOp(app)
+				return nil
+			}
+			if currTreeView.GetCurrentNode().GetReference().(*types.Node).Virtual {
+				if isFileSystemCommand(event) {
+					showMessage(app, "This command is not available inside archives", nil)
+				}
+				return event
+			}
+			if n := currTreeView.GetCurrentNode().GetReference().(*types.Node); n.Ghost || n.IsHidden() {
+				if isFileSystemCommand(event) {
+					showMessage(app, "This command is not available on entries hidden by ignore rules or the size threshold", nil)
+				}
+				return event
+			}
 			if event.Rune() == 'n' || event.Rune() == 'N' {
 				createNewFile(app)
 			}
@@ -73,11 +146,22 @@ func GetApp(path string, f types.SizeFormatter) *tview.Application {
 			if event.Rune() == 'd' || event.Rune() == 'D' {
 				duplicateFileAndFolder(app)
 			}
-			// Commands below here are about the current hovered file.
-			if currTreeView == nil {
-				log.Warning("Tree view is nil")
-				return event
+			if event.Rune() == 'a' || event.Rune() == 'A' {
+				showCreateArchiveForm(app)
+				return nil
+			}
+			if event.Key() == tcell.KeyF2 {
+				showRenameForm(app)
+				return nil
 			}
+			if event.Key() == tcell.KeyF6 {
+				showMoveMarkedForm(app)
+				return nil
+			}
+			if event.Rune() == 'f' || event.Rune() == 'F' {
+				refreshHoveredDir(app)
+			}
+			// Commands below here are about the current hovered file.
 			cNode := currTreeView.GetCurrentNode()
 			if event.Rune() == 'o' || event.Rune() == 'O' {
 				relPath := cNode.GetReference().(*types.Node).RelativePath(currPath)
@@ -88,16 +172,23 @@ func GetApp(path string, f types.SizeFormatter) *tview.Application {
 				}
 			}
 			if event.Rune() == 'p' || event.Rune() == 'P' {
-				relPath := cNode.GetReference().(*types.Node).RelativePath(currPath)
-				askOpenFileWithProgram(app, relPath)
+				var paths []string
+				for _, n := range selectedNodes() {
+					paths = append(paths, n.RelativePath(currPath))
+				}
+				askOpenFileWithProgram(app, paths)
 			}
 			if event.Key() == tcell.KeyBackspace || event.Key() == tcell.KeyDEL {
+				if len(markedPaths) > 0 {
+					askRemoveNodes(app, markedNodes())
+					return event
+				}
 				if cNode == currTreeView.GetRoot() {
 					showCannotRemoveRootWarning(app, cNode)
 					return event
 				}
-				if len(cNode.GetChildren()) > 0 {
-					showCannotRemoveFolderWarning(app, cNode)
+				if cNode.GetReference().(*types.Node).IsDir {
+					askRemoveDir(app, cNode)
 					return event
 				}
 				askRemoveFile(app, cNode)
@@ -109,12 +200,41 @@ func GetApp(path string, f types.SizeFormatter) *tview.Application {
 	return app.SetRoot(loadingPage, true).SetFocus(loadingPage)
 }
 
-func LoadTreeView(app *tview.Application, node *types.Node, path string) {
+// isFileSystemCommand reports whether the key event is a command that reads
+// or changes the scanned files.
+func isFileSystemCommand(event *tcell.EventKey) bool {
+	switch event.Key() {
+	case tcell.KeyBackspace, tcell.KeyDEL, tcell.KeyF2, tcell.KeyF6:
+		return true
+	}
+	switch event.Rune() {
+	case 'n', 'N', 'v', 'V', 'd', 'D', 'a', 'A', 'f', 'F', 'o', 'O', 'p', 'P', 't', 'T', 'z', 'Z', 'y', 'Y':
+		return true
+	}
+	return false
+}
+
+func stopApp(app *tview.Application) {
+	if cancelScan != nil {
+		cancelScan()
+	}
+	closeJournal()
+	app.Stop()
+}
+
+// LoadTreeView shows the tree built by b. The builder is also used to
+// refresh parts of the tree later on.
+func LoadTreeView(app *tview.Application, b *fs.FileTreeBuilder, path string) {
+	currBuilder = b
+	currPath = path
+	node := b.Root()
 	lastLogTextView := tview.NewTextView().
 		SetText("OK.").
 		SetTextColor(tcell.ColorWhite).
 		SetWrap(true)
 	currLastLogView = lastLogTextView
+	currMarkStatusView = tview.NewTextView().
+		SetTextColor(MarkedFileColor)
 
 	originalRootNode = node
 	root := constructNativeTree(node)
@@ -123,11 +243,23 @@ func LoadTreeView(app *tview.Application, node *types.Node, path string) {
 		SetRoot(root).
 		SetCurrentNode(root).
 		SetSelectedFunc(func(node *tview.TreeNode) {
+			if node.GetReference().(*types.Node).Ghost {
+				toggleHidden(app)
+				return
+			}
+			if isUnexpandedArchive(node.GetReference().(*types.Node)) {
+				expandArchiveNode(app, node)
+				return
+			}
 			// Collapse if visible, expand if collapsed.
 			node.SetExpanded(!node.IsExpanded())
 		})
+	title := fmt.Sprintf("[ %s ]", path)
+	if b.Offline() {
+		title = fmt.Sprintf("[ %s (snapshot of %s) ]", path, b.ScannedAt().Format(time.RFC1123))
+	}
 	treeView.SetBorder(true).
-		SetTitle(fmt.Sprintf("[ %s ]", path)).
+		SetTitle(title).
 		SetTitleAlign(tview.AlignCenter).
 		SetTitleColor(TreeViewTitleColor)
 	treeView.SetChangedFunc(func(node *tview.TreeNode) {
@@ -155,7 +287,8 @@ func LoadTreeView(app *tview.Application, node *types.Node, path string) {
 
 	grid.AddItem(treeView, 0, 0, 19, 4, 0, 0, true)
 	grid.AddItem(fileInfoTab, 19, 0, 4, 4, 0, 0, false)
-	grid.AddItem(lastLogTextView, 23, 0, 2, 5, 0, 0, false)
+	grid.AddItem(currMarkStatusView, 23, 0, 1, 5, 0, 0, false)
+	grid.AddItem(lastLogTextView, 24, 0, 2, 5, 0, 0, false)
 	grid.AddItem(helpSideBar, 0, 4, 23, 1, 0, 0, false)
 
 	currTreeView = treeView
@@ -163,6 +296,10 @@ func LoadTreeView(app *tview.Application, node *types.Node, path string) {
 	currGrid = grid
 
 	updateFileInfoTab(app, node)
+	updateMarkStatus()
+	if errs := b.ScanErrors(); len(errs) > 0 {
+		setError(fmt.Sprintf("%d entries could not be scanned, so the sizes may be too small. Press w to list them", len(errs)))
+	}
 
 	app.SetRoot(grid, true).SetFocus(grid).Draw()
 }
@@ -242,12 +379,50 @@ func updateFileInfoTab(app *tview.Application, node *types.Node) {
 	sizeAttrCell := tview.NewTableCell("Size").
 		SetMaxWidth(FileInfoTabAttrWidth).
 		SetTextColor(FileInfoAttrColor)
-	sizeValueCell := tview.NewTableCell(fmt.Sprintf("%s real, %s on disk (%d)", currSizeFormatter(node.Size), currSizeFormatter(node.SizeOnDisk), node.Size)).
+	sizeText := fmt.Sprintf("%s real, %s on disk (%d)", currSizeFormatter(node.Size), currSizeFormatter(node.SizeOnDisk), node.Size)
+	if node.Virtual {
+		sizeText = fmt.Sprintf("%s uncompressed, %s compressed (%d)", currSizeFormatter(node.Size), currSizeFormatter(node.SizeOnDisk), node.Size)
+	} else if node.Archive != "" {
+		sizeText += fmt.Sprintf(", %s uncompressed", currSizeFormatter(node.UncompressedSize))
+	}
+	sizeValueCell := tview.NewTableCell(sizeText).
+		SetTextColor(FileInfoValueColor)
+	usageAttrCell := tview.NewTableCell("Usage").
+		SetMaxWidth(FileInfoTabAttrWidth).
+		SetTextColor(FileInfoAttrColor)
+	usageValueCell := tview.NewTableCell(fmt.Sprintf("%s unique, %s apparent", currSizeFormatter(node.UniqueSizeOnDisk()), currSizeFormatter(node.ApparentSizeOnDisk))).
+		SetTextColor(FileInfoValueColor)
+	linksAttrCell := tview.NewTableCell("Hard links").
+		SetMaxWidth(FileInfoTabAttrWidth).
+		SetTextColor(FileInfoAttrColor)
+	links := fmt.Sprint(node.Links)
+	if node.DuplicateLink {
+		links += " (counted at another link)"
+	}
+	linksValueCell := tview.NewTableCell(links).
+		SetTextColor(FileInfoValueColor)
+	scanErrorAttrCell := tview.NewTableCell("Scan error").
+		SetMaxWidth(FileInfoTabAttrWidth).
+		SetTextColor(FileInfoAttrColor)
+	scanError := "none"
+	if node.ScanError != "" {
+		scanError = node.ScanError
+	}
+	scanErrorValueCell := tview.NewTableCell(scanError).
 		SetTextColor(FileInfoValueColor)
 	typeAttrCell := tview.NewTableCell("Type").
 		SetMaxWidth(FileInfoTabAttrWidth).
 		SetTextColor(FileInfoAttrColor)
-	fileType, err := node.GetFileType(currPath)
+	var fileType string
+	var err error
+	if node.Ghost {
+		fileType = "entries hidden by ignore rules or the size threshold"
+	} else if currBuilder != nil && currBuilder.Offline() && !node.IsDir && !node.IsSymlink() {
+		// The file may not exist anymore, or be a different one.
+		fileType = "file"
+	} else {
+		fileType, err = node.GetFileType(currPath)
+	}
 	if err != nil {
 		log.Errorf("Failed to get type for file %q: %v", node.Name, err)
 		fileType = fmt.Sprintf("<error: %v>", err)
@@ -273,16 +448,43 @@ func updateFileInfoTab(app *tview.Application, node *types.Node) {
 		SetCell(4, 0, permAttrCell).
 		SetCell(4, 1, permValueCell).
 		SetCell(5, 0, modifiedAttrCell).
-		SetCell(5, 1, modifiedValueCell)
+		SetCell(5, 1, modifiedValueCell).
+		SetCell(6, 0, usageAttrCell).
+		SetCell(6, 1, usageValueCell).
+		SetCell(7, 0, linksAttrCell).
+		SetCell(7, 1, linksValueCell).
+		SetCell(8, 0, scanErrorAttrCell).
+		SetCell(8, 1, scanErrorValueCell)
+	updateStatsRows(node)
 }
 
 func createLoadingPage(app *tview.Application) tview.Primitive {
 	loadingPage := tview.NewTextView().
-		SetText("Loading...").
+		SetText(loadingText(fs.ProgressEvent{})).
 		SetTextAlign(tview.AlignCenter)
+	loadingPage.SetBorder(true).
+		SetTitle(fmt.Sprintf("[ %s ]", info.ProjectNameWithVersion())).
+		SetTitleColor(GridTitleColor)
+	currLoadingView = loadingPage
 	return loadingPage
 }
 
+// UpdateLoadingPage shows the given scan progress on the loading page. It is
+// safe to call from any goroutine.
+func UpdateLoadingPage(app *tview.Application, ev fs.ProgressEvent) {
+	if currLoadingView == nil {
+		return
+	}
+	app.QueueUpdateDraw(func() {
+		currLoadingView.SetText(loadingText(ev))
+	})
+}
+
+func loadingText(ev fs.ProgressEvent) string {
+	return fmt.Sprintf("\n\nScanning %s\n\n%d entries, %s, %d errors\n\n%s\n\nPress q to cancel",
+		currPath, ev.Entries, currSizeFormatter(ev.Bytes), ev.Errors, ev.CurrentDir)
+}
+
 func constructNativeTree(node *types.Node) *tview.TreeNode {
 	t := constructTViewTreeFromNodeWithFormatter(node, currSizeFormatter)
 	setInfo(fmt.Sprintf("Constructed tree with %d files", node.FileCount()))
@@ -290,30 +492,217 @@ func constructNativeTree(node *types.Node) *tview.TreeNode {
 }
 
 func constructTViewTreeFromNodeWithFormatter(node *types.Node, f types.SizeFormatter) *tview.TreeNode {
+	key := ""
+	if len(markedPaths) > 0 {
+		key = node.RelativePath(currPath)
+	}
 	treeNode := tview.NewTreeNode(node.InfoWithSizeFormatter(f)).
 		SetReference(node).
 		SetSelectable(true).
-		SetColor(UnmarkedFileColor)
-	if node.IsDir {
-		treeNode.SetColor(DirectoryColor).
-			SetExpanded(false)
+		SetColor(nodeColor(node, key))
+	if node.IsContainer() {
+		treeNode.SetExpanded(false)
 	}
 	for _, child := range node.Children {
 		treeNode.AddChild(constructTViewTreeFromNodeWithFormatter(child, f))
 	}
+	addHiddenRows(treeNode, node, f)
 	return treeNode
 }
 
-func showCannotRemoveFolderWarning(app *tview.Application, tnode *tview.TreeNode) {
-	modal := tview.NewModal().
-		SetText(fmt.Sprintf("Cannot remove folder %q", tnode.GetReference().(*types.Node).Name)).
-		AddButtons([]string{"OK"}).
-		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
-			if buttonLabel == "OK" {
-				app.SetRoot(currGrid, true).SetFocus(currGrid)
+// nodeKey identifies a tree view node by the path of the file it shows, which
+// stays the same when the tree view is rebuilt.
+func nodeKey(tnode *tview.TreeNode) string {
+	return tnode.GetReference().(*types.Node).RelativePath(currPath)
+}
+
+// reloadTreeView rebuilds the tree view from root, keeping the expanded
+// nodes and the cursor where they were.
+func reloadTreeView(app *tview.Application, root *types.Node) {
+	expanded := make(map[string]bool)
+	current := ""
+	if oldRoot := currTreeView.GetRoot(); oldRoot != nil {
+		oldRoot.Walk(func(tnode, _ *tview.TreeNode) bool {
+			if tnode.IsExpanded() {
+				expanded[nodeKey(tnode)] = true
 			}
+			return true
 		})
-	app.SetRoot(modal, true).SetFocus(modal)
+	}
+	if cNode := currTreeView.GetCurrentNode(); cNode != nil {
+		current = nodeKey(cNode)
+	}
+	newRoot := constructNativeTree(root)
+	newRoot.SetExpanded(true)
+	newCurrent := newRoot
+	newRoot.Walk(func(tnode, _ *tview.TreeNode) bool {
+		key := nodeKey(tnode)
+		if expanded[key] {
+			tnode.SetExpanded(true)
+		}
+		if key == current {
+			newCurrent = tnode
+		}
+		return true
+	})
+	currTreeView.SetRoot(newRoot).
+		SetCurrentNode(newCurrent)
+	updateFileInfoTab(app, newCurrent.GetReference().(*types.Node))
+	updateMarkStatus()
+}
+
+// originalNode returns the node of the scanned tree at the same path as n,
+// which may be part of a search result tree.
+func originalNode(n *types.Node) *types.Node {
+	var names []string
+	for ; n.Parent != nil; n = n.Parent {
+		names = append([]string{n.Name}, names...)
+	}
+	return originalRootNode.Lookup(names...)
+}
+
+// refreshHoveredDir rescans the hovered directory, or the directory of the
+// hovered file, and swaps the result into the tree.
+func refreshHoveredDir(app *tview.Application) {
+	node := originalNode(currTreeView.GetCurrentNode().GetReference().(*types.Node))
+	if node == nil {
+		setError("Could not find the hovered node in the scanned tree")
+		return
+	}
+	if !node.IsDir && node.Parent != nil {
+		node = node.Parent
+	}
+	relPath := node.RelativePath(currPath)
+	setInfo(fmt.Sprintf("Refreshing %s...", relPath))
+	go func() {
+		c, err := currBuilder.PrepareChange(context.Background(), relPath)
+		app.QueueUpdateDraw(func() {
+			if err == nil && c != nil {
+				err = c.Apply()
+			}
+			if err != nil {
+				log.Errorf("Could not refresh %q: %v", relPath, err)
+				setError(fmt.Sprintf("Could not refresh %q: %v", relPath, err))
+				return
+			}
+			originalRootNode = currBuilder.Root()
+			reloadTreeView(app, originalRootNode)
+			setInfo(fmt.Sprintf("Refreshed %s", relPath))
+		})
+	}()
+}
+
+// StartWatching keeps the tree view and the file info tab up to date with
+// changes to the scanned files. Directories are polled every pollInterval
+// if the platform offers no file system notifications.
+func StartWatching(app *tview.Application, pollInterval time.Duration) error {
+	w, err := watch.New(watchedDirs(originalRootNode), pollInterval)
+	if err != nil {
+		return err
+	}
+	go watchTree(app, w)
+	return nil
+}
+
+func watchedDirs(root *types.Node) []string {
+	var dirs []string
+	var visit func(n *types.Node)
+	visit = func(n *types.Node) {
+		if !n.IsDir || n.IsSymlink() {
+			return
+		}
+		dirs = append(dirs, n.RelativePath(currPath))
+		for _, c := range n.Children {
+			visit(c)
+		}
+	}
+	visit(root)
+	return dirs
+}
+
+func watchTree(app *tview.Application, w watch.Watcher) {
+	defer w.Close()
+	pending := make(map[string]struct{})
+	var flush <-chan time.Time
+	for {
+		select {
+		case path, ok := <-w.Events():
+			if !ok {
+				return
+			}
+			pending[path] = struct{}{}
+		case err, ok := <-w.Errors():
+			if !ok {
+				return
+			}
+			if err != watch.ErrOverflow {
+				log.Errorf("Error while watching files: %v", err)
+				continue
+			}
+			log.Warning("Lost track of file changes, rescanning the whole tree")
+			pending[currPath] = struct{}{}
+		case <-flush:
+			flush = nil
+			applyWatchedChanges(app, w, pending)
+			pending = make(map[string]struct{})
+			continue
+		}
+		if flush == nil {
+			flush = time.After(watchDebounce)
+		}
+	}
+}
+
+// applyWatchedChanges scans the changed paths and puts the results into the
+// tree. Paths below another changed directory are covered by the scan of
+// that directory.
+func applyWatchedChanges(app *tview.Application, w watch.Watcher, pending map[string]struct{}) {
+	paths := make([]string, 0, len(pending))
+	for p := range pending {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	var changes []*fs.Change
+	for i, p := range paths {
+		if i > 0 && (p == paths[i-1] || strings.HasPrefix(p, paths[i-1]+"/")) {
+			paths[i] = paths[i-1]
+			continue
+		}
+		c, err := currBuilder.PrepareChange(context.Background(), p)
+		if err != nil {
+			log.Errorf("Could not scan changed path %q: %v", p, err)
+			continue
+		}
+		if c != nil {
+			changes = append(changes, c)
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+	app.QueueUpdateDraw(func() {
+		for _, c := range changes {
+			if err := c.Apply(); err != nil {
+				log.Errorf("Could not update the tree: %v", err)
+				continue
+			}
+			if fresh := c.Fresh(); fresh != nil {
+				for _, d := range watchedDirs(fresh) {
+					if err := w.Add(d); err != nil {
+						log.Warningf("Could not watch %q: %v", d, err)
+					}
+				}
+			}
+		}
+		isSearchResult := currTreeView.GetRoot().GetReference().(*types.Node) != originalRootNode
+		originalRootNode = currBuilder.Root()
+		if isSearchResult {
+			setInfo(fmt.Sprintf("%d files or folders changed, restore the tree to see them", len(changes)))
+			return
+		}
+		reloadTreeView(app, originalRootNode)
+		setInfo(fmt.Sprintf("Updated %d changed files or folders", len(changes)))
+	})
 }
 
 func showCannotRemoveRootWarning(app *tview.Application, tnode *tview.TreeNode) {
@@ -329,21 +718,52 @@ func showCannotRemoveRootWarning(app *tview.Application, tnode *tview.TreeNode)
 }
 
 func askRemoveFile(app *tview.Application, tnode *tview.TreeNode) {
+	if isBusy(app) {
+		return
+	}
 	node := tnode.GetReference().(*types.Node)
+	text := fmt.Sprintf("Move %q to the trash?", node.RelativePath(currPath))
+	if permanentDelete {
+		text = fmt.Sprintf("Are you sure to remove %q for good?", node.RelativePath(currPath))
+	}
 	modal := tview.NewModal().
-		SetText(fmt.Sprintf("Are you sure to remove %q?", node.RelativePath(currPath))).
+		SetText(text).
 		AddButtons([]string{"Cancel", "Yes"}).
 		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
 			if buttonLabel == "Yes" {
-				if err := tnode.GetReference().(*types.Node).Remove(currPath); err != nil {
+				orig := originalNode(node)
+				if orig == nil {
+					showMessage(app, fmt.Sprintf("Cannot remove file %q: it is not part of the scanned tree", node.Name), nil)
+					return
+				}
+				if !permanentDelete {
+					trashNode(app, orig)
+					return
+				}
+				relPath := orig.RelativePath(currPath)
+				c, errs, err := currBuilder.RemoveTree(context.Background(), orig, nil)
+				for _, e := range errs {
+					log.Errorf("Could not remove %v", e)
+				}
+				if err == nil && len(errs) > 0 {
+					err = errs[0]
+				}
+				if c != nil {
+					if applyErr := c.Apply(); applyErr != nil {
+						log.Errorf("Could not update the tree after removing %q: %v", relPath, applyErr)
+					}
+				}
+				if err != nil {
 					log.Errorf("Could not remove file %q: %v", node.Name, err)
+					if c != nil {
+						showChangedTree(app)
+					}
 					showMessage(app, fmt.Sprintf("Cannot remove file %q: %v", node.Name, err.Error()), nil)
 					return
 				}
-				newRoot := constructNativeTree(currTreeView.GetRoot().GetReference().(*types.Node))
-				newRoot.SetExpanded(true)
-				currTreeView.SetRoot(newRoot).
-					SetCurrentNode(newRoot)
+				recordOp(journal.Removed(relPath))
+				showChangedTree(app)
+				return
 			}
 			app.SetRoot(currGrid, true).SetFocus(currGrid)
 		})
@@ -436,7 +856,9 @@ func restoreOriginalRoot(app *tview.Application) {
 	app.SetRoot(currGrid, true).SetFocus(currGrid)
 }
 
-func askOpenFileWithProgram(app *tview.Application, relPath string) {
+// askOpenFileWithProgram asks for a program, and opens the files at paths
+// with it.
+func askOpenFileWithProgram(app *tview.Application, paths []string) {
 	form := tview.NewForm().
 		AddInputField("Executable", "", 32, nil, nil)
 	form.AddButton("Open", func() {
@@ -448,10 +870,10 @@ func askOpenFileWithProgram(app *tview.Application, relPath string) {
 			showMessage(app, "Please enter an executable name", nil)
 			return
 		}
-		log.Infof("Opening %q with %q", relPath, program)
-		if err := internal.OpenFileWithProgram(relPath, program); err != nil {
-			log.Errorf("Could not open file %q with %q: %v", relPath, err)
-			setError(fmt.Sprintf("Could not open file %q with %q: %v", relPath, program, err))
+		log.Infof("Opening %q with %q", paths, program)
+		if err := internal.OpenFilesWithProgram(program, paths...); err != nil {
+			log.Errorf("Could not open files %q with %q: %v", paths, program, err)
+			setError(fmt.Sprintf("Could not open files %q with %q: %v", paths, program, err))
 			app.SetRoot(currGrid, true).SetFocus(currGrid)
 		}
 	})
@@ -459,8 +881,12 @@ func askOpenFileWithProgram(app *tview.Application, relPath string) {
 		isFormInputActive = false
 		app.SetRoot(currGrid, true).SetFocus(currGrid)
 	})
+	title := "Open file with program"
+	if len(paths) > 1 {
+		title = fmt.Sprintf("Open %d files with program", len(paths))
+	}
 	form.SetBorder(true).
-		SetTitle("Open file with program").
+		SetTitle(title).
 		SetTitleAlign(tview.AlignCenter).
 		SetTitleColor(SearchFormTitleColor)
 	isFormInputActive = true
@@ -468,29 +894,10 @@ func askOpenFileWithProgram(app *tview.Application, relPath string) {
 
 }
 
-func markUnmarkFile(app *tview.Application) {
-	cNode := currTreeView.GetCurrentNode()
-	if _, ok := markedFiles[cNode]; ok { // unmark if already marked
-		cNode.SetColor(UnmarkedFileColor)
-		delete(markedFiles, cNode)
-		log.Debugf("Removed node with name %q from marked files", cNode.GetText())
-	} else {
-		cNode.SetColor(MarkedFileColor)
-		markedFiles[cNode] = struct{}{}
-		log.Debugf("Added node with name %q to marked files", cNode.GetText())
-	}
-}
-
-func unmarkAll(app *tview.Application) {
-	for n := range markedFiles {
-		n.SetColor(UnmarkedFileColor)
-		delete(markedFiles, n)
-		log.Debugf("Removed node with name %q from marked files (unmarkAll)", n.GetText())
-	}
-	log.Debugf("markedFiles after unmarkAll: %#v", markedFiles)
-}
-
 func createNewFile(app *tview.Application) {
+	if isBusy(app) {
+		return
+	}
 	var fileName string
 	form := tview.NewForm().
 		AddInputField("File name", "", 20, nil, nil)
@@ -505,7 +912,11 @@ func createNewFile(app *tview.Application) {
 			showMessage(app, "File name cannot be empty", nil)
 			return
 		}
-		node := currTreeView.GetCurrentNode().GetReference().(*types.Node)
+		node := originalNode(currTreeView.GetCurrentNode().GetReference().(*types.Node))
+		if node == nil {
+			showMessage(app, "Could not find the hovered node in the scanned tree", nil)
+			return
+		}
 		if !node.IsDir {
 			node = node.Parent
 		}
@@ -516,10 +927,8 @@ func createNewFile(app *tview.Application) {
 			return
 		}
 		log.Infof("Created file: %s", fileName)
-		newRoot := constructNativeTree(currTreeView.GetRoot().GetReference().(*types.Node))
-		newRoot.SetExpanded(true)
-		currTreeView.SetRoot(newRoot).SetCurrentNode(newRoot)
-		app.SetRoot(currGrid, true).SetFocus(currGrid)
+		recordOp(journal.Created(node.RelativePath(currPath) + "/" + fileName))
+		showChangedTree(app)
 	}).
 		AddButton("Cancel", func() {
 			isFormInputActive = false
@@ -543,113 +952,83 @@ func openVIM(app *tview.Application) {
 	})
 }
 
-// duplicateFileAndFolder copies and pastes the marked files and folders to
-// given destination path.
+// duplicateFileAndFolder copies and pastes the marked files and folders, or
+// the hovered one if nothing is marked, to given destination path.
 func duplicateFileAndFolder(app *tview.Application) {
-	cNode := currTreeView.GetCurrentNode()
-	if cNode == nil {
+	nodes := selectedNodes()
+	if len(nodes) == 0 {
+		showMessage(app, "Could not find the hovered node in the scanned tree", nil)
+		return
+	}
+	if isBusy(app) {
 		return
 	}
-	srcFileName := cNode.GetReference().(*types.Node).Name
-	srcPath := cNode.GetReference().(*types.Node).RelativePath(currPath)
-
 	form := tview.NewForm().
-		AddInputField("Destination srcPath", "", 30, nil, nil)
+		AddInputField("Destination folder", "", 40, nil, nil).
+		AddDropDown("If it exists", cp.ConflictNames(), int(CopyConflict), nil)
 
 	form.AddButton("Copy", func() {
-		defer func() {
-			isFormInputActive = false
-		}()
-		dstPath := form.GetFormItem(0).(*tview.InputField).GetText()
-
-		dstPathInfo, err := os.Stat(dstPath)
-		if os.IsNotExist(err) {
-			// Create destination directory if it is not exist.
-			err = os.MkdirAll(dstPath, os.ModePerm)
-			if err != nil {
-				log.Errorf("Directory couldn't created: %v", err)
-				showMessage(app, "Directory couldn't created", nil)
-				return
-			}
-		}
-		dstPathInfo, err = os.Stat(dstPath)
-		if !dstPathInfo.IsDir() {
-			log.Error("Given destination path is not a directory.")
-			showMessage(app, "Given destination path is not a directory", nil)
+		isFormInputActive = false
+		dstDir := form.GetFormItem(0).(*tview.InputField).GetText()
+		conflict, _ := form.GetFormItem(1).(*tview.DropDown).GetCurrentOption()
+		if !makeDestDir(app, dstDir) {
 			return
 		}
-		if cNode.GetReference().(*types.Node).IsDir {
-			// Duplicate folder
-
-			srcFolderName := srcFileName
-			if ok, err := fileExistInDstPath(dstPath, srcFolderName); ok && err == nil {
-				log.Error("Already exist folder name")
-				showMessage(app, "Already exist folder name", nil)
-				return
-			} else if err != nil {
-				log.Errorf("Destination directory couldn't read: %v", err)
-				showMessage(app, "Destination directory couldn't read.", nil)
-				return
-			}
-			err := cp.Folder(filepath.Join(dstPath, srcFolderName), srcPath)
-			if err != nil {
-				log.Errorf("Folder couldn't copied to destination path: %v", err)
-				showMessage(app, "Folder couldn't copied to destination path", nil)
-				return
-			}
-			log.Infof("Folder is copied to %s successfully.", dstPath)
-			newRoot := constructNativeTree(currTreeView.GetRoot().GetReference().(*types.Node))
-			newRoot.SetExpanded(true)
-			currTreeView.SetRoot(newRoot).SetCurrentNode(newRoot)
-			app.SetRoot(currGrid, true).SetFocus(currGrid)
-		} else {
-			// Duplicate file
-
-			if ok, err := fileExistInDstPath(dstPath, srcFileName); ok && err == nil {
-				log.Error("Already exist file name")
-				showMessage(app, "Already exist file name", nil)
-				return
-			} else if err != nil {
-				log.Errorf("Destination directory couldn't read: %v", err)
-				showMessage(app, "Destination directory couldn't read.", nil)
-				return
-			}
-
-			err = cp.File(filepath.Join(dstPath, srcFileName), srcPath)
-			if err != nil {
-				log.Errorf("File couldn't copied to destination: %v", err)
-				showMessage(app, fmt.Sprintf("File couldn't copied to destination: %v", err.Error()), nil)
-				return
-			}
-			log.Info("File is copied successfully.")
-			newRoot := constructNativeTree(currTreeView.GetRoot().GetReference().(*types.Node))
-			newRoot.SetExpanded(true)
-			currTreeView.SetRoot(newRoot).SetCurrentNode(newRoot)
-			app.SetRoot(currGrid, true).SetFocus(currGrid)
-		}
+		app.SetRoot(currGrid, true).SetFocus(currGrid)
+		copyNodes(app, nodes, dstDir, cp.Conflict(conflict))
 	})
 
 	form.AddButton("Cancel", func() {
 		isFormInputActive = false
 		app.SetRoot(currGrid, true).SetFocus(currGrid)
 	})
+	form.SetBorder(true).SetTitle(fmt.Sprintf(" Copy %s ", describeNodes(nodes)))
 
 	isFormInputActive = true
 	app.SetRoot(form, true).SetFocus(form)
 }
 
-// fileExistInDstPath checks the file path whether it exists in destination path.
-func fileExistInDstPath(path, fileName string) (bool, error) {
-	files, err := ioutil.ReadDir(path)
-	if err != nil {
-		return false, err
+// copyNodes copies nodes into the folder dstDir in the background, keeping
+// their times, owners, links, holes and extended attributes, and adds the
+// copies to the tree if they are below the scanned folder. conflict tells
+// what to do with files and folders in the way.
+func copyNodes(app *tview.Application, nodes []*types.Node, dstDir string, conflict cp.Conflict) {
+	done := fmt.Sprintf("Copied %s to %s", describeNodes(nodes), dstDir)
+	if conflict == cp.Rename {
+		// Copies onto files and folders in the way cannot be undone.
+		done += ". Press z to undo"
 	}
-
-	for _, file := range files {
-		if file.Name() == fileName {
-			return true, nil
+	runBatch(app, "Copying", batchItems(nodes), func(it batchItem) (func(), error) {
+		c := cp.New(cp.WithPreserve(cp.PreserveAll), cp.WithConflict(conflict), cp.WithProgress(func(p cp.Progress) {
+			if p.Done {
+				return
+			}
+			app.QueueUpdateDraw(func() {
+				setInfo(fmt.Sprintf("Copying %s: %d entries, %s copied, %d skipped, %d failed, at %s",
+					it.path, p.Entries, currSizeFormatter(p.Bytes), p.Skipped, p.Errors, p.Current))
+			})
+		}))
+		res, err := c.Copy(context.Background(), filepath.Join(dstDir, filepath.Base(it.path)), it.path)
+		if err != nil {
+			return nil, err
 		}
-	}
-
-	return false, nil
+		for _, e := range res.Errs {
+			log.Errorf("Could not copy %v", e)
+		}
+		if res.Path == "" {
+			if len(res.Errs) == 0 {
+				log.Infof("Skipped copying %s, it is in the way", it.path)
+			}
+			return nil, res.Err()
+		}
+		log.Infof("Copied %s to %s: %d entries, %d skipped, %d failed", it.path, res.Path, res.Entries, res.Skipped, res.Errors)
+		return func() {
+			op := journal.Copied(it.path, res.Path)
+			op.Merged = res.Existed
+			recordOp(op)
+			if err := addPathToTree(res.Path); err != nil {
+				log.Errorf("Could not add the copy %q to the tree: %v", res.Path, err)
+			}
+		}, res.Err()
+	}, done)
 }
@@ -0,0 +1,104 @@
+package gui
+
+import (
+	"fmt"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"go.sazak.io/gls/internal/diff"
+	"go.sazak.io/gls/internal/info"
+	"go.sazak.io/gls/internal/types"
+)
+
+const (
+	// maxListedChanges is the number of entries shown in the list of the
+	// largest changes next to the diff tree.
+	maxListedChanges = 100
+)
+
+// RunDiffView shows the diff tree d, annotated with the size change of every
+// entry, along with the list of the largest changes, until the user quits.
+func RunDiffView(d *diff.Node, title string, f types.SizeFormatter) error {
+	app := tview.NewApplication()
+
+	root := constructDiffTree(d, f)
+	root.SetExpanded(true)
+	treeView := tview.NewTreeView().
+		SetRoot(root).
+		SetCurrentNode(root).
+		SetSelectedFunc(func(node *tview.TreeNode) {
+			node.SetExpanded(!node.IsExpanded())
+		})
+	treeView.SetBorder(true).
+		SetTitle(fmt.Sprintf("[ %s ]", title)).
+		SetTitleAlign(tview.AlignCenter).
+		SetTitleColor(TreeViewTitleColor)
+
+	changes := tview.NewTable().
+		SetSelectable(false, false)
+	changes.SetBorder(true).
+		SetBorderColor(BorderColor).
+		SetTitle("[ Largest changes ]").
+		SetTitleColor(FileInfoTitleColor)
+	for i, c := range d.Changes() {
+		if i == maxListedChanges {
+			break
+		}
+		color := diffColor(c)
+		changes.SetCell(i, 0, tview.NewTableCell(diff.FormatDelta(c.Delta(), f)).SetTextColor(color)).
+			SetCell(i, 1, tview.NewTableCell(c.Kind.String()).SetTextColor(color)).
+			SetCell(i, 2, tview.NewTableCell(c.Path()).SetTextColor(FileInfoValueColor))
+	}
+
+	grid := tview.NewGrid().SetRows(-1).SetColumns(-3, -2)
+	grid.SetBorder(true).
+		SetTitle(fmt.Sprintf("[ %s ]", info.ProjectNameWithVersion())).
+		SetTitleColor(GridTitleColor)
+	grid.AddItem(treeView, 0, 0, 1, 1, 0, 0, true)
+	grid.AddItem(changes, 0, 1, 1, 1, 0, 0, false)
+
+	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyCtrlC, event.Key() == tcell.KeyEscape, event.Rune() == 'q', event.Rune() == 'Q':
+			app.Stop()
+		case event.Rune() == 'c', event.Rune() == 'C':
+			treeView.GetRoot().CollapseAll()
+		case event.Rune() == 'e', event.Rune() == 'E':
+			treeView.GetRoot().ExpandAll()
+		}
+		return event
+	})
+	return app.SetRoot(grid, true).SetFocus(treeView).Run()
+}
+
+func constructDiffTree(d *diff.Node, f types.SizeFormatter) *tview.TreeNode {
+	text := d.Name
+	if d.Kind != diff.Unchanged {
+		text = fmt.Sprintf("%s [%s] (%s)", d.Name, diff.FormatDelta(d.Delta(), f), d.Kind)
+	}
+	treeNode := tview.NewTreeNode(text).
+		SetReference(d).
+		SetSelectable(true).
+		SetColor(diffColor(d))
+	if d.IsDir {
+		treeNode.SetExpanded(false)
+	}
+	for _, child := range d.Children {
+		treeNode.AddChild(constructDiffTree(child, f))
+	}
+	return treeNode
+}
+
+func diffColor(d *diff.Node) tcell.Color {
+	switch d.Kind {
+	case diff.Added, diff.Grown:
+		return GrownColor
+	case diff.Removed, diff.Shrunk:
+		return ShrunkColor
+	}
+	if d.IsDir {
+		return DirectoryColor
+	}
+	return UnmarkedFileColor
+}
@@ -0,0 +1,73 @@
+package gui
+
+import (
+	"fmt"
+
+	"github.com/rivo/tview"
+
+	"go.sazak.io/gls/internal/types"
+)
+
+// showScanErrors lists the entries that could not be scanned completely.
+// Selecting one shows it in the tree view.
+func showScanErrors(app *tview.Application) {
+	errs := currBuilder.ScanErrors()
+	if len(errs) == 0 {
+		showMessage(app, "There were no errors while scanning", nil)
+		return
+	}
+	list := tview.NewList().
+		ShowSecondaryText(true).
+		SetMainTextColor(FileInfoValueColor).
+		SetSecondaryTextColor(FileInfoAttrColor)
+	for _, e := range errs {
+		node := e.Node
+		list.AddItem(e.Path, e.Err, 0, func() {
+			isFormInputActive = false
+			app.SetRoot(currGrid, true).SetFocus(currGrid)
+			selectNode(app, node)
+		})
+	}
+	list.SetDoneFunc(func() {
+		isFormInputActive = false
+		app.SetRoot(currGrid, true).SetFocus(currGrid)
+	})
+	list.SetBorder(true).
+		SetTitle(fmt.Sprintf("[ Scan errors (%d) ]", len(errs))).
+		SetTitleAlign(tview.AlignCenter).
+		SetTitleColor(SearchFormTitleColor)
+	// Keep q and the other shortcuts from acting on the tree behind.
+	isFormInputActive = true
+	app.SetRoot(list, true).SetFocus(list)
+}
+
+// selectNode moves the cursor of the tree view to node, expanding its
+// parents. The full tree is shown again if a search result is shown.
+func selectNode(app *tview.Application, node *types.Node) {
+	if currTreeView.GetRoot().GetReference().(*types.Node) != originalRootNode {
+		restoreOriginalRoot(app)
+	}
+	var path []*tview.TreeNode
+	var find func(t *tview.TreeNode) bool
+	find = func(t *tview.TreeNode) bool {
+		if t.GetReference().(*types.Node) == node {
+			path = append(path, t)
+			return true
+		}
+		for _, c := range t.GetChildren() {
+			if find(c) {
+				path = append(path, t)
+				return true
+			}
+		}
+		return false
+	}
+	if !find(currTreeView.GetRoot()) {
+		return
+	}
+	for _, t := range path[1:] {
+		t.SetExpanded(true)
+	}
+	currTreeView.SetCurrentNode(path[0])
+	updateFileInfoTab(app, node)
+}
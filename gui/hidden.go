@@ -0,0 +1,55 @@
+package gui
+
+import (
+	"github.com/rivo/tview"
+
+	"go.sazak.io/gls/internal/types"
+)
+
+// showHidden makes the tree view list the hidden children of every
+// directory, the entries left out for being ignored or below the size
+// threshold, instead of one ghost row standing for all of them.
+var showHidden = false
+
+// SetShowHidden sets whether the tree view starts out listing hidden
+// entries one by one.
+func SetShowHidden(show bool) {
+	showHidden = show
+}
+
+// addHiddenRows adds the rows for the hidden children of node to treeNode,
+// greyed out.
+func addHiddenRows(treeNode *tview.TreeNode, node *types.Node, f types.SizeFormatter) {
+	if !showHidden {
+		if g := node.HiddenSummary(); g != nil {
+			treeNode.AddChild(tview.NewTreeNode(g.InfoWithSizeFormatter(f)).
+				SetReference(g).
+				SetSelectable(true).
+				SetColor(HiddenColor))
+		}
+		return
+	}
+	for _, h := range node.Hidden {
+		treeNode.AddChild(constructTViewTreeFromNodeWithFormatter(h, f).SetColor(HiddenColor))
+	}
+}
+
+// toggleHidden switches between listing hidden entries one by one and
+// summarizing them in ghost rows. The cursor stays on the hidden entries it
+// was on.
+func toggleHidden(app *tview.Application) {
+	showHidden = !showHidden
+	current := currTreeView.GetCurrentNode().GetReference().(*types.Node)
+	reloadTreeView(app, currTreeView.GetRoot().GetReference().(*types.Node))
+	switch {
+	case current.Ghost && len(current.Parent.Hidden) > 0:
+		selectNode(app, current.Parent.Hidden[0])
+	case current.IsHidden():
+		selectNode(app, current.Parent)
+	}
+	if showHidden {
+		setInfo("Showing entries hidden by ignore rules or the size threshold. Press h to summarize them again")
+	} else {
+		setInfo("Summarizing entries hidden by ignore rules or the size threshold. Press h to show them")
+	}
+}
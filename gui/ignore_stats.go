@@ -0,0 +1,64 @@
+package gui
+
+import (
+	"fmt"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// showIgnoreStats lists the ignore rules with what each of them excluded
+// during the scan.
+func showIgnoreStats(app *tview.Application) {
+	if currBuilder.Offline() {
+		showMessage(app, "Ignore statistics are only collected while scanning, not in snapshots", nil)
+		return
+	}
+	stats := currBuilder.IgnoreStats()
+	if len(stats) == 0 {
+		showMessage(app, "There are no ignore rules", nil)
+		return
+	}
+	table := tview.NewTable().
+		SetFixed(1, 0).
+		SetSelectable(true, false).
+		SetSeparator('|').
+		SetBordersColor(BorderColor)
+	for col, header := range []string{"Rule", "File", "Files", "Folders", "Size on disk"} {
+		table.SetCell(0, col, tview.NewTableCell(header).
+			SetTextColor(FileInfoAttrColor).
+			SetSelectable(false))
+	}
+	for i, rs := range stats {
+		cells := []string{
+			rs.Rule.Pattern,
+			fmt.Sprintf("%s:%d", rs.Rule.File, rs.Rule.Line),
+			fmt.Sprint(rs.Files),
+			fmt.Sprint(rs.Dirs),
+			currSizeFormatter(rs.SizeOnDisk),
+		}
+		color := FileInfoValueColor
+		if rs.Files == 0 && rs.Dirs == 0 {
+			// Rules that never matched are candidates for removal.
+			color = HiddenColor
+		}
+		for col, text := range cells {
+			cell := tview.NewTableCell(text).SetTextColor(color)
+			if col >= 2 {
+				cell.SetAlign(tview.AlignRight)
+			}
+			table.SetCell(i+1, col, cell)
+		}
+	}
+	table.SetDoneFunc(func(key tcell.Key) {
+		isFormInputActive = false
+		app.SetRoot(currGrid, true).SetFocus(currGrid)
+	})
+	table.SetBorder(true).
+		SetTitle(fmt.Sprintf("[ Ignore rules (%d) ]", len(stats))).
+		SetTitleAlign(tview.AlignCenter).
+		SetTitleColor(SearchFormTitleColor)
+	// Keep q and the other shortcuts from acting on the tree behind.
+	isFormInputActive = true
+	app.SetRoot(table, true).SetFocus(table)
+}
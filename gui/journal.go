@@ -0,0 +1,144 @@
+package gui
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/rivo/tview"
+
+	"go.sazak.io/gls/internal/journal"
+	"go.sazak.io/gls/log"
+)
+
+// currJournal records the file operations of the session, so that they can
+// be undone. It is nil if it could not be opened.
+var currJournal *journal.Journal
+
+// OpenJournal opens the journal at path that the file operations are
+// recorded in, continuing it if it exists. An empty path opens a new one
+// for the session in the default directory.
+func OpenJournal(path string) error {
+	if path == "" {
+		dir, err := journal.DefaultDir()
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(dir, journal.SessionName(time.Now()))
+	}
+	// Without a trash, undoing deletes what operations made.
+	can, err := getTrash()
+	if err != nil {
+		log.Warningf("Could not open the trash for the journal: %v", err)
+	}
+	j, err := journal.Open(path, can)
+	if err != nil {
+		return err
+	}
+	currJournal = j
+	log.Infof("Recording file operations in %s", path)
+	return nil
+}
+
+func closeJournal() {
+	if currJournal == nil {
+		return
+	}
+	if err := currJournal.Close(); err != nil {
+		log.Errorf("Could not close the journal: %v", err)
+	}
+}
+
+// recordOp records op, which was just done, in the journal.
+func recordOp(op journal.Op) {
+	if currJournal == nil {
+		return
+	}
+	if _, err := currJournal.Record(op); err != nil {
+		log.Errorf("Could not record %s in the journal: %v", op.String(), err)
+		setError(fmt.Sprintf("Could not record %s in the journal, it cannot be undone: %v", op.String(), err))
+	}
+}
+
+// undoLastOp undoes the most recent file operation that can be undone.
+func undoLastOp(app *tview.Application) {
+	runJournalStep(app, "Undid", "Nothing to undo", currJournal.Undo)
+}
+
+// redoLastOp redoes the oldest undone file operation.
+func redoLastOp(app *tview.Application) {
+	runJournalStep(app, "Redid", "Nothing to redo", currJournal.Redo)
+}
+
+// runJournalStep runs step, which undoes or redoes an operation of the
+// journal, in the background, and then shows the changed tree.
+func runJournalStep(app *tview.Application, done, nothing string, step func() (journal.Op, []string, error)) {
+	if currJournal == nil {
+		showMessage(app, "There is no journal to undo file operations with, see the log file", nil)
+		return
+	}
+	go func() {
+		op, paths, err := step()
+		app.QueueUpdateDraw(func() {
+			if errors.Is(err, journal.ErrNothingToUndo) || errors.Is(err, journal.ErrNothingToRedo) {
+				setInfo(nothing)
+				return
+			}
+			for _, p := range paths {
+				if err := addPathToTree(p); err != nil {
+					log.Errorf("Could not update %q in the tree: %v", p, err)
+				}
+			}
+			showChangedTree(app)
+			if err != nil {
+				log.Errorf("%v", err)
+				showMessage(app, fmt.Sprintf("%v\n\nThe operation is skipped from now on.", err), nil)
+				return
+			}
+			log.Infof("%s %s", done, op.String())
+			setInfo(fmt.Sprintf("%s %s. Press z to undo, y to redo and j for the history", done, op.String()))
+		})
+	}()
+}
+
+// showHistory lists the operations in the journal, the most recent first.
+func showHistory(app *tview.Application) {
+	if currJournal == nil {
+		showMessage(app, "There is no journal of file operations, see the log file", nil)
+		return
+	}
+	ops := currJournal.Ops()
+	if len(ops) == 0 {
+		showMessage(app, "No file operations yet", nil)
+		return
+	}
+	back := func() {
+		isFormInputActive = false
+		app.SetRoot(currGrid, true).SetFocus(currGrid)
+	}
+	list := tview.NewList().
+		ShowSecondaryText(true).
+		SetMainTextColor(FileInfoValueColor).
+		SetSecondaryTextColor(FileInfoAttrColor)
+	for _, op := range ops {
+		state := "done"
+		switch {
+		case op.Failed != "":
+			state = "skipped: " + op.Failed
+		case !op.Reversible():
+			state = "cannot be undone"
+		case op.Undone:
+			state = "undone"
+		}
+		list.AddItem(op.String(), fmt.Sprintf("%s, %s", formatModTime(op.Time), state), 0, back)
+	}
+	list.SetDoneFunc(back)
+	list.SetBorder(true).
+		SetTitle(fmt.Sprintf("[ History (%d): z undoes, y redoes ]", len(ops))).
+		SetTitleAlign(tview.AlignCenter).
+		SetTitleColor(SearchFormTitleColor)
+	// Keep q and the other shortcuts from acting on the tree behind.
+	isFormInputActive = true
+	app.SetRoot(list, true).SetFocus(list)
+}
@@ -0,0 +1,163 @@
+package gui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"go.sazak.io/gls/internal/types"
+	"go.sazak.io/gls/log"
+)
+
+// markedPaths holds the paths of the marked files and folders, as nodeKey
+// returns them, so that the marks stay when the tree view is rebuilt for a
+// search, a restore or a change.
+var markedPaths = make(map[string]struct{})
+
+func markUnmarkFile(app *tview.Application) {
+	cNode := currTreeView.GetCurrentNode()
+	node := cNode.GetReference().(*types.Node)
+	switch {
+	case node.Ghost:
+		setError("Ghost rows cannot be marked. Press h to show the hidden entries")
+		return
+	case node.Parent == nil:
+		setError("The root folder cannot be marked")
+		return
+	case node.Virtual:
+		setError("Entries inside archives cannot be marked")
+		return
+	case node.IsHidden():
+		setError("Entries hidden by ignore rules or the size threshold cannot be marked")
+		return
+	}
+	key := nodeKey(cNode)
+	if _, ok := markedPaths[key]; ok { // unmark if already marked
+		delete(markedPaths, key)
+		log.Debugf("Removed %q from marked files", key)
+	} else {
+		markedPaths[key] = struct{}{}
+		log.Debugf("Added %q to marked files", key)
+	}
+	cNode.SetColor(nodeColor(node, key))
+	updateMarkStatus()
+}
+
+func unmarkAll(app *tview.Application) {
+	for key := range markedPaths {
+		delete(markedPaths, key)
+	}
+	if currTreeView != nil && currTreeView.GetRoot() != nil {
+		currTreeView.GetRoot().Walk(func(tnode, _ *tview.TreeNode) bool {
+			tnode.SetColor(nodeColor(tnode.GetReference().(*types.Node), ""))
+			return true
+		})
+	}
+	updateMarkStatus()
+}
+
+// isMarked reports whether the node of the tree view at key is marked.
+func isMarked(key string) bool {
+	_, ok := markedPaths[key]
+	return ok
+}
+
+// nodeColor returns the color the tree view shows n in. key is the path of
+// n as nodeKey returns it, or "" if n is known not to be marked.
+func nodeColor(n *types.Node, key string) tcell.Color {
+	switch {
+	case key != "" && isMarked(key):
+		return MarkedFileColor
+	case n.IsDir:
+		return DirectoryColor
+	}
+	return UnmarkedFileColor
+}
+
+// nodeAtKey returns the node of the scanned tree at the path key, as nodeKey
+// returns it, or nil if there is none.
+func nodeAtKey(key string) *types.Node {
+	if key == currPath {
+		return originalRootNode
+	}
+	rel := strings.TrimPrefix(key, currPath+"/")
+	if rel == key {
+		return nil
+	}
+	return originalRootNode.Lookup(strings.Split(rel, "/")...)
+}
+
+// markedNodes returns the marked nodes of the scanned tree, sorted by path,
+// leaving out those below another marked folder, which go along with it.
+func markedNodes() []*types.Node {
+	marked := make(map[*types.Node]bool)
+	for key := range markedPaths {
+		if n := nodeAtKey(key); n != nil && n.Parent != nil && !n.Ghost {
+			marked[n] = true
+		}
+	}
+	var nodes []*types.Node
+	for n := range marked {
+		below := false
+		for a := n.Parent; a != nil; a = a.Parent {
+			if marked[a] {
+				below = true
+				break
+			}
+		}
+		if !below {
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].RelativePath(currPath) < nodes[j].RelativePath(currPath)
+	})
+	return nodes
+}
+
+// selectedNodes returns the nodes of the scanned tree that batch commands
+// act on: the marked ones, or the hovered one if nothing is marked.
+func selectedNodes() []*types.Node {
+	if len(markedPaths) > 0 {
+		return markedNodes()
+	}
+	if n := originalNode(currTreeView.GetCurrentNode().GetReference().(*types.Node)); n != nil {
+		return []*types.Node{n}
+	}
+	return nil
+}
+
+// describeNodes names nodes in messages: the path of a single one, or how
+// many there are.
+func describeNodes(nodes []*types.Node) string {
+	if len(nodes) == 1 {
+		return fmt.Sprintf("%q", nodes[0].RelativePath(currPath))
+	}
+	return fmt.Sprintf("%d marked files and folders", len(nodes))
+}
+
+// updateMarkStatus drops the marks of files and folders that are not in the
+// tree anymore, and shows the number and the combined size of the rest.
+func updateMarkStatus() {
+	for key := range markedPaths {
+		if nodeAtKey(key) == nil {
+			delete(markedPaths, key)
+		}
+	}
+	if currMarkStatusView == nil {
+		return
+	}
+	if len(markedPaths) == 0 {
+		currMarkStatusView.SetText("Nothing marked. Press m to mark files and folders")
+		return
+	}
+	var size int64
+	for _, n := range markedNodes() {
+		size += n.SizeOnDisk
+	}
+	currMarkStatusView.SetText(fmt.Sprintf("%d marked, %s on disk. d copies, F6 moves, DEL removes, a archives and p opens them, u unmarks",
+		len(markedPaths), currSizeFormatter(size)))
+}
@@ -0,0 +1,142 @@
+package gui
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/rivo/tview"
+
+	"go.sazak.io/gls/internal/cp"
+	"go.sazak.io/gls/internal/journal"
+	"go.sazak.io/gls/internal/types"
+	"go.sazak.io/gls/log"
+)
+
+// showRenameForm asks for a new name for the hovered file or folder, and
+// renames it.
+func showRenameForm(app *tview.Application) {
+	tnode := currTreeView.GetCurrentNode()
+	if tnode == currTreeView.GetRoot() {
+		showMessage(app, "Cannot rename the root folder", nil)
+		return
+	}
+	node := originalNode(tnode.GetReference().(*types.Node))
+	if node == nil {
+		showMessage(app, "Could not find the hovered node in the scanned tree", nil)
+		return
+	}
+	if isBusy(app) {
+		return
+	}
+	form := tview.NewForm().
+		AddInputField("New name", node.Name, 32, nil, nil)
+	form.AddButton("Rename", func() {
+		isFormInputActive = false
+		name := form.GetFormItem(0).(*tview.InputField).GetText()
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			showMessage(app, fmt.Sprintf("Invalid name %q", name), nil)
+			return
+		}
+		if name == node.Name {
+			app.SetRoot(currGrid, true).SetFocus(currGrid)
+			return
+		}
+		src := node.RelativePath(currPath)
+		dst := filepath.Join(filepath.Dir(src), name)
+		if err := cp.Move(dst, src); err != nil {
+			log.Errorf("Could not rename %q to %q: %v", src, name, err)
+			showMessage(app, fmt.Sprintf("Could not rename %q to %q: %v", src, name, err), nil)
+			return
+		}
+		log.Infof("Renamed %s to %s", src, name)
+		recordOp(journal.Moved(src, dst))
+		updateMovedNode(node, src, dst)
+		showChangedTree(app)
+		selectNode(app, node)
+		setInfo(fmt.Sprintf("Renamed %s to %s", src, name))
+	}).
+		AddButton("Cancel", func() {
+			isFormInputActive = false
+			app.SetRoot(currGrid, true).SetFocus(currGrid)
+		})
+	form.SetBorder(true).SetTitle(fmt.Sprintf(" Rename %s ", node.Name))
+	isFormInputActive = true
+	app.SetRoot(form, true).SetFocus(form)
+}
+
+// showMoveMarkedForm asks for a destination folder, and moves the marked
+// files and folders, or the hovered one if nothing is marked, into it.
+func showMoveMarkedForm(app *tview.Application) {
+	nodes := selectedNodes()
+	if len(nodes) == 0 {
+		showMessage(app, "Could not find the hovered node in the scanned tree", nil)
+		return
+	}
+	if len(markedPaths) == 0 && nodes[0].Parent == nil {
+		showMessage(app, "Cannot move the root folder", nil)
+		return
+	}
+	if isBusy(app) {
+		return
+	}
+	form := tview.NewForm().
+		AddInputField("Destination folder", "", 40, nil, nil)
+	form.AddButton("Move", func() {
+		isFormInputActive = false
+		dstDir := form.GetFormItem(0).(*tview.InputField).GetText()
+		if !makeDestDir(app, dstDir) {
+			return
+		}
+		app.SetRoot(currGrid, true).SetFocus(currGrid)
+		moveNodes(app, nodes, dstDir)
+	}).
+		AddButton("Cancel", func() {
+			isFormInputActive = false
+			app.SetRoot(currGrid, true).SetFocus(currGrid)
+		})
+	form.SetBorder(true).SetTitle(fmt.Sprintf(" Move %s ", describeNodes(nodes)))
+	isFormInputActive = true
+	app.SetRoot(form, true).SetFocus(form)
+}
+
+// moveNodes moves nodes into the folder dstDir in the background, copying
+// and deleting those that go to another file system, and updates the tree
+// as each of them is moved.
+func moveNodes(app *tview.Application, nodes []*types.Node, dstDir string) {
+	runBatch(app, "Moving", batchItems(nodes), func(it batchItem) (func(), error) {
+		dst := filepath.Join(dstDir, filepath.Base(it.path))
+		err := cp.CheckInside(dstDir, it.path)
+		if err == nil {
+			err = cp.Move(dst, it.path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("Moved %s to %s", it.path, dst)
+		return func() {
+			recordOp(journal.Moved(it.path, dst))
+			updateMovedNode(it.node, it.path, dst)
+		}, nil
+	}, fmt.Sprintf("Moved %s to %s. Press z to undo", describeNodes(nodes), dstDir))
+}
+
+// updateMovedNode updates the tree after the node n was moved from src to
+// dst on disk. n is re-parented if its new place is shown in the tree, and
+// both paths are scanned otherwise.
+func updateMovedNode(n *types.Node, src, dst string) {
+	if p, ok := treePath(dst); ok {
+		moved, err := currBuilder.MoveNode(n, p)
+		if err != nil {
+			log.Errorf("Could not move %q in the tree: %v", src, err)
+		}
+		if moved {
+			return
+		}
+	}
+	for _, p := range []string{src, dst} {
+		if err := addPathToTree(p); err != nil {
+			log.Errorf("Could not update %q in the tree: %v", p, err)
+		}
+	}
+}
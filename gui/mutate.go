@@ -0,0 +1,80 @@
+package gui
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/rivo/tview"
+)
+
+// showChangedTree shows the scanned tree again after a command changed it.
+// A search result is replaced by the whole tree, since its nodes are copies
+// that do not follow the changes.
+func showChangedTree(app *tview.Application) {
+	originalRootNode = currBuilder.Root()
+	reloadTreeView(app, originalRootNode)
+	app.SetRoot(currGrid, true).SetFocus(currGrid)
+}
+
+// addPathToTree scans the file or folder at path, if it is below the
+// scanned folder, and puts it into the tree along with the folders above it
+// that are not part of the tree yet.
+func addPathToTree(path string) error {
+	rel, ok := treeRelPath(path)
+	if !ok {
+		return nil
+	}
+	for {
+		c, err := currBuilder.PrepareChange(context.Background(), relToTreePath(rel))
+		if err != nil {
+			return err
+		}
+		if c != nil {
+			return c.Apply()
+		}
+		if rel == "." {
+			return nil
+		}
+		// The folder above is not part of the tree either.
+		rel = filepath.Dir(rel)
+	}
+}
+
+// treePath returns the path of path in the tree, as the builder takes it,
+// and whether it is below the scanned folder.
+func treePath(path string) (string, bool) {
+	rel, ok := treeRelPath(path)
+	if !ok {
+		return "", false
+	}
+	return relToTreePath(rel), true
+}
+
+// relToTreePath returns the path in the tree of the path rel relative to
+// the scanned folder. Paths of the tree start with the path of the scanned
+// folder as it was given, like those of RelativePath.
+func relToTreePath(rel string) string {
+	if rel == "." {
+		return currPath
+	}
+	return currPath + "/" + filepath.ToSlash(rel)
+}
+
+// treeRelPath returns the path of path relative to the scanned folder, and
+// whether it is below it.
+func treeRelPath(path string) (string, bool) {
+	root, err := filepath.Abs(currPath)
+	if err != nil {
+		return "", false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
@@ -0,0 +1,244 @@
+package gui
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rivo/tview"
+
+	"go.sazak.io/gls/internal/fs"
+	"go.sazak.io/gls/internal/journal"
+	"go.sazak.io/gls/internal/types"
+	"go.sazak.io/gls/log"
+)
+
+// askRemoveDir asks whether to remove the folder of tnode with everything in
+// it, by moving it to the trash unless permanentDelete is set. For folders
+// deleted for good at or above RemoveConfirmFiles files or
+// RemoveConfirmSize bytes on disk, the name of the folder has to be typed in
+// to confirm.
+func askRemoveDir(app *tview.Application, tnode *tview.TreeNode) {
+	node := originalNode(tnode.GetReference().(*types.Node))
+	if node == nil {
+		showMessage(app, "Cannot remove the folder: it is not part of the scanned tree", nil)
+		return
+	}
+	if isBusy(app) {
+		return
+	}
+	st := node.AggregateStats()
+	relPath := node.RelativePath(currPath)
+	contents := fmt.Sprintf("with %d files and %d folders in it, %s on disk", st.Files, st.Dirs, currSizeFormatter(node.SizeOnDisk))
+	text := fmt.Sprintf("Move the folder %q %s to the trash?", relPath, contents)
+	if permanentDelete {
+		text = fmt.Sprintf("Are you sure to remove the folder %q %s for good?", relPath, contents)
+	}
+	if len(node.Hidden) > 0 {
+		text += " This includes hidden entries."
+	}
+	if !permanentDelete || !isLargeTree(node, st) {
+		modal := tview.NewModal().
+			SetText(text).
+			AddButtons([]string{"Cancel", "Yes"}).
+			SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+				if buttonLabel == "Yes" && !permanentDelete {
+					trashNode(app, node)
+					return
+				}
+				if buttonLabel == "Yes" {
+					removeDir(app, node)
+					return
+				}
+				app.SetRoot(currGrid, true).SetFocus(currGrid)
+			})
+		app.SetRoot(modal, true).SetFocus(modal)
+		return
+	}
+
+	askTypedConfirmation(app, " Remove folder ", text, node.Name,
+		fmt.Sprintf("The folder name does not match, %q is not removed", relPath),
+		func() { removeDir(app, node) })
+}
+
+// askTypedConfirmation shows text and asks to type answer to confirm. remove
+// is called if it matches, and mismatch is shown otherwise.
+func askTypedConfirmation(app *tview.Application, title, text, answer, mismatch string, remove func()) {
+	form := tview.NewForm().
+		AddInputField(fmt.Sprintf("Type %q to confirm", answer), "", 32, nil, nil)
+	form.AddButton("Remove", func() {
+		isFormInputActive = false
+		if form.GetFormItem(0).(*tview.InputField).GetText() != answer {
+			setError(mismatch)
+			app.SetRoot(currGrid, true).SetFocus(currGrid)
+			return
+		}
+		remove()
+	}).
+		AddButton("Cancel", func() {
+			isFormInputActive = false
+			app.SetRoot(currGrid, true).SetFocus(currGrid)
+		})
+	textView := tview.NewTextView().
+		SetText(text).
+		SetWrap(true)
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(textView, 3, 0, false).
+		AddItem(form, 0, 1, true)
+	flex.SetBorder(true).SetTitle(title)
+	isFormInputActive = true
+	app.SetRoot(flex, true).SetFocus(form)
+}
+
+// askRemoveNodes asks whether to remove the marked nodes with everything in
+// them, by moving them to the trash unless permanentDelete is set. Like for
+// a single folder, the number of nodes has to be typed in to confirm when
+// deleting for good at or above RemoveConfirmFiles files or
+// RemoveConfirmSize bytes on disk in total.
+func askRemoveNodes(app *tview.Application, nodes []*types.Node) {
+	if isBusy(app) {
+		return
+	}
+	var total types.Stats
+	var size int64
+	hidden := false
+	for _, n := range nodes {
+		if n.IsDir {
+			st := n.AggregateStats()
+			total.Files += st.Files
+			total.Dirs += st.Dirs + 1
+		} else {
+			total.Files++
+		}
+		size += n.SizeOnDisk
+		hidden = hidden || len(n.Hidden) > 0
+	}
+	contents := fmt.Sprintf("%s, with %d files and %d folders in all, %s on disk,", describeNodes(nodes), total.Files, total.Dirs, currSizeFormatter(size))
+	text := fmt.Sprintf("Move %s to the trash?", contents)
+	if permanentDelete {
+		text = fmt.Sprintf("Are you sure to remove %s for good?", contents)
+	}
+	if hidden {
+		text += " This includes hidden entries."
+	}
+	large := (RemoveConfirmFiles > 0 && total.Files >= RemoveConfirmFiles) ||
+		(RemoveConfirmSize > 0 && size >= RemoveConfirmSize)
+	if permanentDelete && large {
+		askTypedConfirmation(app, " Remove marked files and folders ", text, fmt.Sprint(len(nodes)),
+			"The number does not match, the marked files and folders are not removed",
+			func() { removeNodes(app, nodes) })
+		return
+	}
+	modal := tview.NewModal().
+		SetText(text).
+		AddButtons([]string{"Cancel", "Yes"}).
+		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+			if buttonLabel == "Yes" {
+				removeNodes(app, nodes)
+				return
+			}
+			app.SetRoot(currGrid, true).SetFocus(currGrid)
+		})
+	app.SetRoot(modal, true).SetFocus(modal)
+}
+
+// removeNodes moves nodes to the trash in the background, or deletes them
+// for good if permanentDelete is set, and updates the tree as each of them
+// is removed.
+func removeNodes(app *tview.Application, nodes []*types.Node) {
+	app.SetRoot(currGrid, true).SetFocus(currGrid)
+	can, err := getTrash()
+	if !permanentDelete && err != nil {
+		showMessage(app, fmt.Sprintf("Could not open the trash: %v\n\nRun gls with -permanent to delete files for good.", err), nil)
+		return
+	}
+	if !permanentDelete {
+		runBatch(app, "Trashing", batchItems(nodes), func(it batchItem) (func(), error) {
+			e, err := can.Put(it.path)
+			if err != nil {
+				return nil, err
+			}
+			log.Infof("Moved %s to the trash: %s", it.path, e.File())
+			return func() {
+				updateTrashedPaths(it.path, e)
+				recordOp(journal.Trashed(it.path, e))
+			}, nil
+		}, fmt.Sprintf("Moved %s to the trash, press t to restore them", describeNodes(nodes)))
+		return
+	}
+	runBatch(app, "Removing", batchItems(nodes), func(it batchItem) (func(), error) {
+		c, errs, err := currBuilder.RemoveTree(context.Background(), it.node, nil)
+		for _, e := range errs {
+			log.Errorf("Could not remove %v", e)
+		}
+		if err == nil && len(errs) > 0 {
+			err = fmt.Errorf("%d entries could not be removed, see the log file", len(errs))
+		}
+		if c == nil {
+			return nil, err
+		}
+		log.Infof("Removed %s", it.path)
+		return func() {
+			recordOp(journal.Removed(it.path))
+			if applyErr := c.Apply(); applyErr != nil {
+				log.Errorf("Could not update the tree after removing %q: %v", it.path, applyErr)
+			}
+		}, err
+	}, fmt.Sprintf("Removed %s", describeNodes(nodes)))
+}
+
+// isLargeTree reports whether removing the folder n, with the aggregate
+// stats st, has to be confirmed by typing its name.
+func isLargeTree(n *types.Node, st types.Stats) bool {
+	return (RemoveConfirmFiles > 0 && st.Files >= RemoveConfirmFiles) ||
+		(RemoveConfirmSize > 0 && n.SizeOnDisk >= RemoveConfirmSize)
+}
+
+// removeDir removes the folder node in the background, showing the progress
+// in the log view, and then shows what is left of it in the tree.
+func removeDir(app *tview.Application, node *types.Node) {
+	app.SetRoot(currGrid, true).SetFocus(currGrid)
+	relPath := node.RelativePath(currPath)
+	busyWith = fmt.Sprintf("Removing %s", relPath)
+	setInfo(fmt.Sprintf("Removing %s...", relPath))
+	go func() {
+		c, errs, err := currBuilder.RemoveTree(context.Background(), node, func(ev fs.ProgressEvent) {
+			if ev.Done {
+				return
+			}
+			app.QueueUpdateDraw(func() {
+				setInfo(fmt.Sprintf("Removing %s: %d entries, %s removed, %d failed, in %s",
+					relPath, ev.Entries, currSizeFormatter(ev.Bytes), ev.Errors, ev.CurrentDir))
+			})
+		})
+		app.QueueUpdateDraw(func() {
+			busyWith = ""
+			if c != nil {
+				recordOp(journal.Removed(relPath))
+				if applyErr := c.Apply(); applyErr != nil {
+					log.Errorf("Could not update the tree after removing %q: %v", relPath, applyErr)
+				}
+			}
+			if err != nil {
+				log.Errorf("Could not remove folder %q: %v", relPath, err)
+				if c != nil {
+					showChangedTree(app)
+				}
+				showMessage(app, fmt.Sprintf("Could not remove folder %q: %v", relPath, err), nil)
+				return
+			}
+			showChangedTree(app)
+			if len(errs) == 0 {
+				log.Infof("Removed folder: %s", relPath)
+				setInfo(fmt.Sprintf("Removed %s", relPath))
+				return
+			}
+			msgs := make([]string, len(errs))
+			for i, e := range errs {
+				log.Errorf("Could not remove %v", e)
+				msgs[i] = e.Error()
+			}
+			setError(fmt.Sprintf("Removed %s partially, %d entries could not be removed", relPath, len(errs)))
+			showMessage(app, errorsMessage(fmt.Sprintf("Could not remove %d entries of %q, which are left in place with the folders above them:", len(errs), relPath), msgs), nil)
+		})
+	}()
+}
@@ -0,0 +1,77 @@
+package gui
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rivo/tview"
+
+	"go.sazak.io/gls/internal/types"
+)
+
+// statsFirstRow is the row of the file info tab the folder stats start at,
+// below the attributes every node has.
+const statsFirstRow = 9
+
+// updateStatsRows shows the stats of the folder node in the file info tab,
+// or removes them for other nodes.
+func updateStatsRows(node *types.Node) {
+	for currFileInfoTab.GetRowCount() > statsFirstRow {
+		currFileInfoTab.RemoveRow(statsFirstRow)
+	}
+	if !node.IsDir || node.Stats == nil {
+		return
+	}
+	st := node.Stats
+	largest := "none"
+	if st.LargestFile != "" {
+		largest = fmt.Sprintf("%s (%s)", st.LargestFile, currSizeFormatter(st.LargestSize))
+	}
+	rows := []struct {
+		attr, value string
+	}{
+		{"Contents", fmt.Sprintf("%d files, %d folders, %d levels deep", st.Files, st.Dirs, st.Depth)},
+		{"Largest file", largest},
+		{"Files modified", fmt.Sprintf("newest %s, oldest %s", formatModTime(st.Newest), formatModTime(st.Oldest))},
+		{"Own size", fmt.Sprintf("%s on disk, %s in entries", currSizeFormatter(st.OwnSizeOnDisk), currSizeFormatter(st.ChildrenSizeOnDisk(node.SizeOnDisk)))},
+	}
+	for i, r := range rows {
+		currFileInfoTab.SetCell(statsFirstRow+i, 0, tview.NewTableCell(r.attr).
+			SetMaxWidth(FileInfoTabAttrWidth).
+			SetTextColor(FileInfoAttrColor))
+		currFileInfoTab.SetCell(statsFirstRow+i, 1, tview.NewTableCell(r.value).
+			SetTextColor(FileInfoValueColor))
+	}
+}
+
+func formatModTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// cycleSortKey sorts the tree by the next sort key, keeping the cursor on
+// the hovered node.
+func cycleSortKey(app *tview.Application) {
+	next := nextSortKey(currBuilder.SortKey())
+	currBuilder.SortBy(next)
+	root := currTreeView.GetRoot().GetReference().(*types.Node)
+	if root != originalRootNode {
+		// A search result, whose nodes are copies.
+		root.SortChildren(next)
+	}
+	reloadTreeView(app, root)
+	setInfo(fmt.Sprintf("Sorted by %s. Press b to sort by %s", next.Description(), nextSortKey(next).Description()))
+}
+
+// nextSortKey returns the sort key after k in the order the TUI cycles
+// through them.
+func nextSortKey(k types.SortKey) types.SortKey {
+	for i, key := range types.SortKeys {
+		if key == k {
+			return types.SortKeys[(i+1)%len(types.SortKeys)]
+		}
+	}
+	return types.SortKeys[0]
+}
@@ -0,0 +1,181 @@
+package gui
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"go.sazak.io/gls/internal/journal"
+	"go.sazak.io/gls/internal/trash"
+	"go.sazak.io/gls/internal/types"
+	"go.sazak.io/gls/log"
+)
+
+var (
+	// permanentDelete makes removal delete files for good instead of moving
+	// them to the trash.
+	permanentDelete = false
+	currTrash       *trash.Can
+)
+
+// SetPermanentDelete sets whether removing files and folders deletes them
+// for good instead of moving them to the trash.
+func SetPermanentDelete(permanent bool) {
+	permanentDelete = permanent
+}
+
+// getTrash returns the trash of the user, opening it on first use.
+func getTrash() (*trash.Can, error) {
+	if currTrash == nil {
+		can, err := trash.New()
+		if err != nil {
+			return nil, err
+		}
+		currTrash = can
+	}
+	return currTrash, nil
+}
+
+// trashNode moves the file or folder node to the trash, and shows the tree
+// without it.
+func trashNode(app *tview.Application, node *types.Node) {
+	relPath := node.RelativePath(currPath)
+	can, err := getTrash()
+	if err == nil {
+		var e *trash.Entry
+		if e, err = can.Put(relPath); err == nil {
+			updateTrashedPaths(relPath, e)
+			recordOp(journal.Trashed(relPath, e))
+			log.Infof("Moved %s to the trash: %s", relPath, e.File())
+			showChangedTree(app)
+			setInfo(fmt.Sprintf("Moved %s to the trash, press t to restore it", relPath))
+			return
+		}
+	}
+	log.Errorf("Could not move %q to the trash: %v", relPath, err)
+	showMessage(app, fmt.Sprintf("Could not move %q to the trash: %v\n\nRun gls with -permanent to delete files for good.", relPath, err), nil)
+}
+
+// updateTrashedPaths brings the tree up to date with the file moved between
+// path and the trash entry e, whose files may be part of the tree too.
+func updateTrashedPaths(path string, e *trash.Entry) {
+	for _, p := range []string{path, e.File(), e.Info()} {
+		if err := addPathToTree(p); err != nil {
+			log.Errorf("Could not update %q in the tree: %v", p, err)
+		}
+	}
+}
+
+// showTrash lists the entries in the trash, the most recently deleted
+// first. Enter or r restores the selected entry, and DEL or BACKSPACE
+// deletes it for good.
+func showTrash(app *tview.Application) {
+	isFormInputActive = false
+	can, err := getTrash()
+	if err != nil {
+		showMessage(app, fmt.Sprintf("Could not open the trash: %v", err), nil)
+		return
+	}
+	entries, err := can.List()
+	if err != nil {
+		log.Errorf("Could not list the trash: %v", err)
+		showMessage(app, fmt.Sprintf("Could not list the trash: %v", err), nil)
+		return
+	}
+	if len(entries) == 0 {
+		showMessage(app, "The trash is empty", nil)
+		return
+	}
+	back := func() {
+		isFormInputActive = false
+		app.SetRoot(currGrid, true).SetFocus(currGrid)
+	}
+	list := tview.NewList().
+		ShowSecondaryText(true).
+		SetMainTextColor(FileInfoValueColor).
+		SetSecondaryTextColor(FileInfoAttrColor)
+	for _, e := range entries {
+		e := e
+		kind := "file"
+		if e.IsDir {
+			kind = "folder"
+		}
+		list.AddItem(e.Path, fmt.Sprintf("%s deleted %s", kind, formatModTime(e.DeletedAt)), 0, func() {
+			restoreTrashEntry(app, can, e)
+		})
+	}
+	list.SetDoneFunc(back)
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		e := entries[list.GetCurrentItem()]
+		switch {
+		case event.Key() == tcell.KeyBackspace || event.Key() == tcell.KeyDEL:
+			askPurgeTrashEntry(app, can, e)
+			return nil
+		case event.Rune() == 'r' || event.Rune() == 'R':
+			restoreTrashEntry(app, can, e)
+			return nil
+		case event.Rune() == 'q' || event.Rune() == 'Q':
+			back()
+			return nil
+		}
+		return event
+	})
+	list.SetBorder(true).
+		SetTitle(fmt.Sprintf("[ Trash (%d): ENTER or r restores, DEL deletes for good ]", len(entries))).
+		SetTitleAlign(tview.AlignCenter).
+		SetTitleColor(SearchFormTitleColor)
+	// Keep q and the other shortcuts from acting on the tree behind.
+	isFormInputActive = true
+	app.SetRoot(list, true).SetFocus(list)
+}
+
+// restoreTrashEntry moves the entry e back to where it was deleted from.
+func restoreTrashEntry(app *tview.Application, can *trash.Can, e *trash.Entry) {
+	isFormInputActive = false
+	if err := can.Restore(e); err != nil {
+		log.Errorf("Could not restore %q: %v", e.Path, err)
+		showMessage(app, fmt.Sprintf("Could not restore %q: %v", e.Path, err), nil)
+		return
+	}
+	log.Infof("Restored %s from the trash", e.Path)
+	recordOp(journal.Restored(e))
+	updateTrashedPaths(e.Path, e)
+	showChangedTree(app)
+	setInfo(fmt.Sprintf("Restored %s", e.Path))
+	if rel, ok := treeRelPath(e.Path); ok && rel != "." {
+		if n := originalRootNode.Lookup(strings.Split(filepath.ToSlash(rel), "/")...); n != nil {
+			selectNode(app, n)
+		}
+	}
+}
+
+// askPurgeTrashEntry asks whether to delete the entry e from the trash for
+// good, and shows the trash again afterwards.
+func askPurgeTrashEntry(app *tview.Application, can *trash.Can, e *trash.Entry) {
+	modal := tview.NewModal().
+		SetText(fmt.Sprintf("Are you sure to delete %q from the trash for good?", e.Path)).
+		AddButtons([]string{"Cancel", "Yes"}).
+		SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+			if buttonLabel != "Yes" {
+				showTrash(app)
+				return
+			}
+			if err := can.Purge(e); err != nil {
+				log.Errorf("Could not delete %q from the trash: %v", e.File(), err)
+				isFormInputActive = false
+				showMessage(app, fmt.Sprintf("Could not delete %q from the trash: %v", e.Path, err), nil)
+				return
+			}
+			log.Infof("Deleted %s from the trash", e.File())
+			recordOp(journal.Removed(e.File()))
+			updateTrashedPaths(e.File(), e)
+			originalRootNode = currBuilder.Root()
+			reloadTreeView(app, originalRootNode)
+			setInfo(fmt.Sprintf("Deleted %s from the trash", e.Path))
+			showTrash(app)
+		})
+	app.SetRoot(modal, true).SetFocus(modal)
+}
@@ -0,0 +1,132 @@
+// Package archive reads the list of entries of archive files, so they can be
+// scanned like directories, and creates archives of files and folders. Only
+// the metadata of the entries is read; their contents are not extracted.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	Zip   = "zip"
+	Tar   = "tar"
+	TarGz = "tar.gz"
+)
+
+var formatSuffixes = []struct {
+	suffix string
+	format string
+}{
+	{suffix: ".zip", format: Zip},
+	{suffix: ".jar", format: Zip},
+	{suffix: ".tar", format: Tar},
+	{suffix: ".tar.gz", format: TarGz},
+	{suffix: ".tgz", format: TarGz},
+}
+
+// Format returns the format of the archive file with the given name, judged
+// by its extension, or "" if it is not a supported archive.
+func Format(name string) string {
+	name = strings.ToLower(name)
+	for _, f := range formatSuffixes {
+		if strings.HasSuffix(name, f.suffix) {
+			return f.format
+		}
+	}
+	return ""
+}
+
+// Open reads the entries of the archive file at path. The returned file
+// system lists the entries; the size on disk of an entry is its compressed
+// size.
+func Open(path string) (*FS, error) {
+	format := Format(path)
+	switch format {
+	case Zip:
+		return openZip(path)
+	case Tar, TarGz:
+		return openTar(path, format)
+	}
+	return nil, fmt.Errorf("%s is not a supported archive", path)
+}
+
+func openZip(path string) (*FS, error) {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	fsys := newFS(Zip)
+	for _, f := range r.File {
+		fsys.add(f.Name, &entry{
+			mode:       f.Mode(),
+			size:       int64(f.UncompressedSize64),
+			compressed: int64(f.CompressedSize64),
+			modTime:    f.Modified,
+		})
+	}
+	return fsys, nil
+}
+
+func openTar(path, format string) (*FS, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if format == TarGz {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	fsys := newFS(format)
+	tr := tar.NewReader(r)
+	var total int64
+	var entries []*entry
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		e := &entry{
+			mode:    h.FileInfo().Mode(),
+			size:    h.Size,
+			modTime: h.ModTime,
+			target:  h.Linkname,
+		}
+		if h.Typeflag == tar.TypeLink {
+			// Hard links take no space in the archive.
+			e.size = 0
+		}
+		fsys.add(h.Name, e)
+		total += e.size
+		entries = append(entries, e)
+	}
+	// The entries of a tar file are compressed as a whole, so the size each
+	// of them takes in the archive can only be estimated.
+	ratio := 1.0
+	if format == TarGz && total > 0 {
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		ratio = float64(fi.Size()) / float64(total)
+	}
+	for _, e := range entries {
+		e.compressed = int64(float64(e.size) * ratio)
+	}
+	return fsys, nil
+}
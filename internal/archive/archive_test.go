@@ -0,0 +1,208 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testEntries = []struct {
+	name string
+	size int
+}{
+	{name: "README", size: 100},
+	{name: "src/main.go", size: 2000},
+	{name: "src/lib/util.go", size: 300},
+	{name: "empty/", size: 0},
+}
+
+func writeZip(t *testing.T, name string) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range testEntries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(bytes.Repeat([]byte("a"), e.size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTar(t *testing.T, name string, compress bool) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(&buf)
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	for _, e := range testEntries {
+		h := &tar.Header{Name: e.name, Mode: 0o644, Size: int64(e.size), Typeflag: tar.TypeReg}
+		if e.name[len(e.name)-1] == '/' {
+			h.Typeflag, h.Mode = tar.TypeDir, 0o755
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(bytes.Repeat([]byte("a"), e.size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "link", Linkname: "README", Typeflag: tar.TypeSymlink, Mode: 0o777}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for name, want := range map[string]string{
+		"a.zip":    Zip,
+		"A.JAR":    Zip,
+		"a.tar":    Tar,
+		"a.tar.gz": TarGz,
+		"a.tgz":    TarGz,
+		"a.gz":     "",
+		"zip":      "",
+	} {
+		assert.Equal(t, want, Format(name), name)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	writeZip(t, filepath.Join(dir, "test.zip"))
+	writeTar(t, filepath.Join(dir, "test.tar"), false)
+	writeTar(t, filepath.Join(dir, "test.tar.gz"), true)
+
+	for _, name := range []string{"test.zip", "test.tar", "test.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			fsys, err := Open(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			assert.Equal(t, Format(name), fsys.Format())
+
+			wantFiles := []string{"README", "empty", "src", "src/lib", "src/lib/util.go", "src/main.go"}
+			if name != "test.zip" {
+				wantFiles = append(wantFiles, "link")
+			}
+			sort.Strings(wantFiles)
+			var files []string
+			err = iofs.WalkDir(fsys, ".", func(path string, d iofs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if path != "." {
+					files = append(files, path)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("WalkDir: %v", err)
+			}
+			assert.Equal(t, wantFiles, files)
+
+			fi, err := fsys.Lstat("src/main.go")
+			if err != nil {
+				t.Fatalf("Lstat: %v", err)
+			}
+			assert.Equal(t, int64(2000), fi.Size())
+			compressed, err := fsys.DiskUsage().GetSizeOnDisk(fi)
+			if err != nil {
+				t.Fatalf("GetSizeOnDisk: %v", err)
+			}
+			if name == "test.tar" {
+				assert.Equal(t, int64(2000), compressed)
+			} else {
+				assert.Less(t, compressed, int64(2000), "compressed size")
+			}
+		})
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "lib"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "lib", "util.go"), bytes.Repeat([]byte("a"), 300), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	readme := filepath.Join(dir, "README")
+	if err := os.WriteFile(readme, bytes.Repeat([]byte("a"), 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"out.zip", "out.tar", "out.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			// The archive is written into one of the folders it archives.
+			path := filepath.Join(src, name)
+			if err := Create(path, []string{src, readme}); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			defer os.Remove(path)
+			fsys, err := Open(path)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			var files []string
+			err = iofs.WalkDir(fsys, ".", func(path string, d iofs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if path != "." {
+					files = append(files, path)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("WalkDir: %v", err)
+			}
+			assert.Equal(t, []string{"README", "src", "src/lib", "src/lib/util.go"}, files)
+			fi, err := fsys.Lstat("src/lib/util.go")
+			if err != nil {
+				t.Fatalf("Lstat: %v", err)
+			}
+			assert.Equal(t, int64(300), fi.Size())
+
+			assert.Error(t, Create(path, []string{readme}), "existing archive")
+		})
+	}
+
+	t.Run("failure", func(t *testing.T) {
+		path := filepath.Join(dir, "broken.zip")
+		assert.Error(t, Create(path, []string{readme, filepath.Join(dir, "missing")}))
+		_, err := os.Lstat(path)
+		assert.True(t, os.IsNotExist(err), "partly written archive is removed")
+	})
+	assert.Error(t, Create(filepath.Join(dir, "out.rar"), []string{readme}))
+}
@@ -0,0 +1,178 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Create writes the files and folders srcs, with everything in them, into a
+// new archive at path, in the format its extension names. Each of srcs is
+// stored under its base name. path must not exist yet, and is left out if it
+// is inside one of srcs. If writing fails, the partly written archive is
+// removed.
+func Create(path string, srcs []string) (err error) {
+	format := Format(path)
+	if format == "" {
+		return fmt.Errorf("%s is not a supported archive", path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+	var w writer
+	switch format {
+	case Zip:
+		w = &zipWriter{zw: zip.NewWriter(f)}
+	case Tar:
+		w = &tarWriter{tw: tar.NewWriter(f)}
+	case TarGz:
+		zw := gzip.NewWriter(f)
+		w = &tarWriter{tw: tar.NewWriter(zw), zw: zw}
+	}
+	for _, src := range srcs {
+		if err := addTree(w, src, filepath.Base(src), abs); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// writer adds entries to an archive. name uses forward slashes.
+type writer interface {
+	add(name string, fi os.FileInfo, path string) error
+	Close() error
+}
+
+// addTree adds the file or folder at path to w under name, and everything
+// in it, except the archive being written at skip.
+func addTree(w writer, path, name, skip string) error {
+	if abs, err := filepath.Abs(path); err == nil && abs == skip {
+		return nil
+	}
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if err := w.add(name, fi, path); err != nil {
+		return fmt.Errorf("could not add %s: %v", path, err)
+	}
+	if !fi.IsDir() {
+		return nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := addTree(w, filepath.Join(path, e.Name()), name+"/"+e.Name(), skip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (w *zipWriter) add(name string, fi os.FileInfo, path string) error {
+	h, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+	h.Name = name
+	if fi.IsDir() {
+		h.Name += "/"
+	} else {
+		h.Method = zip.Deflate
+	}
+	ew, err := w.zw.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		// Zip stores the target of a symbolic link as its contents.
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(ew, target)
+		return err
+	case fi.Mode().IsRegular():
+		return copyFile(ew, path)
+	}
+	return nil
+}
+
+func (w *zipWriter) Close() error {
+	return w.zw.Close()
+}
+
+type tarWriter struct {
+	tw *tar.Writer
+	zw *gzip.Writer
+}
+
+func (w *tarWriter) add(name string, fi os.FileInfo, path string) error {
+	var target string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if target, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+	h, err := tar.FileInfoHeader(fi, target)
+	if err != nil {
+		return err
+	}
+	h.Name = name
+	if fi.IsDir() {
+		h.Name += "/"
+	}
+	if err := w.tw.WriteHeader(h); err != nil {
+		return err
+	}
+	if fi.Mode().IsRegular() {
+		return copyFile(w.tw, path)
+	}
+	return nil
+}
+
+func (w *tarWriter) Close() error {
+	err := w.tw.Close()
+	if w.zw != nil {
+		if zerr := w.zw.Close(); err == nil {
+			err = zerr
+		}
+	}
+	return err
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
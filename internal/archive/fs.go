@@ -0,0 +1,189 @@
+package archive
+
+import (
+	"io"
+	iofs "io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"go.sazak.io/gls/internal/size"
+)
+
+// FS is the read-only file system of the entries of an archive. Opened
+// files can be listed and described, but not read.
+type FS struct {
+	format  string
+	entries map[string]*entry
+}
+
+type entry struct {
+	name       string
+	mode       iofs.FileMode
+	size       int64
+	compressed int64
+	modTime    time.Time
+	// target is the target of a symbolic link.
+	target   string
+	children map[string]*entry
+}
+
+func newFS(format string) *FS {
+	return &FS{
+		format: format,
+		entries: map[string]*entry{
+			".": {name: ".", mode: iofs.ModeDir | 0o755, children: make(map[string]*entry)},
+		},
+	}
+}
+
+// Format returns the format of the archive.
+func (fsys *FS) Format() string {
+	return fsys.format
+}
+
+// add adds the entry with the given name in the archive, along with any
+// parent directories the archive does not list.
+func (fsys *FS) add(name string, e *entry) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" || !iofs.ValidPath(name) {
+		return
+	}
+	e.name = path.Base(name)
+	if e.mode.IsDir() {
+		if old, ok := fsys.entries[name]; ok && old.mode.IsDir() {
+			// Implied by an earlier entry below it.
+			e.children = old.children
+		} else {
+			e.children = make(map[string]*entry)
+		}
+	}
+	fsys.entries[name] = e
+	fsys.parent(name).children[e.name] = e
+}
+
+func (fsys *FS) parent(name string) *entry {
+	dir := path.Dir(name)
+	if p, ok := fsys.entries[dir]; ok && p.mode.IsDir() {
+		return p
+	}
+	p := &entry{name: path.Base(dir), mode: iofs.ModeDir | 0o755, children: make(map[string]*entry)}
+	fsys.entries[dir] = p
+	fsys.parent(dir).children[p.name] = p
+	return p
+}
+
+func (fsys *FS) lookup(op, name string) (*entry, error) {
+	if !iofs.ValidPath(name) {
+		return nil, &iofs.PathError{Op: op, Path: name, Err: iofs.ErrInvalid}
+	}
+	e, ok := fsys.entries[name]
+	if !ok {
+		return nil, &iofs.PathError{Op: op, Path: name, Err: iofs.ErrNotExist}
+	}
+	return e, nil
+}
+
+func (fsys *FS) Open(name string) (iofs.File, error) {
+	e, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &file{e: e}, nil
+}
+
+// Lstat returns the entry with the given name. Symbolic links are never
+// followed, since their targets need not be part of the archive.
+func (fsys *FS) Lstat(name string) (iofs.FileInfo, error) {
+	e, err := fsys.lookup("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{e}, nil
+}
+
+func (fsys *FS) ReadLink(name string) (string, error) {
+	e, err := fsys.lookup("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	if e.mode&iofs.ModeSymlink == 0 {
+		return "", &iofs.PathError{Op: "readlink", Path: name, Err: iofs.ErrInvalid}
+	}
+	return e.target, nil
+}
+
+// DiskUsage reports the compressed size of an entry as its size on disk.
+func (fsys *FS) DiskUsage() size.DiskUsage {
+	return diskUsage{}
+}
+
+type diskUsage struct {
+	size.ApparentInfo
+}
+
+func (diskUsage) GetSizeOnDisk(f iofs.FileInfo) (int64, error) {
+	if fi, ok := f.(fileInfo); ok {
+		return fi.e.compressed, nil
+	}
+	return f.Size(), nil
+}
+
+type fileInfo struct {
+	e *entry
+}
+
+func (fi fileInfo) Name() string                 { return fi.e.name }
+func (fi fileInfo) Size() int64                  { return fi.e.size }
+func (fi fileInfo) Mode() iofs.FileMode          { return fi.e.mode }
+func (fi fileInfo) ModTime() time.Time           { return fi.e.modTime }
+func (fi fileInfo) IsDir() bool                  { return fi.e.mode.IsDir() }
+func (fi fileInfo) Sys() interface{}             { return nil }
+func (fi fileInfo) Info() (iofs.FileInfo, error) { return fi, nil }
+func (fi fileInfo) Type() iofs.FileMode          { return fi.e.mode.Type() }
+
+type file struct {
+	e *entry
+	// unread are the directory entries not returned by ReadDir yet, once
+	// listed is set by its first call.
+	unread []iofs.DirEntry
+	listed bool
+}
+
+func (f *file) Stat() (iofs.FileInfo, error) {
+	return fileInfo{f.e}, nil
+}
+
+func (f *file) Read([]byte) (int, error) {
+	return 0, &iofs.PathError{Op: "read", Path: f.e.name, Err: iofs.ErrPermission}
+}
+
+func (f *file) Close() error {
+	return nil
+}
+
+// ReadDir lists the entries of a directory in name order.
+func (f *file) ReadDir(n int) ([]iofs.DirEntry, error) {
+	if !f.e.mode.IsDir() {
+		return nil, &iofs.PathError{Op: "readdir", Path: f.e.name, Err: iofs.ErrInvalid}
+	}
+	if !f.listed {
+		f.listed = true
+		for _, c := range f.e.children {
+			f.unread = append(f.unread, fileInfo{c})
+		}
+		sort.Slice(f.unread, func(i, j int) bool {
+			return f.unread[i].Name() < f.unread[j].Name()
+		})
+	}
+	if n > 0 && len(f.unread) == 0 {
+		return nil, io.EOF
+	}
+	if n <= 0 || n > len(f.unread) {
+		n = len(f.unread)
+	}
+	entries := f.unread[:n]
+	f.unread = f.unread[n:]
+	return entries, nil
+}
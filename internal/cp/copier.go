@@ -0,0 +1,489 @@
+package cp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.sazak.io/gls/internal/size"
+)
+
+// progressInterval is how often a Copier reports its progress at most.
+const progressInterval = 100 * time.Millisecond
+
+// Preserve selects what a Copier keeps of the source besides the contents
+// and the permissions.
+type Preserve uint
+
+const (
+	// PreserveTimes keeps the modification and access times.
+	PreserveTimes Preserve = 1 << iota
+	// PreserveOwner keeps the owner and the group, as far as the user is
+	// allowed to set them.
+	PreserveOwner
+	// PreserveSymlinks copies symbolic links as links, instead of what they
+	// point to.
+	PreserveSymlinks
+	// PreserveHardLinks links the copies of files that are hard links of
+	// each other in the source the same way.
+	PreserveHardLinks
+	// PreserveSparse keeps the holes of sparse files on Linux and macOS,
+	// instead of writing them out as zeros.
+	PreserveSparse
+	// PreserveXattrs keeps the extended attributes on Linux and macOS.
+	PreserveXattrs
+
+	// PreserveAll keeps everything, like cp -a.
+	PreserveAll = PreserveTimes | PreserveOwner | PreserveSymlinks | PreserveHardLinks | PreserveSparse | PreserveXattrs
+)
+
+// Conflict tells a Copier what to do when something is in the way of a copy.
+type Conflict int
+
+const (
+	// Overwrite replaces files in the way, and copies into folders in the
+	// way.
+	Overwrite Conflict = iota
+	// Skip leaves files in the way alone, and copies into folders in the
+	// way.
+	Skip
+	// OverwriteIfNewer replaces files in the way that were modified before
+	// the source, and copies into folders in the way.
+	OverwriteIfNewer
+	// Rename copies to a free name next to what is in the way, like
+	// "notes (2).txt".
+	Rename
+)
+
+var conflictNames = []string{
+	Overwrite:        "overwrite",
+	Skip:             "skip",
+	OverwriteIfNewer: "overwrite-if-newer",
+	Rename:           "rename",
+}
+
+// ConflictNames returns the names of the conflict policies, as ParseConflict
+// takes them.
+func ConflictNames() []string {
+	return append([]string(nil), conflictNames...)
+}
+
+// ParseConflict returns the conflict policy with the given name.
+func ParseConflict(name string) (Conflict, error) {
+	for c, n := range conflictNames {
+		if strings.EqualFold(name, n) {
+			return Conflict(c), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid conflict policy %q, use one of %s", name, strings.Join(conflictNames, ", "))
+}
+
+func (c Conflict) String() string {
+	if c < 0 || int(c) >= len(conflictNames) {
+		return fmt.Sprintf("Conflict(%d)", int(c))
+	}
+	return conflictNames[c]
+}
+
+// Progress is the state of a copy, as reported to a ProgressFunc.
+type Progress struct {
+	// Entries is the number of files, folders and links copied so far, and
+	// Bytes the size of the copied files.
+	Entries int64
+	Bytes   int64
+	// Skipped is the number of entries left alone for a conflict, and
+	// Errors the number of entries that could not be copied.
+	Skipped int64
+	Errors  int64
+	// Current is the path being copied.
+	Current string
+	// Done is set on the last report.
+	Done bool
+}
+
+// ProgressFunc is called with the progress of a copy.
+type ProgressFunc func(Progress)
+
+// Copier copies files and folders. Its zero value is not usable, New
+// returns one.
+type Copier struct {
+	preserve Preserve
+	conflict Conflict
+	progress ProgressFunc
+}
+
+// Option configures a Copier.
+type Option func(*Copier)
+
+// WithPreserve sets what is kept of the source besides the contents and the
+// permissions. Nothing else is kept by default.
+func WithPreserve(p Preserve) Option {
+	return func(c *Copier) {
+		c.preserve = p
+	}
+}
+
+// WithConflict sets what to do when something is in the way of a copy. It
+// is Overwrite by default.
+func WithConflict(conflict Conflict) Option {
+	return func(c *Copier) {
+		c.conflict = conflict
+	}
+}
+
+// WithProgress sets f to be called with the progress of a copy, at most
+// every progressInterval and once at the end.
+func WithProgress(f ProgressFunc) Option {
+	return func(c *Copier) {
+		c.progress = f
+	}
+}
+
+// New returns a Copier configured by opts.
+func New(opts ...Option) *Copier {
+	c := &Copier{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Result is the outcome of a copy.
+type Result struct {
+	// Path is where the source was copied to, which differs from the
+	// destination if it was renamed for a conflict. It is "" if the source
+	// was skipped or could not be copied.
+	Path string
+	// Existed is set if Path was in the way and was overwritten or copied
+	// into.
+	Existed bool
+	Progress
+	// Errs are the errors of the entries that could not be copied, which
+	// the copy went on without.
+	Errs []error
+}
+
+// Err returns an error describing Errs, or nil if there are none.
+func (r *Result) Err() error {
+	switch len(r.Errs) {
+	case 0:
+		return nil
+	case 1:
+		return r.Errs[0]
+	}
+	return fmt.Errorf("%d entries could not be copied, the first: %v", len(r.Errs), r.Errs[0])
+}
+
+// Copy copies the file or folder src to dst, with everything in it. It goes
+// on after errors of single entries, which are returned in the result. The
+// returned error is set if src cannot be read at all, dst is inside src, or
+// ctx is done.
+func (c *Copier) Copy(ctx context.Context, dst, src string) (*Result, error) {
+	fi, err := c.stat(src)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		if err := CheckInside(dst, src); err != nil {
+			return nil, err
+		}
+	}
+	r := &run{Copier: c, ctx: ctx, res: &Result{}, links: make(map[size.FileID]string)}
+	r.res.Path, r.res.Existed = r.copy(dst, src, fi)
+	r.report(true)
+	return r.res, ctx.Err()
+}
+
+// stat returns the file info of path, following a symbolic link unless
+// links are kept.
+func (c *Copier) stat(path string) (os.FileInfo, error) {
+	if c.preserve&PreserveSymlinks != 0 {
+		return os.Lstat(path)
+	}
+	return os.Stat(path)
+}
+
+// CheckInside returns an error if dst is the folder src or inside it, where
+// src cannot be copied or moved to, since the copy would never end.
+func CheckInside(dst, src string) error {
+	absSrc, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	absDst, err := filepath.Abs(dst)
+	if err != nil {
+		return err
+	}
+	if absDst == absSrc || strings.HasPrefix(absDst, absSrc+string(filepath.Separator)) {
+		return fmt.Errorf("cannot copy or move %s into itself", src)
+	}
+	return nil
+}
+
+// run is the state of one Copy.
+type run struct {
+	*Copier
+	ctx context.Context
+	res *Result
+	// links maps the source files with more than one link to their copies.
+	links      map[size.FileID]string
+	lastReport time.Time
+}
+
+func (r *run) fail(err error) {
+	r.res.Errors++
+	r.res.Errs = append(r.res.Errs, err)
+	r.report(false)
+}
+
+func (r *run) report(done bool) {
+	if r.progress == nil {
+		return
+	}
+	if !done && time.Since(r.lastReport) < progressInterval {
+		return
+	}
+	r.lastReport = time.Now()
+	p := r.res.Progress
+	p.Done = done
+	r.progress(p)
+}
+
+// copy copies src, described by fi, to dst. It returns the path it copied
+// to, or "" if it did not, and whether that path was in the way.
+func (r *run) copy(dst, src string, fi os.FileInfo) (string, bool) {
+	if r.ctx.Err() != nil {
+		return "", false
+	}
+	r.res.Current = src
+	dst, existed, ok := r.resolve(dst, fi)
+	if !ok {
+		return "", false
+	}
+	var err error
+	switch {
+	case fi.IsDir():
+		err = r.copyDir(dst, src, fi, existed)
+	case fi.Mode()&os.ModeSymlink != 0:
+		err = r.replace(dst, existed, func(path string) error {
+			return r.copySymlink(path, src, fi)
+		})
+	case fi.Mode().IsRegular():
+		err = r.replace(dst, existed, func(path string) error {
+			return r.copyFile(path, src, fi)
+		})
+	default:
+		err = fmt.Errorf("%s is not a regular file, folder or link", src)
+	}
+	if err != nil {
+		r.fail(err)
+		return "", false
+	}
+	r.res.Entries++
+	r.report(false)
+	return dst, existed
+}
+
+// resolve applies the conflict policy to dst, the copy of the file
+// described by fi. It returns the path to copy to, whether something is in
+// the way there, and whether to copy at all.
+func (r *run) resolve(dst string, fi os.FileInfo) (string, bool, bool) {
+	dfi, err := os.Lstat(dst)
+	if os.IsNotExist(err) {
+		return dst, false, true
+	}
+	if err != nil {
+		r.fail(err)
+		return "", false, false
+	}
+	if r.conflict == Rename {
+		free, err := freeName(dst)
+		if err != nil {
+			r.fail(err)
+			return "", false, false
+		}
+		return free, false, true
+	}
+	if fi.IsDir() && dfi.IsDir() {
+		return dst, true, true
+	}
+	switch {
+	case r.conflict == Skip,
+		r.conflict == OverwriteIfNewer && !fi.ModTime().After(dfi.ModTime()):
+		r.res.Skipped++
+		r.report(false)
+		return "", false, false
+	case dfi.IsDir():
+		r.fail(fmt.Errorf("%s is a folder, which is not overwritten by a file", dst))
+		return "", false, false
+	case fi.IsDir():
+		// The folder takes the place of the file.
+		if err := os.Remove(dst); err != nil {
+			r.fail(err)
+			return "", false, false
+		}
+		return dst, false, true
+	}
+	return dst, true, true
+}
+
+// freeName returns the first path like "name (2).ext" next to path that is
+// not taken.
+func freeName(path string) (string, error) {
+	dir, base := filepath.Split(path)
+	stem, ext := base, ""
+	// Keep multiple extensions like .tar.gz, but not the dot of a dot file.
+	if i := strings.Index(base[1:], "."); i >= 0 {
+		stem, ext = base[:i+1], base[i+1:]
+	}
+	for n := 2; n < 10000; n++ {
+		p := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, n, ext))
+		if _, err := os.Lstat(p); os.IsNotExist(err) {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("no free name for %s", path)
+}
+
+// replace calls write to make the file at dst. If something is in the way
+// there, the file is made next to it and then renamed over it, so that it
+// stays in place if the copy fails.
+func (r *run) replace(dst string, existed bool, write func(path string) error) error {
+	if !existed {
+		return write(dst)
+	}
+	f, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	f.Close()
+	os.Remove(tmp)
+	if err := write(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (r *run) copyDir(dst, src string, fi os.FileInfo, existed bool) error {
+	if !existed {
+		// Writable until everything is in it. Missing folders above it are
+		// made too.
+		if err := os.MkdirAll(dst, 0o700); err != nil {
+			return err
+		}
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		r.fail(err)
+	}
+	for _, e := range entries {
+		if r.ctx.Err() != nil {
+			return nil
+		}
+		s := filepath.Join(src, e.Name())
+		efi, err := r.stat(s)
+		if err != nil {
+			r.fail(err)
+			continue
+		}
+		r.copy(filepath.Join(dst, e.Name()), s, efi)
+	}
+	if existed {
+		// A folder that was in the way keeps what it was like.
+		return nil
+	}
+	if err := os.Chmod(dst, fi.Mode()&os.ModePerm|fi.Mode()&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	return r.copyMeta(dst, src, fi)
+}
+
+func (r *run) copySymlink(dst, src string, fi os.FileInfo) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	if err := os.Symlink(target, dst); err != nil {
+		return err
+	}
+	return r.copyMeta(dst, src, fi)
+}
+
+func (r *run) copyFile(dst, src string, fi os.FileInfo) error {
+	var id size.FileID
+	if r.preserve&PreserveHardLinks != 0 {
+		usage := size.FsInfo{}
+		if n, err := usage.GetLinkCount(fi); err == nil && n > 1 {
+			id, _ = usage.GetFileID(fi)
+		}
+		if first, ok := r.links[id]; ok && id != (size.FileID{}) {
+			if err := os.Link(first, dst); err == nil {
+				return nil
+			}
+			// Links across file systems fail, so copy it once more.
+		}
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	var n int64
+	if r.preserve&PreserveSparse != 0 {
+		n, err = copySparse(out, in, fi.Size())
+	} else {
+		n, err = io.Copy(out, in)
+	}
+	if err == nil && n != fi.Size() {
+		err = fmt.Errorf("copied %d of %d bytes of %s", n, fi.Size(), src)
+	}
+	if err == nil {
+		err = out.Chmod(fi.Mode()&os.ModePerm | fi.Mode()&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky))
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	r.res.Bytes += n
+	if id != (size.FileID{}) {
+		r.links[id] = dst
+	}
+	return r.copyMeta(dst, src, fi)
+}
+
+// copyMeta copies what is to be preserved of src, described by fi, to dst,
+// besides the permissions. The times come last, since the rest may change
+// them.
+func (r *run) copyMeta(dst, src string, fi os.FileInfo) error {
+	if r.preserve&PreserveOwner != 0 {
+		if err := copyOwner(dst, fi); err != nil {
+			return err
+		}
+	}
+	if r.preserve&PreserveXattrs != 0 {
+		if err := copyXattrs(dst, src); err != nil {
+			return err
+		}
+	}
+	if r.preserve&PreserveTimes != 0 {
+		return copyTimes(dst, src, fi)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package cp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.sazak.io/gls/internal/size"
+)
+
+var oldTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func writeTestFile(t *testing.T, path, content string, mtime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCopierPreserve(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	writeTestFile(t, filepath.Join(src, "sub", "a.txt"), "a", oldTime)
+	assert.Nil(t, os.Link(filepath.Join(src, "sub", "a.txt"), filepath.Join(src, "b.txt")))
+	assert.Nil(t, os.Symlink("sub/a.txt", filepath.Join(src, "link")))
+	// A sparse file with a little data at the start and a hole after it.
+	sparse := filepath.Join(src, "sparse")
+	writeTestFile(t, sparse, "data", oldTime)
+	assert.Nil(t, os.Truncate(sparse, 8<<20))
+	assert.Nil(t, os.Chtimes(filepath.Join(src, "sub"), oldTime, oldTime))
+
+	var last Progress
+	c := New(WithPreserve(PreserveAll), WithProgress(func(p Progress) { last = p }))
+	res, err := c.Copy(context.Background(), dst, src)
+	if err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	assert.Empty(t, res.Errs)
+	assert.Equal(t, dst, res.Path)
+	assert.False(t, res.Existed)
+	assert.True(t, last.Done)
+	assert.Equal(t, int64(6), last.Entries)
+
+	fi, err := os.Stat(filepath.Join(dst, "sub", "a.txt"))
+	if assert.Nil(t, err) {
+		assert.True(t, fi.ModTime().Equal(oldTime), "file time")
+		assert.Equal(t, os.FileMode(0o640), fi.Mode().Perm())
+	}
+	if fi, err := os.Stat(filepath.Join(dst, "sub")); assert.Nil(t, err) {
+		assert.True(t, fi.ModTime().Equal(oldTime), "folder time")
+	}
+	target, err := os.Readlink(filepath.Join(dst, "link"))
+	assert.Nil(t, err)
+	assert.Equal(t, "sub/a.txt", target)
+	if runtime.GOOS != "windows" {
+		a, _ := os.Stat(filepath.Join(dst, "sub", "a.txt"))
+		b, _ := os.Stat(filepath.Join(dst, "b.txt"))
+		assert.True(t, os.SameFile(a, b), "hard links")
+
+		srcFi, _ := os.Stat(sparse)
+		dstFi, _ := os.Stat(filepath.Join(dst, "sparse"))
+		assert.Equal(t, srcFi.Size(), dstFi.Size())
+		srcOnDisk, _ := size.FsInfo{}.GetSizeOnDisk(srcFi)
+		dstOnDisk, _ := size.FsInfo{}.GetSizeOnDisk(dstFi)
+		if srcOnDisk < srcFi.Size() {
+			assert.Less(t, dstOnDisk, dstFi.Size(), "holes")
+		}
+	}
+
+	// Without options, links are followed and only the contents are kept.
+	plain := filepath.Join(dir, "plain")
+	res, err = New().Copy(context.Background(), plain, src)
+	if err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	assert.Empty(t, res.Errs)
+	assert.Equal(t, "a", readTestFile(t, filepath.Join(plain, "link")))
+	if fi, err := os.Stat(filepath.Join(plain, "sub", "a.txt")); assert.Nil(t, err) {
+		assert.False(t, fi.ModTime().Equal(oldTime), "file time")
+	}
+
+	_, err = c.Copy(context.Background(), filepath.Join(src, "sub", "copy"), src)
+	assert.NotNil(t, err, "copied into itself")
+}
+
+func TestCopierConflict(t *testing.T) {
+	testCases := []struct {
+		conflict Conflict
+		// want are the contents of old.txt, new.txt and only.txt in dst
+		// after the copy, and path where the copy went.
+		want    [3]string
+		path    string
+		skipped int64
+	}{
+		{conflict: Overwrite, want: [3]string{"src", "src", "src"}, path: "dst"},
+		{conflict: Skip, want: [3]string{"dst", "dst", "src"}, path: "dst", skipped: 2},
+		{conflict: OverwriteIfNewer, want: [3]string{"src", "dst", "src"}, path: "dst", skipped: 1},
+		{conflict: Rename, want: [3]string{"dst", "dst", ""}, path: "dst (2)"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.conflict.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+			newTime := oldTime.Add(time.Hour)
+			writeTestFile(t, filepath.Join(src, "old.txt"), "src", newTime)
+			writeTestFile(t, filepath.Join(src, "new.txt"), "src", oldTime)
+			writeTestFile(t, filepath.Join(src, "only.txt"), "src", oldTime)
+			writeTestFile(t, filepath.Join(dst, "old.txt"), "dst", oldTime)
+			writeTestFile(t, filepath.Join(dst, "new.txt"), "dst", newTime)
+
+			res, err := New(WithConflict(tc.conflict)).Copy(context.Background(), dst, src)
+			if err != nil {
+				t.Fatalf("Copy: %v", err)
+			}
+			assert.Empty(t, res.Errs)
+			assert.Equal(t, filepath.Join(dir, tc.path), res.Path)
+			assert.Equal(t, tc.conflict != Rename, res.Existed)
+			assert.Equal(t, tc.skipped, res.Skipped)
+			for i, name := range []string{"old.txt", "new.txt", "only.txt"} {
+				got := ""
+				if b, err := os.ReadFile(filepath.Join(dst, name)); err == nil {
+					got = string(b)
+				}
+				assert.Equal(t, tc.want[i], got, name)
+			}
+			if tc.conflict == Rename {
+				assert.Equal(t, "src", readTestFile(t, filepath.Join(dir, "dst (2)", "old.txt")))
+			}
+		})
+	}
+
+	t.Run("file onto folder", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTestFile(t, filepath.Join(dir, "src"), "src", oldTime)
+		writeTestFile(t, filepath.Join(dir, "dst", "x"), "x", oldTime)
+		res, err := New().Copy(context.Background(), filepath.Join(dir, "dst"), filepath.Join(dir, "src"))
+		assert.Nil(t, err)
+		assert.Len(t, res.Errs, 1)
+		assert.Equal(t, "", res.Path)
+	})
+}
+
+func TestCopierGoesOnAfterErrors(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("file permissions do not keep the test from reading")
+	}
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	writeTestFile(t, filepath.Join(src, "a"), "a", oldTime)
+	writeTestFile(t, filepath.Join(src, "b"), "b", oldTime)
+	writeTestFile(t, filepath.Join(src, "c"), "c", oldTime)
+	assert.Nil(t, os.Chmod(filepath.Join(src, "b"), 0))
+
+	res, err := New().Copy(context.Background(), dst, src)
+	if err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	assert.Len(t, res.Errs, 1)
+	assert.NotNil(t, res.Err())
+	assert.Equal(t, "a", readTestFile(t, filepath.Join(dst, "a")))
+	assert.Equal(t, "c", readTestFile(t, filepath.Join(dst, "c")))
+	_, err = os.Lstat(filepath.Join(dst, "b"))
+	assert.True(t, os.IsNotExist(err), "half copied file is removed")
+
+	// Tree removes what it copied if not everything could be.
+	assert.NotNil(t, Tree(context.Background(), filepath.Join(dir, "tree"), src))
+	_, err = os.Lstat(filepath.Join(dir, "tree"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFreeName(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.tar.gz", "a (2).tar.gz", ".bashrc", "dir"} {
+		writeTestFile(t, filepath.Join(dir, name), "", oldTime)
+	}
+	for name, want := range map[string]string{
+		"a.tar.gz": "a (3).tar.gz",
+		".bashrc":  ".bashrc (2)",
+		"dir":      "dir (2)",
+	} {
+		got, err := freeName(filepath.Join(dir, name))
+		assert.Nil(t, err)
+		assert.Equal(t, filepath.Join(dir, want), got)
+	}
+}
+
+func TestParseConflict(t *testing.T) {
+	for _, name := range ConflictNames() {
+		c, err := ParseConflict(name)
+		assert.Nil(t, err)
+		assert.Equal(t, name, c.String())
+	}
+	c, err := ParseConflict("Overwrite-If-Newer")
+	assert.Nil(t, err)
+	assert.Equal(t, OverwriteIfNewer, c)
+	_, err = ParseConflict("merge")
+	assert.NotNil(t, err)
+}
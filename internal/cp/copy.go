@@ -1,72 +1,48 @@
 package cp
 
 import (
-	"errors"
-	"fmt"
-	"io"
-	"io/ioutil"
-	"math"
+	"context"
 	"os"
-	"path/filepath"
 )
 
-var errFolderExist = errors.New("folder exist")
-
 // File copies the given src file to dst location. It changes the mode with
-// existing file's mode.
+// existing file's mode. A file at dst is overwritten.
 func File(dst, src string) error {
-	srcFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer srcFile.Close()
+	return copyPlain(dst, src)
+}
 
-	dstFile, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer dstFile.Close()
+// Folder copies the given src folder to dst location recursively. Files at
+// dst are overwritten, and folders at dst are copied into.
+func Folder(dst, src string) error {
+	return copyPlain(dst, src)
+}
 
-	n, err := io.Copy(dstFile, srcFile)
+// copyPlain copies src to dst like cp without options: only the contents
+// and the modes are copied, and links are followed.
+func copyPlain(dst, src string) error {
+	res, err := New().Copy(context.Background(), dst, src)
 	if err != nil {
 		return err
 	}
-	srcStat, err := srcFile.Stat()
-	if n != srcStat.Size() {
-		return fmt.Errorf("src file couldn't copied to destination. %v byte(s) is missing", math.Abs(float64(n-srcStat.Size())))
-	}
-
-	return dstFile.Chmod(srcStat.Mode())
+	return res.Err()
 }
 
-// Folder copies the given src folder to dst location recursively.
-func Folder(dst, src string) error {
-	srcStat, err := os.Stat(src)
-	if err != nil {
-		return err
+// Tree copies the file or folder src to dst with everything that can be
+// kept of it, like cp -a. dst must not exist. If not everything could be
+// copied, what was copied is removed again.
+func Tree(ctx context.Context, dst, src string) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return os.ErrExist
 	}
-	err = os.MkdirAll(dst, srcStat.Mode())
-	if os.IsExist(err) {
-		return errFolderExist
+	res, err := New(WithPreserve(PreserveAll)).Copy(ctx, dst, src)
+	if err == nil {
+		err = res.Err()
 	}
-
-	files, err := ioutil.ReadDir(src)
 	if err != nil {
-		return err
-	}
-
-	for _, f := range files {
-		if f.IsDir() {
-			err = Folder(filepath.Join(dst, f.Name()), filepath.Join(src, f.Name()))
-			if err != nil {
-				return err
-			}
-		} else {
-			err = File(filepath.Join(dst, f.Name()), filepath.Join(src, f.Name()))
-			if err != nil {
-				return err
-			}
+		if res != nil && res.Path != "" {
+			os.RemoveAll(res.Path)
 		}
+		return err
 	}
 	return nil
 }
@@ -0,0 +1,33 @@
+//go:build !linux && !darwin
+
+package cp
+
+import (
+	"io"
+	"os"
+)
+
+// copyOwner does nothing, since owners are not copied on this platform.
+func copyOwner(dst string, fi os.FileInfo) error {
+	return nil
+}
+
+// copyXattrs does nothing, since extended attributes are not copied on this
+// platform.
+func copyXattrs(dst, src string) error {
+	return nil
+}
+
+// copyTimes gives dst the modification time of src, described by fi, also
+// as its access time. The times of links are left alone.
+func copyTimes(dst, src string, fi os.FileInfo) error {
+	if fi.Mode()&os.ModeSymlink != 0 {
+		return nil
+	}
+	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+}
+
+// copySparse copies in to out, writing out any holes.
+func copySparse(out, in *os.File, size int64) (int64, error) {
+	return io.Copy(out, in)
+}
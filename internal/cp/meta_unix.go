@@ -0,0 +1,126 @@
+//go:build linux || darwin
+
+package cp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyOwner gives dst the owner and the group of the file described by fi.
+// Other users' files are copied as the user's own, like cp -p does.
+func copyOwner(dst string, fi os.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("could not cast %T to syscall.Stat_t", fi.Sys())
+	}
+	if err := os.Lchown(dst, int(st.Uid), int(st.Gid)); err != nil && !isNotAllowed(err) {
+		return err
+	}
+	return nil
+}
+
+// copyXattrs copies the extended attributes of src to dst. Attributes that
+// the file system of dst does not support, or that only the superuser may
+// set, are left out.
+func copyXattrs(dst, src string) error {
+	sz, err := unix.Llistxattr(src, nil)
+	if err != nil || sz == 0 {
+		if isNotAllowed(err) {
+			return nil
+		}
+		return err
+	}
+	names := make([]byte, sz)
+	if sz, err = unix.Llistxattr(src, names); err != nil {
+		return err
+	}
+	for _, name := range strings.Split(string(names[:sz]), "\x00") {
+		if name == "" {
+			continue
+		}
+		n, err := unix.Lgetxattr(src, name, nil)
+		if err != nil {
+			return fmt.Errorf("could not read the attribute %s of %s: %v", name, src, err)
+		}
+		val := make([]byte, n)
+		if n, err = unix.Lgetxattr(src, name, val); err != nil {
+			return fmt.Errorf("could not read the attribute %s of %s: %v", name, src, err)
+		}
+		if err := unix.Lsetxattr(dst, name, val[:n], 0); err != nil && !isNotAllowed(err) {
+			return fmt.Errorf("could not copy the attribute %s of %s: %v", name, src, err)
+		}
+	}
+	return nil
+}
+
+// copyTimes gives dst the access and modification times of src, described
+// by fi, without following a link at dst.
+func copyTimes(dst, src string, fi os.FileInfo) error {
+	var st unix.Stat_t
+	var err error
+	if fi.Mode()&os.ModeSymlink != 0 {
+		err = unix.Lstat(src, &st)
+	} else {
+		err = unix.Stat(src, &st)
+	}
+	if err != nil {
+		return err
+	}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, dst, []unix.Timespec{st.Atim, st.Mtim}, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// copySparse copies the size bytes of in to out, skipping the holes of in,
+// which are left as holes in out. It falls back to copying everything if
+// the file system cannot tell where the holes are.
+func copySparse(out, in *os.File, size int64) (int64, error) {
+	var off int64
+	for off < size {
+		data, err := in.Seek(off, unix.SEEK_DATA)
+		if errors.Is(err, syscall.ENXIO) {
+			// Only a hole is left.
+			break
+		}
+		if err != nil && off == 0 {
+			if _, err := in.Seek(0, io.SeekStart); err != nil {
+				return 0, err
+			}
+			return io.Copy(out, in)
+		}
+		if err != nil {
+			return off, err
+		}
+		hole, err := in.Seek(data, unix.SEEK_HOLE)
+		if err != nil {
+			return off, err
+		}
+		if _, err := in.Seek(data, io.SeekStart); err != nil {
+			return off, err
+		}
+		if _, err := out.Seek(data, io.SeekStart); err != nil {
+			return off, err
+		}
+		if _, err := io.CopyN(out, in, hole-data); err != nil {
+			return data, err
+		}
+		off = hole
+	}
+	// A hole at the end has to be made by the size.
+	if err := out.Truncate(size); err != nil {
+		return off, err
+	}
+	return size, nil
+}
+
+// isNotAllowed reports whether err means that the file system does not
+// support something, or that only the superuser may do it.
+func isNotAllowed(err error) bool {
+	return errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) ||
+		(errors.Is(err, unix.EPERM) && os.Geteuid() != 0)
+}
@@ -0,0 +1,48 @@
+package cp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// errNotSameDevice is ERROR_NOT_SAME_DEVICE, which Windows returns for
+// renames across volumes.
+const errNotSameDevice = syscall.Errno(17)
+
+// rename is os.Rename, replaced in tests.
+var rename = os.Rename
+
+// Move moves the file or folder src to dst, which must not exist. Moves
+// across file systems, which cannot be renamed, fall back to copying src
+// and then deleting it. A copy that fails half way is deleted again, leaving
+// src in place. Everything that can be kept of src is, like with Tree.
+func Move(dst, src string) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	err := rename(src, dst)
+	if err == nil || !isCrossDevice(err) {
+		return err
+	}
+	if err := Tree(context.Background(), dst, src); err != nil {
+		return fmt.Errorf("could not copy %s to another file system: %v", src, err)
+	}
+	if err := os.RemoveAll(src); err != nil {
+		return fmt.Errorf("copied %s to %s, but could not delete it: %v", src, dst, err)
+	}
+	return nil
+}
+
+// isCrossDevice reports whether err is the error of a rename across file
+// systems.
+func isCrossDevice(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	return errno == syscall.EXDEV || (runtime.GOOS == "windows" && errno == errNotSameDevice)
+}
@@ -0,0 +1,58 @@
+package cp
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMove(t *testing.T) {
+	testCases := []struct {
+		name string
+		// rename replaces os.Rename, if set.
+		rename func(src, dst string) error
+	}{
+		{
+			name: "Move on the same file system",
+		},
+		{
+			name: "Move to another file system",
+			rename: func(src, dst string) error {
+				return &os.LinkError{Op: "rename", Old: src, New: dst, Err: syscall.EXDEV}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.rename != nil {
+				rename = tc.rename
+				defer func() { rename = os.Rename }()
+			}
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src")
+			assert.Nil(t, os.MkdirAll(filepath.Join(src, "sub"), 0o755))
+			assert.Nil(t, os.WriteFile(filepath.Join(src, "sub", "a.txt"), []byte("a"), 0o600))
+			assert.Nil(t, os.Symlink("sub/a.txt", filepath.Join(dir, "link")))
+
+			dst := filepath.Join(dir, "dst")
+			assert.Nil(t, Move(dst, src))
+			b, err := os.ReadFile(filepath.Join(dst, "sub", "a.txt"))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("a"), b)
+			_, err = os.Lstat(src)
+			assert.True(t, os.IsNotExist(err))
+
+			// Links are moved as links.
+			assert.Nil(t, Move(filepath.Join(dir, "moved-link"), filepath.Join(dir, "link")))
+			target, err := os.Readlink(filepath.Join(dir, "moved-link"))
+			assert.Nil(t, err)
+			assert.Equal(t, "sub/a.txt", target)
+
+			assert.NotNil(t, Move(dst, filepath.Join(dir, "moved-link")), "moved onto an existing file")
+		})
+	}
+}
@@ -0,0 +1,182 @@
+// Package diff compares two scans of the same path and computes how the size
+// of every file and folder changed between them.
+package diff
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"go.sazak.io/gls/internal/types"
+)
+
+type Kind int
+
+const (
+	Unchanged Kind = iota
+	Added
+	Removed
+	Grown
+	Shrunk
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Unchanged:
+		return "unchanged"
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Grown:
+		return "grown"
+	case Shrunk:
+		return "shrunk"
+	}
+	return fmt.Sprintf("Kind(%d)", int(k))
+}
+
+// Node is an entry of either scan, with its size on disk in both. The size
+// in the scan an entry is missing from is zero.
+type Node struct {
+	Name     string
+	IsDir    bool
+	Kind     Kind
+	OldSize  int64
+	NewSize  int64
+	Children []*Node
+	Parent   *Node
+}
+
+// Delta returns how much the size on disk of the entry changed.
+func (n *Node) Delta() int64 {
+	return n.NewSize - n.OldSize
+}
+
+// Path returns the path of the entry relative to the compared root.
+func (n *Node) Path() string {
+	if n.Parent == nil {
+		return n.Name
+	}
+	return filepath.Join(n.Parent.Path(), n.Name)
+}
+
+// Compare matches the entries of the old and new trees by name and returns
+// the tree of both, with the children of every folder sorted by the absolute
+// size change. The roots are matched whatever their names. Hidden entries,
+// left out for being ignored or below the size threshold, are matched like
+// the others, so that an entry that only became hidden or shown is not
+// reported as removed or added.
+func Compare(old, new *types.Node) *Node {
+	return compare(old, new, false, nil)
+}
+
+// compare matches old and new, and their entries unless noEntries is set,
+// for hidden folders, whose entries are not part of the tree.
+func compare(old, new *types.Node, noEntries bool, parent *Node) *Node {
+	n := &Node{Parent: parent}
+	switch {
+	case old == nil:
+		n.Name, n.IsDir, n.Kind, n.NewSize = new.Name, new.IsDir, Added, new.SizeOnDisk
+	case new == nil:
+		n.Name, n.IsDir, n.Kind, n.OldSize = old.Name, old.IsDir, Removed, old.SizeOnDisk
+	default:
+		n.Name, n.IsDir, n.OldSize, n.NewSize = new.Name, new.IsDir, old.SizeOnDisk, new.SizeOnDisk
+		switch {
+		case n.NewSize > n.OldSize:
+			n.Kind = Grown
+		case n.NewSize < n.OldSize:
+			n.Kind = Shrunk
+		}
+	}
+
+	if noEntries {
+		return n
+	}
+	hidden := make(map[*types.Node]bool)
+	oldChildren, newChildren := entries(old, hidden), entries(new, hidden)
+	byName := make(map[string]*types.Node, len(oldChildren))
+	for _, c := range oldChildren {
+		byName[c.Name] = c
+	}
+	for _, c := range newChildren {
+		oc := byName[c.Name]
+		delete(byName, c.Name)
+		n.Children = append(n.Children, compare(oc, c, hidden[oc] || hidden[c], n))
+	}
+	for _, c := range oldChildren {
+		if _, ok := byName[c.Name]; ok {
+			n.Children = append(n.Children, compare(c, nil, hidden[c], n))
+		}
+	}
+	sortByChange(n.Children)
+	return n
+}
+
+// entries returns the children of the folder n along with its hidden
+// children, which are added to hidden. It returns nil if n is nil or not a
+// folder.
+func entries(n *types.Node, hidden map[*types.Node]bool) []*types.Node {
+	if n == nil || !n.IsDir {
+		return nil
+	}
+	all := make([]*types.Node, 0, len(n.Children)+len(n.Hidden))
+	all = append(all, n.Children...)
+	for _, h := range n.Hidden {
+		hidden[h] = true
+		all = append(all, h)
+	}
+	return all
+}
+
+// Changes returns the changed entries below n, sorted by the absolute size
+// change. Added and removed folders are reported as a whole rather than
+// entry by entry, and grown or shrunk folders are left out in favour of the
+// changes of their contents.
+func (n *Node) Changes() []*Node {
+	var changes []*Node
+	var visit func(d *Node)
+	visit = func(d *Node) {
+		switch d.Kind {
+		case Added, Removed:
+			changes = append(changes, d)
+			return
+		case Unchanged:
+			return
+		}
+		before := len(changes)
+		for _, c := range d.Children {
+			visit(c)
+		}
+		if len(changes) == before {
+			// Only the folder entry itself changed.
+			changes = append(changes, d)
+		}
+	}
+	for _, c := range n.Children {
+		visit(c)
+	}
+	sortByChange(changes)
+	return changes
+}
+
+func sortByChange(nodes []*Node) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return abs(nodes[i].Delta()) > abs(nodes[j].Delta())
+	})
+}
+
+// FormatDelta formats a size change with f, with an explicit sign.
+func FormatDelta(delta int64, f types.SizeFormatter) string {
+	if delta < 0 {
+		return "-" + f(-delta)
+	}
+	return "+" + f(delta)
+}
+
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,73 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.sazak.io/gls/internal/types"
+)
+
+func dir(name string, children ...*types.Node) *types.Node {
+	n := &types.Node{Name: name, IsDir: true}
+	for _, c := range children {
+		c.Parent = n
+		n.SizeOnDisk += c.SizeOnDisk
+		n.Children = append(n.Children, c)
+	}
+	return n
+}
+
+func file(name string, size int64) *types.Node {
+	return &types.Node{Name: name, SizeOnDisk: size}
+}
+
+func TestCompare(t *testing.T) {
+	old := dir("root",
+		dir("logs", file("a.log", 100), file("b.log", 50)),
+		dir("cache", file("x", 1000)),
+		file("same", 10),
+	)
+	new := dir("root",
+		dir("logs", file("a.log", 400), file("b.log", 20), file("c.log", 5)),
+		dir("build", file("out", 250)),
+		file("same", 10),
+	)
+	d := Compare(old, new)
+	assert.Equal(t, Shrunk, d.Kind)
+	assert.Equal(t, int64(1160-685), -d.Delta())
+
+	var got []string
+	for _, c := range d.Changes() {
+		got = append(got, c.Path()+" "+c.Kind.String()+" "+FormatDelta(c.Delta(), types.NoFormat))
+	}
+	assert.Equal(t, []string{
+		"root/cache removed -1000",
+		"root/logs/a.log grown +300",
+		"root/build added +250",
+		"root/logs/b.log shrunk -30",
+		"root/logs/c.log added +5",
+	}, got)
+
+	// Children are sorted by absolute change, unchanged entries last.
+	var names []string
+	for _, c := range d.Children {
+		names = append(names, c.Name)
+	}
+	assert.Equal(t, []string{"cache", "logs", "build", "same"}, names)
+}
+
+func TestCompareHidden(t *testing.T) {
+	// small.log dropped below the size threshold and vendor became ignored,
+	// without changing on disk, while big.log grew and crossed it.
+	old := dir("root", file("small.log", 10), dir("vendor", file("lib", 300)))
+	old.Hidden = []*types.Node{file("big.log", 5)}
+	new := dir("root", file("big.log", 50))
+	new.Hidden = []*types.Node{file("small.log", 10), {Name: "vendor", IsDir: true, SizeOnDisk: 300}}
+
+	var got []string
+	for _, c := range Compare(old, new).Changes() {
+		got = append(got, c.Path()+" "+c.Kind.String()+" "+FormatDelta(c.Delta(), types.NoFormat))
+	}
+	assert.Equal(t, []string{"root/big.log grown +45"}, got)
+}
@@ -0,0 +1,76 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+
+	"go.sazak.io/gls/internal/archive"
+	"go.sazak.io/gls/internal/types"
+)
+
+// expandArchive scans the entries of the archive file n at path in the
+// OS file system into virtual children of n, with the options of the scan
+// the archive is part of. rel is the path of the archive relative to the
+// directory of the ignore rules, which apply to the entries as if the
+// archive was a directory. The sizes of n and its parents are not changed,
+// since the entries take no space on disk besides the archive file.
+func expandArchive(ctx context.Context, path, rel string, n *types.Node, opts *WalkOptions) error {
+	a, err := archive.Open(path)
+	if err != nil {
+		return err
+	}
+	aopts := *opts
+	aopts.FS = a
+	aopts.Progress = nil
+	aopts.Jobs = 1
+	aopts.ExpandArchives = false
+	aopts.RelRoot = rel
+	// The ignore files in an archive could only be read by extracting them.
+	aopts.IgnoreFiles = nil
+	root, err := Walk(ctx, ".", &aopts)
+	if err != nil {
+		return err
+	}
+	var markVirtual func(n *types.Node)
+	markVirtual = func(n *types.Node) {
+		n.Virtual = true
+		for _, c := range n.Children {
+			markVirtual(c)
+		}
+	}
+	for _, c := range root.Children {
+		markVirtual(c)
+		c.Parent = n
+	}
+	for _, c := range root.Hidden {
+		markVirtual(c)
+		c.Parent = n
+	}
+	n.Children = root.Children
+	n.Hidden = root.Hidden
+	n.Archive = a.Format()
+	n.UncompressedSize = root.Size
+	return nil
+}
+
+// ExpandArchive reads the entries of the archive file n into virtual
+// children of it, unless it is expanded already.
+func (b *FileTreeBuilder) ExpandArchive(ctx context.Context, n *types.Node) error {
+	if b.offline {
+		return errOffline
+	}
+	if n.Archive != "" {
+		return nil
+	}
+	if n.IsDir || n.Virtual || archive.Format(n.Name) == "" {
+		return fmt.Errorf("%s is not an archive", n.Name)
+	}
+	rel := b.ignorePath(n)
+	if err := expandArchive(ctx, n.RelativePath(b.path), rel, n, b.walkOptionsAt(rel)); err != nil {
+		return err
+	}
+	if b.sort {
+		n.SortChildren(b.sortKey)
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"strings"
+
+	"go.sazak.io/gls/internal/local"
+	"go.sazak.io/gls/internal/types"
+)
+
+// Change updates the built tree for a path that changed on disk. It is
+// prepared with PrepareChange, which does all the scanning, and then
+// applied with Apply by the goroutine that owns the tree.
+type Change struct {
+	b *FileTreeBuilder
+	// names lead from the root of the tree to the changed entry.
+	names []string
+	// old is the node the change was prepared against, and fresh the node
+	// replacing or added by it, or nil if the entry is gone.
+	old   *types.Node
+	fresh *types.Node
+	// links are the hard-linked inodes counted in fresh.
+	links *hardLinks
+}
+
+// PrepareChange scans the file or directory at path, which must be below
+// the root of the built tree, and returns the change that brings the tree
+// up to date with it. It returns a nil change if the path is not part of
+// the tree. It only reads the tree through Lookup, so it may run on another
+// goroutine than the one that owns the tree.
+func (b *FileTreeBuilder) PrepareChange(ctx context.Context, path string) (*Change, error) {
+	root := b.Root()
+	if root == nil {
+		return nil, fmt.Errorf("no root node built")
+	}
+	if b.offline {
+		return nil, errOffline
+	}
+	names, err := b.treeNames(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return b.prepareRescan(ctx, names, root)
+	}
+	parent := root.Lookup(names[:len(names)-1]...)
+	if parent == nil {
+		// Somewhere below an entry that is not shown.
+		return nil, nil
+	}
+	name := names[len(names)-1]
+	if b.isIgnoreFile(name) {
+		// The rules for the whole directory may have changed.
+		return b.prepareRescan(ctx, names[:len(names)-1], parent)
+	}
+	old := parent.Lookup(name)
+	opts := b.walkOptionsAt(strings.Join(names, "/"))
+	if old == nil && b.hidesEntries(opts.IgnoreChecker) {
+		// The path may be an entry that was ignored or below the size
+		// threshold, whose size is already counted in its parent, so the
+		// parent has to be scanned again to keep the sizes right.
+		return b.prepareRescan(ctx, names[:len(names)-1], parent)
+	}
+	if b.filter.Active() {
+		// Whether the entry and its parent are shown may have changed with
+		// it.
+		return b.prepareRescan(ctx, names[:len(names)-1], parent)
+	}
+	fresh, err := Walk(ctx, path, opts)
+	if errors.Is(err, iofs.ErrNotExist) {
+		if old == nil {
+			return nil, nil
+		}
+		return &Change{b: b, names: names, old: old}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fresh == nil {
+		return nil, nil
+	}
+	if b.sort {
+		fresh.SortChildren(b.sortKey)
+	}
+	return &Change{b: b, names: names, old: old, fresh: fresh, links: opts.links}, nil
+}
+
+// MoveNode updates the built tree after the file or directory n was moved on
+// disk to dst, a path below the root of the tree like those PrepareChange
+// takes, renaming n if the name differs. n is re-parented with its whole
+// subtree, and the sizes of the ancestors on both sides are updated, without
+// scanning anything. It reports false and changes nothing if the tree
+// cannot be updated like that, because the new place is not part of the
+// tree, or because ignore rules, the size threshold or filters may show or
+// hide other entries there; the old and the new path then have to be
+// scanned with PrepareChange.
+func (b *FileTreeBuilder) MoveNode(n *types.Node, dst string) (bool, error) {
+	root := b.Root()
+	if root == nil {
+		return false, fmt.Errorf("no root node built")
+	}
+	if b.offline {
+		return false, errOffline
+	}
+	if n.Parent == nil {
+		return false, fmt.Errorf("cannot move the root %s", n.Name)
+	}
+	names, err := b.treeNames(dst)
+	if err != nil {
+		return false, err
+	}
+	if len(names) == 0 {
+		return false, fmt.Errorf("cannot move %s onto the root", n.Name)
+	}
+	parent := root.Lookup(names[:len(names)-1]...)
+	if parent == nil || !parent.IsDir {
+		return false, nil
+	}
+	name := names[len(names)-1]
+	if b.isIgnoreFile(name) || b.isIgnoreFile(n.Name) || hasHidden(n) ||
+		b.hidesEntries(b.ignoreCheckerAbove(strings.Join(names, "/"))) {
+		return false, nil
+	}
+	if c := parent.Lookup(name); c != nil && c != n {
+		// The tree is behind the disk.
+		return false, nil
+	}
+	oldRel := b.ignorePath(n)
+	if parent != n.Parent {
+		if err := n.MoveTo(parent); err != nil {
+			return false, err
+		}
+	}
+	if err := n.Rename(name); err != nil {
+		return false, err
+	}
+	b.countedLinks().move(oldRel, strings.Join(names, "/"))
+	if b.sort {
+		parent.SortChildren(b.sortKey)
+	}
+	return true, nil
+}
+
+// treeNames returns the names of the entries leading from the root of the
+// tree to path, which must be below it.
+func (b *FileTreeBuilder) treeNames(path string) ([]string, error) {
+	if path != b.path && !strings.HasPrefix(path, b.path+"/") {
+		return nil, fmt.Errorf("%s is not below %s", path, b.path)
+	}
+	var names []string
+	for _, name := range strings.Split(strings.TrimPrefix(path, b.path), "/") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (b *FileTreeBuilder) isIgnoreFile(name string) bool {
+	for _, f := range b.ignoreFiles {
+		if name == f {
+			return true
+		}
+	}
+	return false
+}
+
+// hasHidden reports whether any directory in the tree under n has hidden
+// entries, which may depend on the rules of the directories above n.
+func hasHidden(n *types.Node) bool {
+	if len(n.Hidden) > 0 {
+		return true
+	}
+	for _, c := range n.Children {
+		if hasHidden(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareRescan prepares the change that replaces n, at names, with a fresh
+// scan of it.
+func (b *FileTreeBuilder) prepareRescan(ctx context.Context, names []string, n *types.Node) (*Change, error) {
+	fresh, links, err := b.rescan(ctx, strings.Join(names, "/"))
+	if err != nil {
+		return nil, err
+	}
+	return &Change{b: b, names: names, old: n, fresh: fresh, links: links}, nil
+}
+
+// hidesEntries reports whether the scan leaves entries out of the tree
+// where the ignore rules of ic are in effect.
+func (b *FileTreeBuilder) hidesEntries(ic *local.IgnoreChecker) bool {
+	return b.sizeThreshold > 0 || ic.HasRules() || b.filter.Active()
+}
+
+// Fresh returns the node the change puts into the tree, or nil if it only
+// removes a node.
+func (c *Change) Fresh() *types.Node {
+	return c.fresh
+}
+
+// Apply puts the change into the tree, and updates the sizes of all
+// ancestors of the changed node. The tree may have changed since the change
+// was prepared, so the entry is looked up again: a fresh node replaces
+// whatever is there now, and a removal is skipped if the node it was
+// prepared against is not there anymore. Nothing happens if the directory
+// of the entry left the tree.
+func (c *Change) Apply() error {
+	applied, err := c.apply()
+	if err != nil || !applied {
+		return err
+	}
+	c.b.countNextLinks(c.b.countedLinks().replace(strings.Join(c.names, "/"), c.links))
+	return nil
+}
+
+// apply is Apply without keeping track of the hard links, and reports
+// whether the tree was changed.
+func (c *Change) apply() (bool, error) {
+	root := c.b.Root()
+	if len(c.names) == 0 {
+		return true, c.b.ReplaceSubtree(root, c.fresh)
+	}
+	parent := root.Lookup(c.names[:len(c.names)-1]...)
+	if parent == nil {
+		return false, nil
+	}
+	curr := parent.Lookup(c.names[len(c.names)-1])
+	switch {
+	case c.fresh == nil && curr != nil && curr == c.old:
+		return true, parent.DetachChild(curr)
+	case c.fresh != nil && curr != nil:
+		return true, parent.ReplaceChild(curr, c.fresh)
+	case c.fresh != nil:
+		parent.AttachChild(c.fresh)
+		return true, nil
+	}
+	return false, nil
+}
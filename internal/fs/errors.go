@@ -0,0 +1,39 @@
+package fs
+
+import (
+	"go.sazak.io/gls/internal/types"
+)
+
+// ScanError is an entry of the tree that could not be scanned completely.
+type ScanError struct {
+	Node *types.Node
+	// Path is the path of the entry, and Err the reason it could not be
+	// scanned.
+	Path string
+	Err  string
+}
+
+func (e ScanError) Error() string {
+	return e.Path + ": " + e.Err
+}
+
+// ScanErrors returns the entries of the built tree that could not be scanned
+// completely, in tree order.
+func (b *FileTreeBuilder) ScanErrors() []ScanError {
+	root := b.Root()
+	if root == nil {
+		return nil
+	}
+	var errs []ScanError
+	var visit func(n *types.Node)
+	visit = func(n *types.Node) {
+		if n.ScanError != "" {
+			errs = append(errs, ScanError{Node: n, Path: n.RelativePath(b.path), Err: n.ScanError})
+		}
+		for _, c := range n.Children {
+			visit(c)
+		}
+	}
+	visit(root)
+	return errs
+}
@@ -0,0 +1,95 @@
+package fs
+
+import (
+	iofs "io/fs"
+	"os"
+
+	"go.sazak.io/gls/internal/size"
+)
+
+// Walk scans any io/fs.FS. File systems that know about symbolic links and
+// disk usage tell it through the extension interfaces below; for the others
+// links are not detected and the size on disk of a file is its size.
+
+// LstatFS is a file system that can describe a symbolic link itself rather
+// than the file it points to.
+type LstatFS interface {
+	iofs.FS
+	Lstat(name string) (iofs.FileInfo, error)
+}
+
+// ReadLinkFS is a file system with symbolic links.
+type ReadLinkFS interface {
+	iofs.FS
+	ReadLink(name string) (string, error)
+}
+
+// DiskUsageFS is a file system that knows how much space its files take on
+// disk and which of them are hard links to the same file.
+type DiskUsageFS interface {
+	iofs.FS
+	DiskUsage() size.DiskUsage
+}
+
+// OS is the file system of the operating system, and the one scanned unless
+// another is given. Unlike other file systems, it takes paths in the form
+// the os package does, including absolute and parent-relative paths.
+var OS iofs.FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) Open(name string) (iofs.File, error) {
+	return os.Open(name)
+}
+
+func (osFS) Stat(name string) (iofs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) Lstat(name string) (iofs.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (osFS) ReadLink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+func (osFS) DiskUsage() size.DiskUsage {
+	return size.FsInfo{}
+}
+
+func lstat(fsys iofs.FS, name string) (iofs.FileInfo, error) {
+	if fsys, ok := fsys.(LstatFS); ok {
+		return fsys.Lstat(name)
+	}
+	return iofs.Stat(fsys, name)
+}
+
+func readLink(fsys iofs.FS, name string) (string, error) {
+	if fsys, ok := fsys.(ReadLinkFS); ok {
+		return fsys.ReadLink(name)
+	}
+	return "", &iofs.PathError{Op: "readlink", Path: name, Err: iofs.ErrInvalid}
+}
+
+func diskUsage(fsys iofs.FS) size.DiskUsage {
+	if fsys, ok := fsys.(DiskUsageFS); ok {
+		return fsys.DiskUsage()
+	}
+	return size.ApparentInfo{}
+}
+
+// readDirEntries is like io/fs.ReadDir, but leaves the entries unsorted
+// since the children are sorted after the scan anyway.
+func readDirEntries(fsys iofs.FS, name string) ([]iofs.DirEntry, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dir, ok := f.(iofs.ReadDirFile)
+	if !ok {
+		return nil, &iofs.PathError{Op: "readdir", Path: name, Err: iofs.ErrInvalid}
+	}
+	return dir.ReadDir(-1)
+}
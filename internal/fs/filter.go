@@ -0,0 +1,140 @@
+package fs
+
+import (
+	"fmt"
+	iofs "io/fs"
+	"strings"
+	"time"
+
+	"go.sazak.io/gls/internal/local"
+	"go.sazak.io/gls/internal/types"
+)
+
+// Filter selects the files a scan shows. Files it does not select are
+// hidden like ignored ones, so their sizes still count in their folders.
+// Folders are not filtered themselves, but a folder left without any shown
+// entries is hidden too. The zero value selects every file.
+type Filter struct {
+	// Include, if set, selects only the files matched by one of its rules,
+	// or below a folder matched by one.
+	Include *local.IgnoreChecker
+	// ModifiedBefore and ModifiedAfter, if not zero, select only the files
+	// last modified before or after them.
+	ModifiedBefore time.Time
+	ModifiedAfter  time.Time
+	// Types, if not empty, selects only the files of the given types, as
+	// returned by FileMode.Type. Regular files have type 0.
+	Types []iofs.FileMode
+	// Owners, if not empty, selects only the files owned by one of the
+	// given user ids.
+	Owners []uint32
+	// MinFiles, if positive, hides the directories with fewer files below
+	// them, hidden files included.
+	MinFiles int64
+}
+
+// fileTypes are the file types by the letters find(1) uses for them.
+var fileTypes = map[byte]iofs.FileMode{
+	'f': 0,
+	'l': iofs.ModeSymlink,
+	'p': iofs.ModeNamedPipe,
+	's': iofs.ModeSocket,
+	'c': iofs.ModeDevice | iofs.ModeCharDevice,
+	'b': iofs.ModeDevice,
+}
+
+// ParseFileTypes parses a comma-separated list of file types, by the letters
+// of find(1): f for regular files, l for symbolic links, p for named pipes,
+// s for sockets, c for character devices and b for block devices.
+func ParseFileTypes(s string) ([]iofs.FileMode, error) {
+	var types []iofs.FileMode
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		mode, ok := fileTypes[t[0]]
+		if len(t) != 1 || !ok {
+			return nil, fmt.Errorf("unknown file type %q, wanted one of f, l, p, s, c or b", t)
+		}
+		types = append(types, mode)
+	}
+	return types, nil
+}
+
+// Active reports whether the filter can leave any file out.
+func (f *Filter) Active() bool {
+	return f != nil && (f.Include.HasRules() || !f.ModifiedBefore.IsZero() || !f.ModifiedAfter.IsZero() ||
+		len(f.Types) > 0 || len(f.Owners) > 0 || f.MinFiles > 0)
+}
+
+// selects reports whether the filter selects the file n, whose path
+// relative to the root of the ignore rules is rel. owner returns the user
+// id of the owner of the file.
+func (f *Filter) selects(rel string, n *types.Node, owner func() (uint32, error)) bool {
+	if !f.Active() {
+		return true
+	}
+	if !f.ModifiedBefore.IsZero() && !n.LastModification.Before(f.ModifiedBefore) {
+		return false
+	}
+	if !f.ModifiedAfter.IsZero() && !n.LastModification.After(f.ModifiedAfter) {
+		return false
+	}
+	if len(f.Types) > 0 && !containsMode(f.Types, n.Mode.Type()) {
+		return false
+	}
+	if len(f.Owners) > 0 {
+		uid, err := owner()
+		if err != nil || !containsUID(f.Owners, uid) {
+			return false
+		}
+	}
+	return !f.Include.HasRules() || f.included(rel)
+}
+
+// selectsDir reports whether the filter selects the directory n, once it
+// is scanned.
+func (f *Filter) selectsDir(n *types.Node) bool {
+	if !f.Active() {
+		return true
+	}
+	// A directory with nothing selected by the filter is left out too.
+	return len(n.Children) > 0 && n.AggregateStats().Files >= f.MinFiles
+}
+
+// included reports whether the file at rel is matched by an include rule,
+// or else whether the closest of its folders matched by any rule is.
+func (f *Filter) included(rel string) bool {
+	if rule, ok := f.Include.Match(rel, false); rule != nil {
+		return ok
+	}
+	for dir := rel; ; {
+		i := strings.LastIndexByte(dir, '/')
+		if i < 0 {
+			return false
+		}
+		dir = dir[:i]
+		if rule, ok := f.Include.Match(dir, true); rule != nil {
+			return ok
+		}
+	}
+}
+
+func containsMode(modes []iofs.FileMode, m iofs.FileMode) bool {
+	for _, mode := range modes {
+		if mode == m {
+			return true
+		}
+	}
+	return false
+}
+
+func containsUID(uids []uint32, uid uint32) bool {
+	for _, u := range uids {
+		if u == uid {
+			return true
+		}
+	}
+	return false
+}
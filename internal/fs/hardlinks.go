@@ -0,0 +1,150 @@
+package fs
+
+import (
+	iofs "io/fs"
+	"strings"
+	"sync"
+
+	"go.sazak.io/gls/internal/size"
+	"go.sazak.io/gls/internal/types"
+)
+
+// hardLinks records the inodes with more than one link that a scan counted,
+// along with the path of the entry they were counted for, relative to the
+// root of the tree. The methods are safe for concurrent use.
+type hardLinks struct {
+	mu    sync.Mutex
+	paths map[size.FileID]string
+}
+
+// add records the inode id as counted for the entry at rel, unless it is
+// counted already, and reports whether it was not.
+func (l *hardLinks) add(id size.FileID, rel string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.paths[id]; ok {
+		return false
+	}
+	if l.paths == nil {
+		l.paths = make(map[size.FileID]string)
+	}
+	l.paths[id] = rel
+	return true
+}
+
+// outside returns a copy of l without the inodes counted for the entry at
+// rel or below it, which a scan of rel counts again. l may be nil.
+func (l *hardLinks) outside(rel string) *hardLinks {
+	c := &hardLinks{paths: make(map[size.FileID]string)}
+	if l == nil {
+		return c
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for id, p := range l.paths {
+		if !isBelow(p, rel) {
+			c.paths[id] = p
+		}
+	}
+	return c
+}
+
+// replace drops the inodes counted for the entry at rel or below it, and
+// takes over those that fresh, a scan of rel, counted there. It returns the
+// dropped inodes that fresh did not count again, whose other links are now
+// left uncounted. It does nothing on a nil l, and fresh is nil if rel is
+// gone.
+func (l *hardLinks) replace(rel string, fresh *hardLinks) []size.FileID {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	dropped := make(map[size.FileID]bool)
+	for id, p := range l.paths {
+		if isBelow(p, rel) {
+			delete(l.paths, id)
+			dropped[id] = true
+		}
+	}
+	if fresh != nil {
+		fresh.mu.Lock()
+		defer fresh.mu.Unlock()
+		for id, p := range fresh.paths {
+			if isBelow(p, rel) {
+				if l.paths == nil {
+					l.paths = make(map[size.FileID]string)
+				}
+				l.paths[id] = p
+				delete(dropped, id)
+			}
+		}
+	}
+	var gone []size.FileID
+	for id := range dropped {
+		gone = append(gone, id)
+	}
+	return gone
+}
+
+// move updates the paths of the inodes counted for the entry at from or
+// below it after the entry was moved to to. It does nothing on a nil l.
+func (l *hardLinks) move(from, to string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for id, p := range l.paths {
+		if isBelow(p, from) {
+			l.paths[id] = to + strings.TrimPrefix(p, from)
+		}
+	}
+}
+
+// countNextLinks counts the first link the tree has left to each of the
+// inodes in gone, after the entries they were counted for left the tree or
+// were scanned again without them. Directories reached through followed
+// links are left as they are.
+func (b *FileTreeBuilder) countNextLinks(gone []size.FileID) {
+	links, root := b.countedLinks(), b.Root()
+	if len(gone) == 0 || links == nil || root == nil {
+		return
+	}
+	wanted := make(map[size.FileID]bool, len(gone))
+	for _, id := range gone {
+		wanted[id] = true
+	}
+	fsys := b.fsys
+	if fsys == nil {
+		fsys = OS
+	}
+	usage := diskUsage(fsys)
+	var visit func(n *types.Node, rel string)
+	visit = func(n *types.Node, rel string) {
+		if n.DuplicateLink && !n.IsDir && !n.Virtual {
+			// The node does not keep its inode, so it is looked up again.
+			if fi, err := iofs.Stat(fsys, joinPath(b.path, rel)); err == nil {
+				if id, err := usage.GetFileID(fi); err == nil && wanted[id] && links.add(id, rel) {
+					delete(wanted, id)
+					n.SetDuplicateLink(false)
+				}
+			}
+		}
+		for _, entries := range [][]*types.Node{n.Children, n.Hidden} {
+			for _, c := range entries {
+				if len(wanted) == 0 {
+					return
+				}
+				visit(c, joinRel(rel, c.Name))
+			}
+		}
+	}
+	visit(root, "")
+}
+
+// isBelow reports whether the relative path p is dir or below it. Every
+// path is below the root, whose relative path is empty.
+func isBelow(p, dir string) bool {
+	return dir == "" || p == dir || strings.HasPrefix(p, dir+"/")
+}
@@ -0,0 +1,84 @@
+package fs
+
+import (
+	"sync"
+
+	"go.sazak.io/gls/internal/local"
+	"go.sazak.io/gls/internal/types"
+)
+
+// IgnoreStats counts what every ignore rule excluded during a scan. The
+// methods are safe for concurrent use, and do nothing on a nil IgnoreStats.
+type IgnoreStats struct {
+	mu    sync.Mutex
+	rules []*local.Rule
+	stats map[*local.Rule]*RuleStats
+}
+
+// RuleStats counts the entries an ignore rule matched and excluded. An
+// excluded directory counts as one directory, with the size of its whole
+// subtree.
+type RuleStats struct {
+	Rule       *local.Rule
+	Files      int
+	Dirs       int
+	SizeOnDisk int64
+}
+
+// addRules registers the rules of ic, so that rules that never match are
+// listed too.
+func (s *IgnoreStats) addRules(ic *local.IgnoreChecker) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stats == nil {
+		s.stats = make(map[*local.Rule]*RuleStats)
+	}
+	for _, r := range ic.Rules() {
+		if _, ok := s.stats[r]; !ok {
+			s.rules = append(s.rules, r)
+			s.stats[r] = &RuleStats{Rule: r}
+		}
+	}
+}
+
+// add counts n as excluded by r.
+func (s *IgnoreStats) add(r *local.Rule, n *types.Node) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stats == nil {
+		s.stats = make(map[*local.Rule]*RuleStats)
+	}
+	rs, ok := s.stats[r]
+	if !ok {
+		rs = &RuleStats{Rule: r}
+		s.rules = append(s.rules, r)
+		s.stats[r] = rs
+	}
+	if n.IsDir {
+		rs.Dirs++
+	} else {
+		rs.Files++
+	}
+	rs.SizeOnDisk += n.UniqueSizeOnDisk()
+}
+
+// Rules returns the counts of every rule seen during the scan, in the order
+// the rules were first seen.
+func (s *IgnoreStats) Rules() []RuleStats {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make([]RuleStats, 0, len(s.rules))
+	for _, r := range s.rules {
+		stats = append(stats, *s.stats[r])
+	}
+	return stats
+}
@@ -0,0 +1,105 @@
+package fs
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultProgressInterval = 100 * time.Millisecond
+)
+
+// ProgressEvent is a point-in-time snapshot of a running scan.
+type ProgressEvent struct {
+	Entries    int64
+	Bytes      int64
+	Errors     int64
+	CurrentDir string
+	Done       bool
+}
+
+type ProgressFunc func(ProgressEvent)
+
+// Progress collects the counters of a running scan. It is safe for concurrent
+// use, and a nil *Progress silently discards all updates.
+type Progress struct {
+	entries int64
+	bytes   int64
+	errors  int64
+
+	mu         sync.Mutex
+	currentDir string
+}
+
+func (p *Progress) addEntry(sizeOnDisk int64) {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.entries, 1)
+	atomic.AddInt64(&p.bytes, sizeOnDisk)
+}
+
+func (p *Progress) addError() {
+	if p == nil {
+		return
+	}
+	atomic.AddInt64(&p.errors, 1)
+}
+
+func (p *Progress) enterDir(path string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.currentDir = path
+}
+
+func (p *Progress) Snapshot() ProgressEvent {
+	if p == nil {
+		return ProgressEvent{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return ProgressEvent{
+		Entries:    atomic.LoadInt64(&p.entries),
+		Bytes:      atomic.LoadInt64(&p.bytes),
+		Errors:     atomic.LoadInt64(&p.errors),
+		CurrentDir: p.currentDir,
+	}
+}
+
+// report calls f with a fresh snapshot of p on every tick until stop is
+// closed, then sends a final snapshot marked as done.
+func (p *Progress) report(interval time.Duration, f ProgressFunc, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f(p.Snapshot())
+		case <-stop:
+			ev := p.Snapshot()
+			ev.Done = true
+			f(ev)
+			return
+		}
+	}
+}
+
+// startProgress returns a new Progress whose snapshots are reported to f
+// every interval, until the returned stop function is called.
+func startProgress(interval time.Duration, f ProgressFunc) (*Progress, func()) {
+	p := &Progress{}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.report(interval, f, stop)
+	}()
+	return p, func() {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,55 @@
+package fs
+
+import (
+	iofs "io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// failingFS is a file system whose directory fail cannot be listed.
+type failingFS struct {
+	fstest.MapFS
+	fail string
+}
+
+func (f failingFS) Open(name string) (iofs.File, error) {
+	if name == f.fail {
+		return nil, &iofs.PathError{Op: "open", Path: name, Err: iofs.ErrPermission}
+	}
+	return f.MapFS.Open(name)
+}
+
+func TestFileTreeBuilderProgress(t *testing.T) {
+	var events []ProgressEvent
+	bl := NewFileTreeBuilder(".",
+		WithFS(failingFS{MapFS: testMapFS(), fail: "c/skip"}),
+		WithProgress(time.Millisecond, func(ev ProgressEvent) {
+			events = append(events, ev)
+		}))
+	if err := bl.Build(); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if len(events) == 0 {
+		t.Fatalf("no progress reported")
+	}
+	for _, ev := range events[:len(events)-1] {
+		if ev.Done {
+			t.Errorf("progress event %+v before the last one is done", ev)
+		}
+	}
+	last := events[len(events)-1]
+	if !last.Done {
+		t.Errorf("last progress event %+v is not done", last)
+	}
+	// The root, a, a/b, c, c/skip and empty, and the 5 files outside c/skip.
+	if last.Entries != 11 {
+		t.Errorf("got %d entries, want 11", last.Entries)
+	}
+	if want := int64(10 + 5000 + 300 + 1000 + 1); last.Bytes < want {
+		t.Errorf("got %d bytes, want at least %d", last.Bytes, want)
+	}
+	if last.Errors != 1 {
+		t.Errorf("got %d errors, want 1", last.Errors)
+	}
+}
@@ -0,0 +1,114 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.sazak.io/gls/internal/size"
+	"go.sazak.io/gls/internal/types"
+)
+
+// remover deletes file trees on the file system of the OS, going on past
+// the entries it cannot delete.
+type remover struct {
+	progress *Progress
+	usage    size.DiskUsage
+	// device is the device of the removed tree, which removal does not
+	// leave.
+	device uint64
+	errs   []error
+}
+
+// RemoveTree deletes the file or directory n of the built tree from the file
+// system, with everything below it, reporting the progress to f like Build
+// does if f is not nil. Entries that cannot be deleted are skipped, along
+// with the directories above them, and returned as errors; so are mount
+// points of other file systems, which are never descended into. The
+// returned change brings the tree up to date with what is left, and is
+// applied with Apply by the goroutine that owns the tree.
+func (b *FileTreeBuilder) RemoveTree(ctx context.Context, n *types.Node, f ProgressFunc) (*Change, []error, error) {
+	root := b.Root()
+	if root == nil {
+		return nil, nil, fmt.Errorf("no root node built")
+	}
+	if b.offline {
+		return nil, nil, errOffline
+	}
+	if n == root || n.Parent == nil {
+		return nil, nil, fmt.Errorf("cannot remove the root %s", n.Name)
+	}
+	if n.Virtual {
+		return nil, nil, fmt.Errorf("cannot remove %s inside an archive", n.Name)
+	}
+	path := n.RelativePath(b.path)
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := &remover{usage: size.FsInfo{}}
+	if id, err := r.usage.GetFileID(fi); err == nil {
+		r.device = id.Device
+	}
+	if f != nil {
+		var stop func()
+		r.progress, stop = startProgress(b.progressInterval, f)
+		defer stop()
+	}
+	r.remove(ctx, path, fi)
+	// Whatever happened, the tree has to show what is left.
+	c, err := b.PrepareChange(context.Background(), path)
+	if err != nil {
+		return nil, r.errs, err
+	}
+	return c, r.errs, ctx.Err()
+}
+
+// remove deletes the entry at path, described by fi, and reports whether it
+// is gone.
+func (r *remover) remove(ctx context.Context, path string, fi os.FileInfo) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	sizeOnDisk, _ := r.usage.GetSizeOnDisk(fi)
+	if fi.IsDir() {
+		if id, err := r.usage.GetFileID(fi); err == nil && id.Device != r.device {
+			r.fail(fmt.Errorf("%s: mount point of another file system, not removed", path))
+			return false
+		}
+		r.progress.enterDir(path)
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			r.fail(err)
+			return false
+		}
+		gone := true
+		for _, e := range entries {
+			childPath := filepath.Join(path, e.Name())
+			info, err := e.Info()
+			if err != nil {
+				if !os.IsNotExist(err) {
+					r.fail(err)
+					gone = false
+				}
+				continue
+			}
+			gone = r.remove(ctx, childPath, info) && gone
+		}
+		if !gone {
+			return false
+		}
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		r.fail(err)
+		return false
+	}
+	r.progress.addEntry(sizeOnDisk)
+	return true
+}
+
+func (r *remover) fail(err error) {
+	r.errs = append(r.errs, err)
+	r.progress.addError()
+}
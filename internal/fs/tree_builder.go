@@ -1,21 +1,57 @@
 package fs
 
 import (
+	"context"
 	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	pathpkg "path"
+	"strings"
+	"sync"
+	"time"
 
 	"go.sazak.io/gls/internal/local"
+	"go.sazak.io/gls/internal/snapshot"
 	"go.sazak.io/gls/internal/types"
 )
 
+var errOffline = fmt.Errorf("the tree is loaded from a snapshot and cannot be rescanned")
+
 type FileTreeBuilderOption func(*FileTreeBuilder)
 
 type FileTreeBuilder struct {
-	root          *types.Node
+	// mu guards root and links, which changes are prepared against off the
+	// goroutine that owns the tree.
+	mu   sync.Mutex
+	root *types.Node
+	// links are the hard-linked inodes counted in the tree, so that rescans
+	// of subtrees do not count those linked from outside them again.
+	links         *hardLinks
+	fsys          iofs.FS
 	path          string
 	sort          bool
+	sortKey       types.SortKey
 	sizeFormatter types.SizeFormatter
 	sizeThreshold int64
 	ignoreChecker *local.IgnoreChecker
+	ignoreFiles   []string
+	filter        *Filter
+	jobs          int
+	followLinks   bool
+	oneFs         bool
+	archives      bool
+
+	progressFunc     ProgressFunc
+	progressInterval time.Duration
+
+	// scannedAt is when the tree was built, and offline is set if it was
+	// loaded from a snapshot instead of scanned.
+	scannedAt time.Time
+	offline   bool
+	// ignoreStats counts what the ignore rules excluded during the last
+	// full scan.
+	ignoreStats *IgnoreStats
 }
 
 func NewFileTreeBuilder(path string, opts ...FileTreeBuilderOption) *FileTreeBuilder {
@@ -23,9 +59,17 @@ func NewFileTreeBuilder(path string, opts ...FileTreeBuilderOption) *FileTreeBui
 		root:          nil,
 		path:          path,
 		sort:          false,
+		sortKey:       types.SortBySizeOnDisk,
 		sizeFormatter: types.NoFormat,
 		sizeThreshold: 0,
 		ignoreChecker: nil,
+		ignoreFiles:   []string{local.DefaultIgnoreFile},
+		jobs:          DefaultJobs,
+		followLinks:   false,
+		oneFs:         false,
+
+		progressFunc:     nil,
+		progressInterval: defaultProgressInterval,
 	}
 	for _, opt := range opts {
 		opt(b)
@@ -45,6 +89,14 @@ func WithSortingBySize() FileTreeBuilderOption {
 	}
 }
 
+// WithSortKey sorts the tree by k instead of by size.
+func WithSortKey(k types.SortKey) FileTreeBuilderOption {
+	return func(b *FileTreeBuilder) {
+		b.sort = true
+		b.sortKey = k
+	}
+}
+
 func WithSizeThreshold(thresh int64) FileTreeBuilderOption {
 	return func(b *FileTreeBuilder) {
 		b.sizeThreshold = thresh
@@ -57,40 +109,337 @@ func WithIgnoreChecker(ic *local.IgnoreChecker) FileTreeBuilderOption {
 	}
 }
 
+// WithIgnoreFiles sets the names of the ignore files looked for in every
+// scanned directory, .glsignore by default. The rules of an ignore file
+// apply to the subtree of its directory. No names turn the lookup off.
+func WithIgnoreFiles(names ...string) FileTreeBuilderOption {
+	return func(b *FileTreeBuilder) {
+		b.ignoreFiles = names
+	}
+}
+
+// WithFilter makes the scan show only the files selected by f.
+func WithFilter(f *Filter) FileTreeBuilderOption {
+	return func(b *FileTreeBuilder) {
+		b.filter = f
+	}
+}
+
+// WithJobs limits the number of directories that are scanned concurrently.
+func WithJobs(n int) FileTreeBuilderOption {
+	return func(b *FileTreeBuilder) {
+		b.jobs = n
+	}
+}
+
+// WithFollowSymlinks makes the scan descend into symbolically linked
+// directories and count the sizes of link targets.
+func WithFollowSymlinks() FileTreeBuilderOption {
+	return func(b *FileTreeBuilder) {
+		b.followLinks = true
+	}
+}
+
+// WithFS makes the builder scan the path in fsys instead of the file system
+// of the OS.
+func WithFS(fsys iofs.FS) FileTreeBuilderOption {
+	return func(b *FileTreeBuilder) {
+		b.fsys = fsys
+	}
+}
+
+// WithOneFilesystem keeps the scan from descending into mount points of
+// other file systems.
+func WithOneFilesystem() FileTreeBuilderOption {
+	return func(b *FileTreeBuilder) {
+		b.oneFs = true
+	}
+}
+
+// WithArchives makes the scan list the entries of archive files as their
+// children.
+func WithArchives() FileTreeBuilderOption {
+	return func(b *FileTreeBuilder) {
+		b.archives = true
+	}
+}
+
+// WithProgress makes Build report the scan progress to f every interval. f is
+// called from a separate goroutine, and once more with Done set when the scan
+// finishes.
+func WithProgress(interval time.Duration, f ProgressFunc) FileTreeBuilderOption {
+	return func(b *FileTreeBuilder) {
+		if interval > 0 {
+			b.progressInterval = interval
+		}
+		b.progressFunc = f
+	}
+}
+
 func (b *FileTreeBuilder) Root() *types.Node {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	return b.root
 }
 
+func (b *FileTreeBuilder) setRoot(n *types.Node) {
+	b.mu.Lock()
+	b.root = n
+	b.mu.Unlock()
+}
+
+func (b *FileTreeBuilder) countedLinks() *hardLinks {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.links
+}
+
+// Path returns the path of the root of the tree.
+func (b *FileTreeBuilder) Path() string {
+	return b.path
+}
+
+// ScannedAt returns when the tree was scanned.
+func (b *FileTreeBuilder) ScannedAt() time.Time {
+	return b.scannedAt
+}
+
+// IgnoreStats returns what every ignore rule excluded during the last full
+// scan, or nil if the tree was not scanned. Rescans of subtrees are not
+// counted.
+func (b *FileTreeBuilder) IgnoreStats() []RuleStats {
+	return b.ignoreStats.Rules()
+}
+
+// SortKey returns the order the tree is sorted in, if it is sorted.
+func (b *FileTreeBuilder) SortKey() types.SortKey {
+	return b.sortKey
+}
+
+// SortBy sorts the built tree by k, and keeps it sorted by k when parts of
+// it are scanned again.
+func (b *FileTreeBuilder) SortBy(k types.SortKey) {
+	b.sort = true
+	b.sortKey = k
+	if root := b.Root(); root != nil {
+		root.SortChildren(k)
+	}
+}
+
+// Offline reports whether the tree was loaded from a snapshot, in which case
+// it must not be compared with or changed on the file system.
+func (b *FileTreeBuilder) Offline() bool {
+	return b.offline
+}
+
 func (b *FileTreeBuilder) Build() error {
-	var err error
-	b.root, err = Walk(b.path, &WalkOptions{
-		SizeThreshold: b.sizeThreshold,
-		IgnoreChecker: b.ignoreChecker,
-	})
+	return b.BuildContext(context.Background())
+}
+
+func (b *FileTreeBuilder) walkOptions() *WalkOptions {
+	return &WalkOptions{
+		FS:             b.fsys,
+		SizeThreshold:  b.sizeThreshold,
+		IgnoreChecker:  b.ignoreChecker,
+		IgnoreFiles:    b.ignoreFiles,
+		Filter:         b.filter,
+		Jobs:           b.jobs,
+		FollowSymlinks: b.followLinks,
+		OneFilesystem:  b.oneFs,
+		ExpandArchives: b.archives,
+	}
+}
+
+// walkOptionsAt returns the options to scan the entry at rel, relative to
+// the root of the tree, with the ignore rules in effect in its directory.
+func (b *FileTreeBuilder) walkOptionsAt(rel string) *WalkOptions {
+	opts := b.walkOptions()
+	opts.RelRoot = rel
+	opts.IgnoreChecker = b.ignoreCheckerAbove(rel)
+	opts.links = b.countedLinks().outside(rel)
+	return opts
+}
+
+// ignoreCheckerAbove returns the ignore checker of the builder with the
+// rules of the ignore files in the directories above the entry at rel,
+// relative to the root of the tree, on top.
+func (b *FileTreeBuilder) ignoreCheckerAbove(rel string) *local.IgnoreChecker {
+	ic := b.ignoreChecker
+	if rel == "" || len(b.ignoreFiles) == 0 {
+		return ic
+	}
+	fsys := b.fsys
+	if fsys == nil {
+		fsys = OS
+	}
+	dir, dirRel := pathpkg.Clean(b.path), ""
+	names := strings.Split(rel, "/")
+	for _, name := range names[:len(names)-1] {
+		ic = scopeIgnoreRules(fsys, dir, dirRel, b.ignoreFiles, ic, nil)
+		dir, dirRel = joinPath(dir, name), joinRel(dirRel, name)
+	}
+	return scopeIgnoreRules(fsys, dir, dirRel, b.ignoreFiles, ic, nil)
+}
+
+// ignorePath returns the path of n relative to the root of the tree, which
+// is the one its ignore rules are matched against.
+func (b *FileTreeBuilder) ignorePath(n *types.Node) string {
+	return strings.TrimPrefix(strings.TrimPrefix(n.RelativePath(b.path), b.path), "/")
+}
+
+// BuildContext is like Build, but aborts the scan and returns the context's
+// error as soon as ctx is cancelled.
+func (b *FileTreeBuilder) BuildContext(ctx context.Context) error {
+	opts := b.walkOptions()
+	if b.progressFunc != nil {
+		var stop func()
+		opts.Progress, stop = startProgress(b.progressInterval, b.progressFunc)
+		defer stop()
+	}
+	b.scannedAt = time.Now()
+	b.offline = false
+	b.ignoreStats = &IgnoreStats{}
+	opts.IgnoreStats = b.ignoreStats
+	opts.links = &hardLinks{}
+	root, err := Walk(ctx, b.path, opts)
 	if err != nil {
 		return err
 	}
-	if b.root == nil {
+	if root == nil {
 		return fmt.Errorf("could not build, root is nil")
 	}
 	if b.sort {
-		b.root.SortChildrenBySizeOnDisk()
+		root.SortChildren(b.sortKey)
+	}
+	b.mu.Lock()
+	b.root, b.links = root, opts.links
+	b.mu.Unlock()
+	return nil
+}
+
+// Load reads the tree from the named snapshot file instead of scanning it.
+// The path of the builder is replaced by the path the snapshot was taken at.
+func (b *FileTreeBuilder) Load(name string) error {
+	s, err := snapshot.Load(name)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.root, b.links = s.Root, nil
+	b.mu.Unlock()
+	b.path = s.Path
+	b.scannedAt = s.ScannedAt
+	b.offline = true
+	b.ignoreStats = nil
+	// Snapshots of older versions have no stats.
+	s.Root.ComputeStats()
+	if b.sort {
+		s.Root.SortChildren(b.sortKey)
 	}
 	return nil
 }
 
+// Save writes the built tree to the named snapshot file, as JSON if the name
+// ends in .json and in the compact binary format otherwise.
+func (b *FileTreeBuilder) Save(name string) error {
+	root := b.Root()
+	if root == nil {
+		return fmt.Errorf("no root node built")
+	}
+	return snapshot.Save(name, &snapshot.Snapshot{
+		Path:      b.path,
+		ScannedAt: b.scannedAt,
+		Root:      root,
+	})
+}
+
+// Rescan scans the subtree at n again with the options of the builder, and
+// returns the fresh subtree without changing the built tree.
+func (b *FileTreeBuilder) Rescan(ctx context.Context, n *types.Node) (*types.Node, error) {
+	fresh, _, err := b.rescan(ctx, b.ignorePath(n))
+	return fresh, err
+}
+
+// rescan is Rescan for the entry at rel, relative to the root of the tree,
+// which does not read the tree, so that it can run on any goroutine. It also
+// returns the hard-linked inodes the scan counted.
+func (b *FileTreeBuilder) rescan(ctx context.Context, rel string) (*types.Node, *hardLinks, error) {
+	if b.Root() == nil {
+		return nil, nil, fmt.Errorf("no root node built")
+	}
+	if b.offline {
+		return nil, nil, errOffline
+	}
+	path := b.path
+	if rel != "" {
+		path += "/" + rel
+	}
+	opts := b.walkOptionsAt(rel)
+	fresh, err := Walk(ctx, path, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fresh == nil {
+		return nil, nil, fmt.Errorf("could not rescan %s", path)
+	}
+	if b.sort {
+		fresh.SortChildren(b.sortKey)
+	}
+	return fresh, opts.links, nil
+}
+
+// ReplaceSubtree puts fresh in the place of old in the built tree, and
+// updates the sizes of all ancestors of old.
+func (b *FileTreeBuilder) ReplaceSubtree(old, fresh *types.Node) error {
+	if old == b.Root() {
+		b.setRoot(fresh)
+		return nil
+	}
+	if old.Parent == nil {
+		return fmt.Errorf("%s is not part of the tree", old.Name)
+	}
+	return old.Parent.ReplaceChild(old, fresh)
+}
+
+// Refresh rescans the subtree at n and swaps it into the built tree in
+// place, so the sizes of all ancestors stay correct without a full rescan.
+// It returns the node that replaced n.
+func (b *FileTreeBuilder) Refresh(ctx context.Context, n *types.Node) (*types.Node, error) {
+	rel := b.ignorePath(n)
+	fresh, links, err := b.rescan(ctx, rel)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.ReplaceSubtree(n, fresh); err != nil {
+		return nil, err
+	}
+	b.countNextLinks(b.countedLinks().replace(rel, links))
+	return fresh, nil
+}
+
 func (b *FileTreeBuilder) Print() error {
-	if b.root == nil {
+	return b.Fprint(os.Stdout, types.PrintOptions{})
+}
+
+// Fprint writes the built tree to w, with the size formatter of the builder
+// unless opts has one.
+func (b *FileTreeBuilder) Fprint(w io.Writer, opts types.PrintOptions) error {
+	root := b.Root()
+	if root == nil {
 		return fmt.Errorf("no root node built")
 	}
-	b.root.PrintWithSizeFormatter(b.sizeFormatter)
+	if opts.Formatter == nil {
+		opts.Formatter = b.sizeFormatter
+	}
+	root.Fprint(w, opts)
 	return nil
 }
 
 func (b *FileTreeBuilder) RootInfo() error {
-	if b.root == nil {
+	root := b.Root()
+	if root == nil {
 		return fmt.Errorf("no root node built")
 	}
-	b.root.InfoWithSizeFormatter(b.sizeFormatter)
+	root.InfoWithSizeFormatter(b.sizeFormatter)
 	return nil
 }
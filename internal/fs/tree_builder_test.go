@@ -1,8 +1,14 @@
 package fs
 
 import (
+	"context"
 	"flag"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"go.sazak.io/gls/internal/local"
+	"go.sazak.io/gls/internal/types"
 )
 
 var (
@@ -17,3 +23,495 @@ func BenchmarkFileTreeBuilder(b *testing.B) {
 		}
 	}
 }
+
+func TestFileTreeBuilderRefresh(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "a", "b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	bl := NewFileTreeBuilder(dir)
+	if err := bl.Build(); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	root, a := bl.Root(), bl.Root().Lookup("a")
+	rootBefore, aBefore := root.SizeOnDisk, a.SizeOnDisk
+	if err := os.WriteFile(filepath.Join(dir, "a", "b", "new"), make([]byte, 64*1024), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := root.Lookup("a", "b")
+	fresh, err := bl.Refresh(context.Background(), old)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if got := root.Lookup("a", "b"); got != fresh || len(got.Children) != 1 {
+		t.Fatalf("refreshed node is not in the tree")
+	}
+	delta := fresh.SizeOnDisk - old.SizeOnDisk
+	if delta <= 0 {
+		t.Fatalf("size on disk did not grow: %d", delta)
+	}
+	if root.SizeOnDisk != rootBefore+delta {
+		t.Errorf("root size on disk = %d, want %d", root.SizeOnDisk, rootBefore+delta)
+	}
+	if a.SizeOnDisk != aBefore+delta {
+		t.Errorf("size on disk of a = %d, want %d", a.SizeOnDisk, aBefore+delta)
+	}
+}
+
+func TestFileTreeBuilderRefreshHardLinks(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a", "file"), make([]byte, 64*1024), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(dir, "a", "file"), filepath.Join(dir, "b", "link")); err != nil {
+		t.Skipf("hard links are not supported: %v", err)
+	}
+	bl := NewFileTreeBuilder(dir)
+	if err := bl.Build(); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	before := bl.Root().SizeOnDisk
+	// The file is counted once, on either side of the subtree boundary,
+	// however often each side is refreshed.
+	check := func(when, linkDir string) {
+		t.Helper()
+		if got := bl.Root().SizeOnDisk; got != before {
+			t.Errorf("root size on disk %s = %d, want %d", when, got, before)
+		}
+		file, link := bl.Root().Lookup("a", "file"), bl.Root().Lookup(linkDir, "link")
+		if file.DuplicateLink == link.DuplicateLink {
+			t.Errorf("%s: file and link are duplicates: %t and %t, want exactly one", when, file.DuplicateLink, link.DuplicateLink)
+		}
+	}
+	for _, name := range []string{"a", "b", "a", "b"} {
+		if _, err := bl.Refresh(context.Background(), bl.Root().Lookup(name)); err != nil {
+			t.Fatalf("Refresh(%s): %v", name, err)
+		}
+		check("after refreshing "+name, "b")
+	}
+
+	// Moving the link along with its folder keeps track of it.
+	if err := os.Rename(filepath.Join(dir, "b"), filepath.Join(dir, "c")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := bl.MoveNode(bl.Root().Lookup("b"), filepath.Join(dir, "c")); !ok || err != nil {
+		t.Fatalf("MoveNode = %t, %v", ok, err)
+	}
+	for _, name := range []string{"c", "a"} {
+		if _, err := bl.Refresh(context.Background(), bl.Root().Lookup(name)); err != nil {
+			t.Fatalf("Refresh(%s): %v", name, err)
+		}
+		check("after moving and refreshing "+name, "c")
+	}
+
+	// Removing the counted link counts the one that is left.
+	counted, left := bl.Root().Lookup("a", "file"), bl.Root().Lookup("c", "link")
+	if counted.DuplicateLink {
+		counted, left = left, counted
+	}
+	c, errs, err := bl.RemoveTree(context.Background(), counted, nil)
+	if err != nil || len(errs) > 0 {
+		t.Fatalf("RemoveTree = %v, %v", errs, err)
+	}
+	if err := c.Apply(); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if counted.Parent != nil {
+		t.Errorf("removed node still has the parent %s", counted.Parent.Name)
+	}
+	if left.DuplicateLink {
+		t.Errorf("the link that is left is still a duplicate")
+	}
+	if got := bl.Root().SizeOnDisk; got != before {
+		t.Errorf("root size on disk after removing a link = %d, want %d", got, before)
+	}
+	if _, err := bl.Refresh(context.Background(), left.Parent); err != nil {
+		t.Fatalf("Refresh(%s): %v", left.Parent.Name, err)
+	}
+	if got := bl.Root().SizeOnDisk; got != before {
+		t.Errorf("root size on disk after refreshing the link = %d, want %d", got, before)
+	}
+}
+
+func TestFileTreeBuilderApplyChanges(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "a"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	bl := NewFileTreeBuilder(dir)
+	if err := bl.Build(); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	before := bl.Root().SizeOnDisk
+	apply := func(path string) {
+		t.Helper()
+		c, err := bl.PrepareChange(context.Background(), path)
+		if err != nil {
+			t.Fatalf("PrepareChange(%s): %v", path, err)
+		}
+		if c == nil {
+			t.Fatalf("PrepareChange(%s) returned no change", path)
+		}
+		if err := c.Apply(); err != nil {
+			t.Fatalf("Apply(%s): %v", path, err)
+		}
+	}
+
+	created := filepath.Join(dir, "a", "created")
+	if err := os.WriteFile(created, make([]byte, 10000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	apply(created)
+	n := bl.Root().Lookup("a", "created")
+	if n == nil {
+		t.Fatalf("created file is not in the tree")
+	}
+	if got, want := bl.Root().SizeOnDisk, before+n.SizeOnDisk; got != want {
+		t.Errorf("root size on disk after create = %d, want %d", got, want)
+	}
+
+	if err := os.Remove(created); err != nil {
+		t.Fatal(err)
+	}
+	apply(created)
+	if bl.Root().Lookup("a", "created") != nil {
+		t.Errorf("removed file is still in the tree")
+	}
+	if got := bl.Root().SizeOnDisk; got != before {
+		t.Errorf("root size on disk after remove = %d, want %d", got, before)
+	}
+}
+
+func TestFileTreeBuilderApplyStaleChanges(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "a"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	bl := NewFileTreeBuilder(dir)
+	if err := bl.Build(); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	before := bl.Root().SizeOnDisk
+	prepare := func(path string) *Change {
+		t.Helper()
+		c, err := bl.PrepareChange(context.Background(), path)
+		if err != nil || c == nil {
+			t.Fatalf("PrepareChange(%s) = %v, %v", path, c, err)
+		}
+		return c
+	}
+
+	// Two changes prepared for the same new path, as the watcher and a file
+	// command may do, add it once.
+	created := filepath.Join(dir, "a", "created")
+	if err := os.WriteFile(created, make([]byte, 10000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	first, second := prepare(created), prepare(created)
+	for _, c := range []*Change{first, second} {
+		if err := c.Apply(); err != nil {
+			t.Fatalf("Apply: %v", err)
+		}
+	}
+	a := bl.Root().Lookup("a")
+	if len(a.Children) != 1 {
+		t.Fatalf("a has %d children, want 1", len(a.Children))
+	}
+	if got, want := bl.Root().SizeOnDisk, before+a.Children[0].SizeOnDisk; got != want {
+		t.Errorf("root size on disk = %d, want %d", got, want)
+	}
+
+	// Changes prepared against nodes that were replaced since then still
+	// apply to what is in the tree now.
+	if err := os.WriteFile(created, make([]byte, 20000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	grown := prepare(created)
+	if _, err := bl.Refresh(context.Background(), a); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if err := grown.Apply(); err != nil {
+		t.Fatalf("Apply after refresh: %v", err)
+	}
+	n := bl.Root().Lookup("a", "created")
+	if n != grown.Fresh() {
+		t.Errorf("fresh node is not in the tree")
+	}
+	if got, want := bl.Root().SizeOnDisk, before+n.SizeOnDisk; got != want {
+		t.Errorf("root size on disk after refresh = %d, want %d", got, want)
+	}
+
+	if err := os.Remove(created); err != nil {
+		t.Fatal(err)
+	}
+	removed := prepare(created)
+	if err := prepare(filepath.Join(dir, "a")).Apply(); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if err := removed.Apply(); err != nil {
+		t.Errorf("Apply of a removal that already happened: %v", err)
+	}
+	if got := bl.Root().SizeOnDisk; got != before {
+		t.Errorf("root size on disk after remove = %d, want %d", got, before)
+	}
+}
+
+func TestFileTreeBuilderSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), make([]byte, 5000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bl := NewFileTreeBuilder(dir)
+	if err := bl.Build(); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	name := filepath.Join(t.TempDir(), "tree.snap")
+	if err := bl.Save(name); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	loaded := NewFileTreeBuilder("")
+	if err := loaded.Load(name); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !loaded.Offline() || loaded.Path() != dir {
+		t.Errorf("loaded builder: Offline() = %t, Path() = %q, want true, %q", loaded.Offline(), loaded.Path(), dir)
+	}
+	if got, want := loaded.Root().SizeOnDisk, bl.Root().SizeOnDisk; got != want {
+		t.Errorf("loaded size on disk = %d, want %d", got, want)
+	}
+	if _, err := loaded.Refresh(context.Background(), loaded.Root()); err == nil {
+		t.Errorf("Refresh of a loaded tree succeeded")
+	}
+}
+
+func TestFileTreeBuilderIgnoreFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		".glsignore":   "*.tmp\n",
+		"sub/keep.txt": "keep",
+		"sub/drop.tmp": "drop",
+	} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bl := NewFileTreeBuilder(dir)
+	if err := bl.Build(); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	sub := bl.Root().Lookup("sub")
+	if sub.Lookup("drop.tmp") != nil || sub.Lookup("keep.txt") == nil {
+		t.Fatalf("rules of the root ignore file were not applied")
+	}
+	// A subtree scanned on its own keeps the rules of the folders above.
+	fresh, err := bl.Rescan(context.Background(), sub)
+	if err != nil {
+		t.Fatalf("Rescan: %v", err)
+	}
+	if fresh.Lookup("drop.tmp") != nil {
+		t.Errorf("rescanned subtree lost the rules of the root ignore file")
+	}
+	// New rules are picked up as soon as their ignore file changes.
+	ignoreFile := filepath.Join(dir, "sub", ".glsignore")
+	if err := os.WriteFile(ignoreFile, []byte("!drop.tmp\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := bl.PrepareChange(context.Background(), ignoreFile)
+	if err != nil {
+		t.Fatalf("PrepareChange: %v", err)
+	}
+	if err := c.Apply(); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if bl.Root().Lookup("sub", "drop.tmp") == nil {
+		t.Errorf("rules of the new ignore file were not applied")
+	}
+}
+
+func TestFileTreeBuilderIgnoreStatsFromScanRoot(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		".glsignore": "*.tmp\n",
+		"drop.tmp":   "drop",
+		"keep":       "keep",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// The ignore file of the working directory is the one of the scan root,
+	// whichever way the root is given, so its rules are counted once.
+	for _, root := range []string{".", dir} {
+		ic, err := local.NewIgnoreChecker()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bl := NewFileTreeBuilder(root, WithIgnoreChecker(ic))
+		if err := bl.Build(); err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		stats := bl.IgnoreStats()
+		if len(stats) != 1 {
+			t.Fatalf("got %d rules from %s, want 1", len(stats), root)
+		}
+		if stats[0].Rule.Pattern != "*.tmp" || stats[0].Files != 1 {
+			t.Errorf("rule %q excluded %d files from %s, want *.tmp and 1", stats[0].Rule.Pattern, stats[0].Files, root)
+		}
+	}
+}
+
+func TestFileTreeBuilderRemoveTree(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{"a/b/x", "a/y", "a/locked/z", "keep"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(p)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, p), make([]byte, 5000), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bl := NewFileTreeBuilder(dir)
+	if err := bl.Build(); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	keep := bl.Root().Lookup("keep")
+
+	// Without write permission on locked, z cannot be removed, and neither
+	// can locked and a above it. Permissions do not stop root.
+	partial := os.Geteuid() > 0
+	if partial {
+		locked := filepath.Join(dir, "a", "locked")
+		if err := os.Chmod(locked, 0o555); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(locked, 0o755)
+	}
+	var last ProgressEvent
+	c, errs, err := bl.RemoveTree(context.Background(), bl.Root().Lookup("a"), func(ev ProgressEvent) { last = ev })
+	if err != nil {
+		t.Fatalf("RemoveTree: %v", err)
+	}
+	if err := c.Apply(); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if !last.Done {
+		t.Errorf("last progress event is not done: %+v", last)
+	}
+	if partial {
+		if len(errs) != 1 || last.Errors != 1 {
+			t.Fatalf("RemoveTree errors = %v (%d reported), want one", errs, last.Errors)
+		}
+		a := bl.Root().Lookup("a")
+		if a == nil || len(a.Children) != 1 || a.Lookup("locked", "z") == nil {
+			t.Fatalf("tree does not show what is left of a")
+		}
+		if last.Entries != 3 {
+			t.Errorf("removed entries = %d, want 3", last.Entries)
+		}
+		if got, want := bl.Root().SizeOnDisk, bl.Root().Stats.OwnSizeOnDisk+a.SizeOnDisk+keep.SizeOnDisk; got != want {
+			t.Errorf("root size on disk = %d, want %d", got, want)
+		}
+		return
+	}
+	if len(errs) > 0 {
+		t.Fatalf("RemoveTree errors: %v", errs)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+		t.Errorf("a was not removed: %v", err)
+	}
+	if bl.Root().Lookup("a") != nil {
+		t.Errorf("removed folder is still in the tree")
+	}
+	if last.Entries != 6 {
+		t.Errorf("removed entries = %d, want 6", last.Entries)
+	}
+	if got, want := bl.Root().SizeOnDisk, bl.Root().Stats.OwnSizeOnDisk+keep.SizeOnDisk; got != want {
+		t.Errorf("root size on disk = %d, want %d", got, want)
+	}
+
+	if _, _, err := bl.RemoveTree(context.Background(), bl.Root(), nil); err == nil {
+		t.Errorf("RemoveTree: wanted an error for the root")
+	}
+}
+
+func TestFileTreeBuilderMoveNode(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{"a/b/x", "a/y", "c/z"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(p)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, p), make([]byte, 5000), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bl := NewFileTreeBuilder(dir)
+	if err := bl.Build(); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	move := func(n *types.Node, dst string) bool {
+		t.Helper()
+		if err := os.Rename(n.RelativePath(dir), dst); err != nil {
+			t.Fatal(err)
+		}
+		moved, err := bl.MoveNode(n, dst)
+		if err != nil {
+			t.Fatalf("MoveNode(%s): %v", dst, err)
+		}
+		return moved
+	}
+
+	b := bl.Root().Lookup("a", "b")
+	if !move(b, dir+"/c/renamed") {
+		t.Fatalf("MoveNode did not move b")
+	}
+	if bl.Root().Lookup("c", "renamed") != b || bl.Root().Lookup("a", "b") != nil {
+		t.Errorf("b is not at its new place in the tree")
+	}
+	if !move(bl.Root().Lookup("a", "y"), dir+"/a/y2") {
+		t.Fatalf("MoveNode did not rename y")
+	}
+
+	fresh := NewFileTreeBuilder(dir)
+	if err := fresh.Build(); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	for _, names := range [][]string{nil, {"a"}, {"c"}, {"c", "renamed"}} {
+		got, want := bl.Root().Lookup(names...), fresh.Root().Lookup(names...)
+		if got.SizeOnDisk != want.SizeOnDisk || got.Size != want.Size {
+			t.Errorf("sizes of %v = %d, %d; want %d, %d", names, got.Size, got.SizeOnDisk, want.Size, want.SizeOnDisk)
+		}
+		if *got.Stats != *want.Stats {
+			t.Errorf("stats of %v = %+v, want %+v", names, *got.Stats, *want.Stats)
+		}
+	}
+
+	if _, err := bl.MoveNode(bl.Root().Lookup("c", "z"), filepath.Join(t.TempDir(), "z")); err == nil {
+		t.Errorf("MoveNode: wanted an error for a path outside the tree")
+	}
+	// Where ignore rules apply, the paths have to be scanned instead.
+	if err := os.WriteFile(filepath.Join(dir, "a", local.DefaultIgnoreFile), []byte("*.log\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if move(bl.Root().Lookup("a", "y2"), dir+"/a/y.log") {
+		t.Errorf("MoveNode moved a node where ignore rules apply")
+	}
+}
@@ -1,107 +1,495 @@
 package fs
 
 import (
+	"bytes"
 	"context"
-	"os"
+	"errors"
+	iofs "io/fs"
+	pathpkg "path"
+	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
 	"sync"
 
+	"go.sazak.io/gls/internal/archive"
 	"go.sazak.io/gls/internal/local"
+	"go.sazak.io/gls/internal/mount"
 	"go.sazak.io/gls/internal/size"
 	"go.sazak.io/gls/internal/types"
 	"go.sazak.io/gls/log"
-
-	"golang.org/x/sync/errgroup"
 )
 
+// DefaultJobs is the number of directories scanned concurrently when no job
+// count is given. Scanning is mostly waiting on syscalls, so it is worth
+// running more scanners than there are CPUs.
+var DefaultJobs = 4 * runtime.NumCPU()
+
 type WalkOptions struct {
+	// FS is the file system to scan, OS if nil.
+	FS            iofs.FS
 	IgnoreChecker *local.IgnoreChecker
+	// IgnoreStats, if set, counts what every ignore rule excludes.
+	IgnoreStats *IgnoreStats
+	// RelRoot is the path of the scanned root relative to the directory
+	// the ignore rules apply to, when a subtree of a larger tree is scanned.
+	// Empty means the root itself.
+	RelRoot string
+	// IgnoreFiles are the names of the ignore files looked for in every
+	// scanned directory. The rules of an ignore file apply to the subtree of
+	// its directory, on top of IgnoreChecker and the ignore files above.
+	IgnoreFiles []string
+	// Filter, if set, selects the files that are shown. The files it leaves
+	// out are hidden, and so are the directories left without any shown
+	// entries.
+	Filter        *Filter
 	SizeThreshold int64
+	Progress      *Progress
+	// Jobs bounds the number of directories scanned at the same time, and
+	// thereby the number of goroutines and open directory handles. Values
+	// less than 1 mean DefaultJobs.
+	Jobs int
+	// FollowSymlinks makes Walk count the targets of symbolic links and
+	// descend into linked directories, instead of treating links as files.
+	FollowSymlinks bool
+	// OneFilesystem stops Walk from descending into directories that are
+	// mount points of other file systems, like du -x.
+	OneFilesystem bool
+	// ExpandArchives makes Walk list the entries of archive files as their
+	// children. Archives in other archives are not expanded.
+	ExpandArchives bool
+
+	// links, if set, holds the hard-linked inodes counted outside the
+	// scanned subtree, which are not counted again, and records those the
+	// scan counts.
+	links *hardLinks
 }
 
-func Walk(path string, opts *WalkOptions) (*types.Node, error) {
-	f, err := os.Lstat(path)
-	if err != nil {
-		log.Warningf("%s: %v", path, err)
-		return nil, nil
+// walker scans a file tree with a bounded pool of workers. A directory is
+// handed to an idle worker if there is one, and scanned inline by the
+// current goroutine otherwise, so a worker waiting for its subdirectories
+// can never starve the pool.
+type walker struct {
+	opts   *WalkOptions
+	fsys   iofs.FS
+	usage  size.DiskUsage
+	slots  chan struct{}
+	cancel context.CancelFunc
+
+	// links is the set of hard-linked inodes already counted.
+	links *hardLinks
+
+	// rootPath is the path the scan started at, and absRoot its absolute
+	// form, used to look scanned paths up in the mount table of the OS.
+	rootPath string
+	absRoot  string
+	mounts   mount.Table
+
+	// failedMu guards failed, the set of nodes with a scan error in their
+	// subtree, which are kept in the tree whatever their size.
+	failedMu sync.Mutex
+	failed   map[*types.Node]struct{}
+
+	// filteredMu guards filtered, the set of files left out by the filter.
+	filteredMu sync.Mutex
+	filtered   map[*types.Node]struct{}
+}
+
+// ancestor is a link in the chain of directories above the one being
+// scanned, used to detect symbolic link cycles.
+type ancestor struct {
+	id     size.FileID
+	parent *ancestor
+}
+
+func (a *ancestor) contains(id size.FileID) bool {
+	if id == (size.FileID{}) {
+		// Nothing is known about the identity of the file.
+		return false
 	}
-	//implements os dependent proper methods.
-	var diskUsage size.DiskUsage = &size.FsInfo{}
-	size, err := diskUsage.GetSize(f)
-	if err != nil {
+	for ; a != nil; a = a.parent {
+		if a.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Walk scans the file tree under root in opts.FS. The scan stops early and
+// returns the context's error once ctx is cancelled.
+func Walk(ctx context.Context, root string, opts *WalkOptions) (*types.Node, error) {
+	jobs := opts.Jobs
+	if jobs < 1 {
+		jobs = DefaultJobs
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	fsys := opts.FS
+	if fsys == nil {
+		fsys = OS
+	}
+	root = pathpkg.Clean(root)
+	links := opts.links
+	if links == nil {
+		links = &hardLinks{}
+	}
+	w := &walker{
+		opts:  opts,
+		fsys:  fsys,
+		usage: diskUsage(fsys),
+		// The calling goroutine is a worker too.
+		slots:  make(chan struct{}, jobs-1),
+		cancel: cancel,
+		links:  links,
+		failed: make(map[*types.Node]struct{}),
+
+		filtered: make(map[*types.Node]struct{}),
+
+		rootPath: root,
+	}
+	if fsys == OS {
+		var err error
+		if w.absRoot, err = filepath.Abs(root); err != nil {
+			return nil, err
+		}
+		if w.mounts, err = mount.LoadTable(); err != nil {
+			log.Warningf("Could not read the mount table: %v", err)
+		}
+	}
+	opts.IgnoreStats.addRules(opts.IgnoreChecker)
+	return w.walk(ctx, root, opts.RelRoot, opts.IgnoreChecker, nil)
+}
+
+// walk scans the entry at path, whose path relative to the directory of the
+// ignore rules is rel. ic holds the ignore rules in effect in the directory
+// of the entry.
+func (w *walker) walk(ctx context.Context, path, rel string, ic *local.IgnoreChecker, parent *ancestor) (*types.Node, error) {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
-	sizeOnDisk, err := diskUsage.GetSizeOnDisk(f)
+	f, err := lstat(w.fsys, path)
+	if err != nil {
+		if parent == nil {
+			return nil, err
+		}
+		n := &types.Node{Name: filepath.Base(path)}
+		w.setScanError(path, n, err)
+		return n, nil
+	}
+	root, id, err := w.newNode(f)
 	if err != nil {
 		return nil, err
 	}
-	root := &types.Node{
-		Name:             f.Name(),
-		Mode:             f.Mode(),
-		Size:             size,
-		SizeOnDisk:       sizeOnDisk,
-		IsDir:            f.IsDir(),
-		LastModification: f.ModTime(),
+	followed := false
+	if root.IsSymlink() {
+		var targetID size.FileID
+		if followed, targetID, err = w.resolveSymlink(path, root); err != nil {
+			return nil, err
+		}
+		if followed {
+			id = targetID
+		}
+	}
+	if id != (size.FileID{}) && (followed || (!root.IsDir && root.Links > 1) || (root.IsDir && w.opts.FollowSymlinks)) {
+		// Anything reachable through a followed link may be reached again
+		// through its real path, so it is only counted once.
+		root.DuplicateLink = !w.links.add(id, rel)
 	}
-	if root.IsDir {
-		names, err := readDirNames(path)
-		if err != nil {
-			log.Warningf("%s: %v", path, err)
+	w.opts.Progress.addEntry(root.UniqueSizeOnDisk())
+	if !root.IsDir {
+		if !w.opts.Filter.selects(rel, root, func() (uint32, error) { return w.usage.GetOwner(f) }) {
+			w.markFiltered(root)
+		}
+		if w.opts.ExpandArchives && w.fsys == OS && root.Mode.IsRegular() && archive.Format(root.Name) != "" {
+			opts := *w.opts
+			opts.IgnoreChecker = ic
+			if err := expandArchive(ctx, path, rel, root, &opts); err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				// The archive itself was scanned fine.
+				log.Warningf("%s: could not read archive: %v", path, err)
+			}
+		}
+		return root, nil
+	}
+	if parent.contains(id) {
+		log.Warningf("%s: symbolic link cycle, not descending", path)
+		return root, nil
+	}
+	if mp, ok := w.mounts.Lookup(w.absPath(path)); ok || (parent != nil && id.Device != parent.id.Device) {
+		root.MountPoint = true
+		root.FsType = mp.FsType
+		if w.opts.OneFilesystem && parent != nil {
+			log.Debugf("%s: mount point of %s, not descending", path, root.FsType)
 			return root, nil
 		}
+	}
+	self := &ancestor{id: id, parent: parent}
+	root.Stats = &types.Stats{OwnSizeOnDisk: root.SizeOnDisk}
 
-		eg, _ := errgroup.WithContext(context.Background())
-		rl := &sync.Mutex{}
-
-		for _, name := range names {
-			var curr = name
-			eg.Go(func() error {
-				child, err := Walk(path+"/"+curr, opts)
-				if err != nil {
-					return err
-				}
-				child.Parent = root
-				rl.Lock()
-				defer rl.Unlock()
-				root.Size += child.Size
-				root.SizeOnDisk += child.SizeOnDisk
-				threshOK := child.Size >= opts.SizeThreshold
-				ignoreOK := true
-				if opts.IgnoreChecker != nil && opts.IgnoreChecker.ShouldIgnore(child.Name, child.IsDir) {
-					ignoreOK = false
-				}
-				if !ignoreOK {
-					log.Debugf("ignore: %s", path+"/"+child.Name)
-				}
-				if threshOK && ignoreOK {
-					root.Children = append(root.Children, child)
+	w.opts.Progress.enterDir(path)
+	entries, err := readDirEntries(w.fsys, path)
+	if err != nil {
+		w.setScanError(path, root, err)
+		return root, nil
+	}
+	if len(w.opts.IgnoreFiles) > 0 {
+		scoped := scopeIgnoreRules(w.fsys, path, rel, w.opts.IgnoreFiles, ic, func(name string) bool {
+			for _, e := range entries {
+				if e.Name() == name {
+					return e.Type().IsRegular()
 				}
-				return nil
-			})
+			}
+			return false
+		})
+		if scoped != ic {
+			w.opts.IgnoreStats.addRules(scoped)
+			ic = scoped
 		}
+	}
 
-		if err := eg.Wait(); err != nil {
-			return nil, err
+	var (
+		wg       sync.WaitGroup
+		rl       sync.Mutex
+		firstErr error
+	)
+	addChild := func(child *types.Node, err error) {
+		rl.Lock()
+		defer rl.Unlock()
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+				w.cancel()
+			}
+			return
+		}
+		if child == nil {
+			return
 		}
+		child.Parent = root
+		root.Size += child.UniqueSize()
+		root.SizeOnDisk += child.UniqueSizeOnDisk()
+		root.ApparentSize += child.ApparentSize
+		root.ApparentSizeOnDisk += child.ApparentSizeOnDisk
+		root.Stats.Add(child)
+		childFailed := w.hasFailed(child)
+		if childFailed {
+			w.markFailed(root)
+		}
+		// Entries that could not be scanned are kept whatever their size
+		// or filter, so that it shows where the totals are incomplete.
+		threshOK := child.Size >= w.opts.SizeThreshold || childFailed
+		ignoreOK := true
+		if rule, ignored := ic.Match(joinRel(rel, child.Name), child.IsDir); ignored {
+			ignoreOK = false
+			log.Debugf("ignore: %s", path+"/"+child.Name)
+			w.opts.IgnoreStats.add(rule, child)
+		}
+		filterOK := childFailed || !w.isFiltered(child)
+		if child.IsDir && !childFailed {
+			filterOK = w.opts.Filter.selectsDir(child)
+		}
+		if threshOK && ignoreOK && filterOK {
+			root.Children = append(root.Children, child)
+		} else {
+			// Only the entry itself is kept, so it can be listed on demand
+			// without holding on to its whole subtree.
+			child.Children, child.Hidden = nil, nil
+			root.Hidden = append(root.Hidden, child)
+		}
+	}
 
-		sort.Slice(root.Children, func(i, j int) bool {
-			return strings.Compare(root.Children[i].Name, root.Children[j].Name) == -1
-		})
+	for _, e := range entries {
+		childPath := joinPath(path, e.Name())
+		childRel := joinRel(rel, e.Name())
+		if !e.IsDir() {
+			addChild(w.walk(ctx, childPath, childRel, ic, self))
+			continue
+		}
+		select {
+		case w.slots <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-w.slots
+					wg.Done()
+				}()
+				addChild(w.walk(ctx, childPath, childRel, ic, self))
+			}()
+		default:
+			addChild(w.walk(ctx, childPath, childRel, ic, self))
+		}
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
 	}
+	sort.Slice(root.Children, func(i, j int) bool {
+		return strings.Compare(root.Children[i].Name, root.Children[j].Name) == -1
+	})
+	sort.Slice(root.Hidden, func(i, j int) bool {
+		return strings.Compare(root.Hidden[i].Name, root.Hidden[j].Name) == -1
+	})
 	return root, nil
 }
 
-// Taken directly from the Go stdlib
-func readDirNames(dirname string) ([]string, error) {
-	f, err := os.Open(dirname)
+// joinPath returns the path of the entry name in the directory dir, which
+// is also a valid io/fs path if dir is.
+func joinPath(dir, name string) string {
+	if dir == "." {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// scopeIgnoreRules returns ic with the rules of the ignore files in the
+// directory at path on top, in the order of names. rel is the path of the
+// directory relative to the root of the ignore rules, and has reports
+// whether it contains a file with the given name; a nil has makes it try
+// every name. Ignore files that cannot be read are skipped with a warning,
+// and those whose rules are part of ic already are skipped silently.
+func scopeIgnoreRules(fsys iofs.FS, path, rel string, names []string, ic *local.IgnoreChecker, has func(name string) bool) *local.IgnoreChecker {
+	for _, name := range names {
+		if has != nil && !has(name) {
+			continue
+		}
+		p := joinPath(path, name)
+		if fsys == OS && ic.HasRuleFile(p) {
+			continue
+		}
+		data, err := iofs.ReadFile(fsys, p)
+		if err != nil {
+			if !errors.Is(err, iofs.ErrNotExist) {
+				log.Warningf("Could not read ignore file: %v", err)
+			}
+			continue
+		}
+		scoped, err := ic.Scoped(rel, p, bytes.NewReader(data))
+		if err != nil {
+			log.Warningf("Skipping ignore file: %v", err)
+			continue
+		}
+		ic = scoped
+	}
+	return ic
+}
+
+// joinRel returns the relative path of the entry name in the directory at
+// the relative path dir, which is empty for the root.
+func joinRel(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// absPath returns the absolute form of a path below the scan root.
+func (w *walker) absPath(path string) string {
+	if w.rootPath == "." {
+		return filepath.Join(w.absRoot, path)
+	}
+	return w.absRoot + strings.TrimPrefix(path, w.rootPath)
+}
+
+// resolveSymlink records the target of the symbolic link n at path. If
+// symbolic links are followed and the target exists, n takes over the sizes
+// and type of the target, and the id of the target is returned.
+func (w *walker) resolveSymlink(path string, n *types.Node) (followed bool, id size.FileID, err error) {
+	target, err := readLink(w.fsys, path)
 	if err != nil {
-		return nil, err
+		w.setScanError(path, n, err)
+		return false, size.FileID{}, nil
 	}
-	names, err := f.Readdirnames(-1)
-	f.Close()
+	n.LinkTarget = target
+	f, err := iofs.Stat(w.fsys, path)
 	if err != nil {
-		return nil, err
+		n.BrokenLink = true
+		return false, size.FileID{}, nil
+	}
+	if !w.opts.FollowSymlinks {
+		return false, size.FileID{}, nil
+	}
+	t, id, err := w.newNode(f)
+	if err != nil {
+		return false, size.FileID{}, err
+	}
+	n.Size = t.Size
+	n.SizeOnDisk = t.SizeOnDisk
+	n.ApparentSize = t.ApparentSize
+	n.ApparentSizeOnDisk = t.ApparentSizeOnDisk
+	n.Links = t.Links
+	n.IsDir = t.IsDir
+	n.LastModification = t.LastModification
+	return true, id, nil
+}
+
+// setScanError records that n at path could not be scanned completely.
+func (w *walker) setScanError(path string, n *types.Node, err error) {
+	log.Warningf("%s: %v", path, err)
+	w.opts.Progress.addError()
+	var pe *iofs.PathError
+	if errors.As(err, &pe) {
+		// The path is shown by the tree already.
+		err = pe.Err
+	}
+	n.ScanError = err.Error()
+	w.markFailed(n)
+}
+
+func (w *walker) markFailed(n *types.Node) {
+	w.failedMu.Lock()
+	defer w.failedMu.Unlock()
+	w.failed[n] = struct{}{}
+}
+
+func (w *walker) hasFailed(n *types.Node) bool {
+	w.failedMu.Lock()
+	defer w.failedMu.Unlock()
+	_, ok := w.failed[n]
+	return ok
+}
+
+func (w *walker) markFiltered(n *types.Node) {
+	w.filteredMu.Lock()
+	defer w.filteredMu.Unlock()
+	w.filtered[n] = struct{}{}
+}
+
+func (w *walker) isFiltered(n *types.Node) bool {
+	w.filteredMu.Lock()
+	defer w.filteredMu.Unlock()
+	_, ok := w.filtered[n]
+	return ok
+}
+
+func (w *walker) newNode(f iofs.FileInfo) (*types.Node, size.FileID, error) {
+	fileSize, err := w.usage.GetSize(f)
+	if err != nil {
+		return nil, size.FileID{}, err
+	}
+	sizeOnDisk, err := w.usage.GetSizeOnDisk(f)
+	if err != nil {
+		return nil, size.FileID{}, err
+	}
+	id, err := w.usage.GetFileID(f)
+	if err != nil {
+		return nil, size.FileID{}, err
+	}
+	links, err := w.usage.GetLinkCount(f)
+	if err != nil {
+		return nil, size.FileID{}, err
 	}
-	return names, nil
+	return &types.Node{
+		Name:               f.Name(),
+		Mode:               f.Mode(),
+		Size:               fileSize,
+		SizeOnDisk:         sizeOnDisk,
+		ApparentSize:       fileSize,
+		ApparentSizeOnDisk: sizeOnDisk,
+		Links:              links,
+		IsDir:              f.IsDir(),
+		LastModification:   f.ModTime(),
+	}, id, nil
 }
@@ -0,0 +1,534 @@
+package fs
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.sazak.io/gls/internal/local"
+	"go.sazak.io/gls/internal/types"
+)
+
+func testMapFS() fstest.MapFS {
+	return fstest.MapFS{
+		"a/small.txt":   {Data: make([]byte, 10)},
+		"a/big.mp4":     {Data: make([]byte, 5000)},
+		"a/b/deep.txt":  {Data: make([]byte, 300)},
+		"c/medium.log":  {Data: make([]byte, 1000)},
+		"c/skip/x.bin":  {Data: make([]byte, 2000)},
+		"empty":         {Mode: os.ModeDir | 0o755},
+		"top-level.txt": {Data: make([]byte, 1)},
+	}
+}
+
+// names lists the paths of the nodes in the tree below n, the directories
+// with a trailing slash.
+func names(n *types.Node) []string {
+	var paths []string
+	var visit func(n *types.Node, prefix string)
+	visit = func(n *types.Node, prefix string) {
+		for _, c := range n.Children {
+			p := prefix + c.Name
+			if c.IsDir {
+				paths = append(paths, p+"/")
+				visit(c, p+"/")
+				continue
+			}
+			paths = append(paths, p)
+		}
+	}
+	visit(n, "")
+	return paths
+}
+
+// hiddenNames lists the paths of the hidden children in the tree below n,
+// the directories with a trailing slash.
+func hiddenNames(n *types.Node) []string {
+	var paths []string
+	var visit func(n *types.Node, prefix string)
+	visit = func(n *types.Node, prefix string) {
+		for _, h := range n.Hidden {
+			p := prefix + h.Name
+			if h.IsDir {
+				p += "/"
+			}
+			paths = append(paths, p)
+		}
+		for _, c := range n.Children {
+			visit(c, prefix+c.Name+"/")
+		}
+	}
+	visit(n, "")
+	return paths
+}
+
+func TestWalkFS(t *testing.T) {
+	ruleFile := filepath.Join(t.TempDir(), "ignore")
+	if err := os.WriteFile(ruleFile, []byte("*.mp4\nskip/\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ignoreChecker, err := local.NewIgnoreChecker(local.WithRuleFile(ruleFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	anchoredRuleFile := filepath.Join(t.TempDir(), "anchored")
+	if err := os.WriteFile(anchoredRuleFile, []byte("a/b/deep.txt\n/small.txt\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	anchoredChecker, err := local.NewIgnoreChecker(local.WithRuleFile(anchoredRuleFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		desc      string
+		root      string
+		opts      WalkOptions
+		wantSize  int64
+		wantNames []string
+		// wantHidden are the entries left out of the tree, which are kept
+		// without their own entries.
+		wantHidden []string
+	}{
+		{
+			desc:     "everything",
+			root:     ".",
+			wantSize: 8311,
+			wantNames: []string{
+				"a/", "a/b/", "a/b/deep.txt", "a/big.mp4", "a/small.txt",
+				"c/", "c/medium.log", "c/skip/", "c/skip/x.bin",
+				"empty/", "top-level.txt",
+			},
+		},
+		{
+			desc:      "subdirectory",
+			root:      "a/b",
+			wantSize:  300,
+			wantNames: []string{"deep.txt"},
+		},
+		{
+			desc:     "size threshold",
+			root:     ".",
+			opts:     WalkOptions{SizeThreshold: 1000},
+			wantSize: 8311,
+			wantNames: []string{
+				"a/", "a/big.mp4",
+				"c/", "c/medium.log", "c/skip/", "c/skip/x.bin",
+			},
+			wantHidden: []string{"empty/", "top-level.txt", "a/b/", "a/small.txt"},
+		},
+		{
+			desc:     "ignore rules",
+			root:     ".",
+			opts:     WalkOptions{IgnoreChecker: ignoreChecker},
+			wantSize: 8311,
+			wantNames: []string{
+				"a/", "a/b/", "a/b/deep.txt", "a/small.txt",
+				"c/", "c/medium.log",
+				"empty/", "top-level.txt",
+			},
+			wantHidden: []string{"a/big.mp4", "c/skip/"},
+		},
+		{
+			desc:     "anchored ignore rules",
+			root:     ".",
+			opts:     WalkOptions{IgnoreChecker: anchoredChecker},
+			wantSize: 8311,
+			wantNames: []string{
+				"a/", "a/b/", "a/big.mp4", "a/small.txt",
+				"c/", "c/medium.log", "c/skip/", "c/skip/x.bin",
+				"empty/", "top-level.txt",
+			},
+			wantHidden: []string{"a/b/deep.txt"},
+		},
+		{
+			desc:       "anchored ignore rules in a subtree",
+			root:       "a/b",
+			opts:       WalkOptions{IgnoreChecker: anchoredChecker, RelRoot: "a/b"},
+			wantSize:   300,
+			wantNames:  nil,
+			wantHidden: []string{"deep.txt"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := tc.opts
+			opts.FS = testMapFS()
+			root, err := Walk(context.Background(), tc.root, &opts)
+			if err != nil {
+				t.Fatalf("Walk: %v", err)
+			}
+			// Hidden entries still count towards the sizes of their parents.
+			assert.Equal(t, tc.wantSize, root.Size)
+			assert.Equal(t, tc.wantSize, root.SizeOnDisk)
+			assert.Equal(t, tc.wantNames, names(root))
+			assert.Equal(t, tc.wantHidden, hiddenNames(root))
+		})
+	}
+}
+
+func TestFprintHidden(t *testing.T) {
+	root, err := Walk(context.Background(), "a", &WalkOptions{FS: testMapFS(), SizeThreshold: 1000})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	cases := []struct {
+		desc string
+		opts types.PrintOptions
+		want string
+	}{
+		{
+			desc: "summarized",
+			want: "a [5310]\n  big.mp4 [5000]\n  <2 hidden items, 310>\n",
+		},
+		{
+			desc: "shown",
+			opts: types.PrintOptions{ShowHidden: true},
+			want: "a [5310]\n  big.mp4 [5000]\n  b [300]\n  small.txt [10]\n",
+		},
+		{
+			desc: "dimmed",
+			opts: types.PrintOptions{Dim: true},
+			want: "a [5310]\n  big.mp4 [5000]\n\x1b[2m  <2 hidden items, 310>\x1b[22m\n",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var buf bytes.Buffer
+			root.Fprint(&buf, tc.opts)
+			assert.Equal(t, tc.want, buf.String())
+		})
+	}
+}
+
+func TestWalkIgnoreFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		".glsignore":          {Data: []byte("*.tmp\n")},
+		".gitignore":          {Data: []byte("*.bak\n")},
+		"b.o":                 {Data: []byte("b")},
+		"x.tmp":               {Data: []byte("x")},
+		"x.bak":               {Data: []byte("x")},
+		"pkg/.glsignore":      {Data: []byte("*.o\n/out/\n!keep.tmp\n")},
+		"pkg/a.o":             {Data: []byte("a")},
+		"pkg/keep.tmp":        {Data: []byte("k")},
+		"pkg/drop.tmp":        {Data: []byte("d")},
+		"pkg/out/x":           {Data: []byte("x")},
+		"pkg/sub/out/y":       {Data: []byte("y")},
+		"pkg/sub/.glsignore":  {Data: []byte("!a.o\n")},
+		"pkg/sub/a.o":         {Data: []byte("a")},
+		"other/.gitignore":    {Data: []byte("*\n")},
+		"other/not-ignored.o": {Data: []byte("n")},
+	}
+	cases := []struct {
+		desc      string
+		root      string
+		opts      WalkOptions
+		wantNames []string
+	}{
+		{
+			desc: "glsignore",
+			root: ".",
+			opts: WalkOptions{IgnoreFiles: []string{".glsignore"}},
+			wantNames: []string{
+				".gitignore", ".glsignore", "b.o",
+				"other/", "other/.gitignore", "other/not-ignored.o",
+				"pkg/", "pkg/.glsignore", "pkg/keep.tmp",
+				"pkg/sub/", "pkg/sub/.glsignore", "pkg/sub/a.o", "pkg/sub/out/", "pkg/sub/out/y",
+				"x.bak",
+			},
+		},
+		{
+			desc: "glsignore and gitignore",
+			root: ".",
+			opts: WalkOptions{IgnoreFiles: []string{".glsignore", ".gitignore"}},
+			wantNames: []string{
+				".gitignore", ".glsignore", "b.o",
+				"other/",
+				"pkg/", "pkg/.glsignore", "pkg/keep.tmp",
+				"pkg/sub/", "pkg/sub/.glsignore", "pkg/sub/a.o", "pkg/sub/out/", "pkg/sub/out/y",
+			},
+		},
+		{
+			desc:      "subtree",
+			root:      "pkg",
+			opts:      WalkOptions{IgnoreFiles: []string{".glsignore"}, RelRoot: "pkg"},
+			wantNames: []string{".glsignore", "drop.tmp", "keep.tmp", "sub/", "sub/.glsignore", "sub/a.o", "sub/out/", "sub/out/y"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			opts := tc.opts
+			opts.FS = fsys
+			root, err := Walk(context.Background(), tc.root, &opts)
+			if err != nil {
+				t.Fatalf("Walk: %v", err)
+			}
+			assert.Equal(t, tc.wantNames, names(root))
+		})
+	}
+}
+
+func TestWalkFilter(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"logs/old.log":   {Data: make([]byte, 100), ModTime: now.AddDate(0, -3, 0)},
+		"logs/new.log":   {Data: make([]byte, 200), ModTime: now.AddDate(0, 0, -1)},
+		"logs/link":      {Data: []byte("new.log"), Mode: os.ModeSymlink, ModTime: now.AddDate(0, -3, 0)},
+		"src/main.go":    {Data: make([]byte, 400), ModTime: now.AddDate(0, -3, 0)},
+		"src/vendor.log": {Data: make([]byte, 800), ModTime: now.AddDate(0, -3, 0)},
+	}
+	include := func(patterns ...string) *local.IgnoreChecker {
+		ic, err := local.CompileRules("-include", patterns...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ic
+	}
+	cases := []struct {
+		desc       string
+		filter     *Filter
+		wantNames  []string
+		wantHidden []string
+	}{
+		{
+			desc:      "no filter",
+			filter:    &Filter{},
+			wantNames: []string{"logs/", "logs/link", "logs/new.log", "logs/old.log", "src/", "src/main.go", "src/vendor.log"},
+		},
+		{
+			desc:       "include glob",
+			filter:     &Filter{Include: include("*.log", "!vendor.log")},
+			wantNames:  []string{"logs/", "logs/new.log", "logs/old.log"},
+			wantHidden: []string{"src/", "logs/link"},
+		},
+		{
+			desc:       "include directory",
+			filter:     &Filter{Include: include("src/")},
+			wantNames:  []string{"src/", "src/main.go", "src/vendor.log"},
+			wantHidden: []string{"logs/"},
+		},
+		{
+			desc:       "include regex",
+			filter:     &Filter{Include: include(`re:\.go$`)},
+			wantNames:  []string{"src/", "src/main.go"},
+			wantHidden: []string{"logs/", "src/vendor.log"},
+		},
+		{
+			desc:       "older than a month",
+			filter:     &Filter{Include: include("*.log"), ModifiedBefore: now.AddDate(0, -1, 0)},
+			wantNames:  []string{"logs/", "logs/old.log", "src/", "src/vendor.log"},
+			wantHidden: []string{"logs/link", "logs/new.log", "src/main.go"},
+		},
+		{
+			desc:       "newer than a week",
+			filter:     &Filter{ModifiedAfter: now.AddDate(0, 0, -7)},
+			wantNames:  []string{"logs/", "logs/new.log"},
+			wantHidden: []string{"src/", "logs/link", "logs/old.log"},
+		},
+		{
+			desc:       "symbolic links",
+			filter:     &Filter{Types: []os.FileMode{os.ModeSymlink}},
+			wantNames:  []string{"logs/", "logs/link"},
+			wantHidden: []string{"src/", "logs/new.log", "logs/old.log"},
+		},
+		{
+			desc:       "folders with at least two files",
+			filter:     &Filter{MinFiles: 2},
+			wantNames:  []string{"logs/", "logs/link", "logs/new.log", "logs/old.log", "src/", "src/main.go", "src/vendor.log"},
+			wantHidden: nil,
+		},
+		{
+			desc:       "folders with at least three files",
+			filter:     &Filter{MinFiles: 3},
+			wantNames:  []string{"logs/", "logs/link", "logs/new.log", "logs/old.log"},
+			wantHidden: []string{"src/"},
+		},
+		{
+			desc:       "owner of files without owners",
+			filter:     &Filter{Owners: []uint32{0}},
+			wantHidden: []string{"logs/", "src/"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.desc, func(t *testing.T) {
+			root, err := Walk(context.Background(), ".", &WalkOptions{FS: fsys, Filter: tc.filter})
+			if err != nil {
+				t.Fatalf("Walk: %v", err)
+			}
+			// Filtered entries still count towards the sizes of their parents.
+			assert.Equal(t, int64(1507), root.Size)
+			assert.Equal(t, tc.wantNames, names(root))
+			assert.Equal(t, tc.wantHidden, hiddenNames(root))
+		})
+	}
+}
+
+func TestWalkStats(t *testing.T) {
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	ignoreChecker, err := local.CompileRules("test", "*.tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"a/x.txt":       {Data: make([]byte, 10), ModTime: now},
+		"a/b/c/big.bin": {Data: make([]byte, 900), ModTime: old},
+		"a/b/y.tmp":     {Data: make([]byte, 20), ModTime: now.AddDate(1, 0, 0)},
+		"d/z.txt":       {Data: make([]byte, 500), ModTime: now},
+		"empty":         {Mode: os.ModeDir | 0o755},
+	}
+	root, err := Walk(context.Background(), ".", &WalkOptions{FS: fsys, IgnoreChecker: ignoreChecker})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	// The ignored y.tmp is counted like its size is.
+	assert.Equal(t, types.Stats{
+		Files:       4,
+		Dirs:        5,
+		Depth:       4,
+		LargestFile: "a/b/c/big.bin",
+		LargestSize: 900,
+		Newest:      now.AddDate(1, 0, 0),
+		Oldest:      old,
+	}, *root.Stats)
+	assert.Equal(t, 4, root.FileCount())
+	a := root.Lookup("a")
+	assert.Equal(t, types.Stats{
+		Files:       3,
+		Dirs:        2,
+		Depth:       3,
+		LargestFile: "b/c/big.bin",
+		LargestSize: 900,
+		Newest:      now.AddDate(1, 0, 0),
+		Oldest:      old,
+	}, *a.Stats)
+	assert.Equal(t, types.Stats{}, *root.Lookup("empty").Stats)
+
+	// The stats of the ancestors follow changes to the tree.
+	if err := a.DetachChild(a.Lookup("b")); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(2), root.Stats.Files)
+	assert.Equal(t, 2, root.Stats.Depth)
+	assert.Equal(t, "d/z.txt", root.Stats.LargestFile)
+	assert.Equal(t, now, root.Stats.Oldest)
+
+	// Entries with as many files are ordered by size.
+	root.SortChildren(types.SortByFiles)
+	assert.Equal(t, []string{"d/", "d/z.txt", "a/", "a/x.txt", "empty/"}, names(root))
+	root.SortChildren(types.SortByName)
+	assert.Equal(t, []string{"a/", "a/x.txt", "d/", "d/z.txt", "empty/"}, names(root))
+}
+
+func TestParseFileTypes(t *testing.T) {
+	types, err := ParseFileTypes("f, l,b")
+	if err != nil {
+		t.Fatalf("ParseFileTypes: %v", err)
+	}
+	assert.Equal(t, []os.FileMode{0, os.ModeSymlink, os.ModeDevice}, types)
+	for _, s := range []string{"d", "x", "ff"} {
+		if _, err := ParseFileTypes(s); err == nil {
+			t.Errorf("ParseFileTypes(%q): wanted an error", s)
+		}
+	}
+}
+
+func TestWalkFSNotFound(t *testing.T) {
+	if _, err := Walk(context.Background(), "missing", &WalkOptions{FS: testMapFS()}); !os.IsNotExist(err) {
+		t.Errorf("Walk of a missing root returned %v, want a not-exist error", err)
+	}
+}
+
+func TestWalkExpandsArchives(t *testing.T) {
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "logs.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, size := range map[string]int{"app.log": 50000, "old/app.log.1": 20000, "tiny": 1} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(make([]byte, size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := Walk(context.Background(), dir, &WalkOptions{ExpandArchives: true, SizeThreshold: 100})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	zipNode := root.Lookup("logs.zip")
+	if zipNode == nil {
+		t.Fatalf("archive is missing from the tree")
+	}
+	assert.Equal(t, "zip", zipNode.Archive)
+	assert.Equal(t, int64(70001), zipNode.UncompressedSize)
+	// The threshold applies to the uncompressed sizes of the entries.
+	assert.Equal(t, []string{"app.log", "old/", "old/app.log.1"}, names(zipNode))
+	entry := zipNode.Lookup("old", "app.log.1")
+	assert.True(t, entry.Virtual)
+	assert.Equal(t, int64(20000), entry.Size)
+	assert.Less(t, entry.SizeOnDisk, entry.Size)
+	// The entries take no space besides the archive file.
+	plain, err := Walk(context.Background(), dir, &WalkOptions{})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	assert.Equal(t, plain.SizeOnDisk, root.SizeOnDisk)
+}
+
+func TestWalkIgnoreStats(t *testing.T) {
+	ruleFile := filepath.Join(t.TempDir(), "ignore")
+	if err := os.WriteFile(ruleFile, []byte("*.mp4\nskip/\n*.nothing\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ignoreChecker, err := local.NewIgnoreChecker(local.WithRuleFile(ruleFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := testMapFS()
+	fsys["c/.glsignore"] = &fstest.MapFile{Data: []byte("# logs\n*.log\n")}
+	stats := &IgnoreStats{}
+	if _, err := Walk(context.Background(), ".", &WalkOptions{
+		FS:            fsys,
+		IgnoreChecker: ignoreChecker,
+		IgnoreFiles:   []string{".glsignore"},
+		IgnoreStats:   stats,
+	}); err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	type count struct {
+		file       string
+		line       int
+		pattern    string
+		files      int
+		dirs       int
+		sizeOnDisk int64
+	}
+	var got []count
+	for _, rs := range stats.Rules() {
+		got = append(got, count{filepath.Base(rs.Rule.File), rs.Rule.Line, rs.Rule.Pattern, rs.Files, rs.Dirs, rs.SizeOnDisk})
+	}
+	assert.Equal(t, []count{
+		{"ignore", 1, "*.mp4", 1, 0, 5000},
+		{"ignore", 2, "skip/", 0, 1, 2000},
+		{"ignore", 3, "*.nothing", 0, 0, 0},
+		{".glsignore", 2, "*.log", 1, 0, 1000},
+	}, got)
+}
@@ -0,0 +1,113 @@
+package fs
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.sazak.io/gls/internal/size"
+	"go.sazak.io/gls/internal/types"
+	"go.sazak.io/gls/log"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// legacyWalk is the unbounded traversal Walk used before the worker pool,
+// which starts one goroutine for every directory entry. It is only kept as
+// a baseline for the benchmarks.
+func legacyWalk(ctx context.Context, path string, opts *WalkOptions) (*types.Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := os.Lstat(path)
+	if err != nil {
+		log.Warningf("%s: %v", path, err)
+		opts.Progress.addError()
+		return nil, nil
+	}
+	//implements os dependent proper methods.
+	var diskUsage size.DiskUsage = &size.FsInfo{}
+	size, err := diskUsage.GetSize(f)
+	if err != nil {
+		return nil, err
+	}
+	sizeOnDisk, err := diskUsage.GetSizeOnDisk(f)
+	if err != nil {
+		return nil, err
+	}
+	root := &types.Node{
+		Name:             f.Name(),
+		Mode:             f.Mode(),
+		Size:             size,
+		SizeOnDisk:       sizeOnDisk,
+		IsDir:            f.IsDir(),
+		LastModification: f.ModTime(),
+	}
+	opts.Progress.addEntry(sizeOnDisk)
+	if root.IsDir {
+		opts.Progress.enterDir(path)
+		names, err := readDirNames(path)
+		if err != nil {
+			log.Warningf("%s: %v", path, err)
+			opts.Progress.addError()
+			return root, nil
+		}
+
+		eg, egCtx := errgroup.WithContext(ctx)
+		rl := &sync.Mutex{}
+
+		for _, name := range names {
+			var curr = name
+			eg.Go(func() error {
+				child, err := legacyWalk(egCtx, path+"/"+curr, opts)
+				if err != nil {
+					return err
+				}
+				if child == nil {
+					return nil
+				}
+				child.Parent = root
+				rl.Lock()
+				defer rl.Unlock()
+				root.Size += child.Size
+				root.SizeOnDisk += child.SizeOnDisk
+				threshOK := child.Size >= opts.SizeThreshold
+				ignoreOK := true
+				if opts.IgnoreChecker != nil && opts.IgnoreChecker.ShouldIgnore(child.Name, child.IsDir) {
+					ignoreOK = false
+				}
+				if !ignoreOK {
+					log.Debugf("ignore: %s", path+"/"+child.Name)
+				}
+				if threshOK && ignoreOK {
+					root.Children = append(root.Children, child)
+				}
+				return nil
+			})
+		}
+
+		if err := eg.Wait(); err != nil {
+			return nil, err
+		}
+
+		sort.Slice(root.Children, func(i, j int) bool {
+			return strings.Compare(root.Children[i].Name, root.Children[j].Name) == -1
+		})
+	}
+	return root, nil
+}
+
+func readDirNames(dirname string) ([]string, error) {
+	f, err := os.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
@@ -0,0 +1,334 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"go.sazak.io/gls/internal/types"
+)
+
+// syntheticTree describes a generated benchmark tree: every directory down
+// to depth holds fanout subdirectories and files regular files.
+type syntheticTree struct {
+	name   string
+	depth  int
+	fanout int
+	files  int
+}
+
+var (
+	syntheticTrees = []syntheticTree{
+		{name: "wide", depth: 1, fanout: 4, files: 5000},
+		{name: "deep", depth: 12, fanout: 1, files: 50},
+		{name: "balanced", depth: 5, fanout: 4, files: 8},
+	}
+
+	syntheticTreeDir  string
+	syntheticTreeMu   sync.Mutex
+	syntheticTreeMade = make(map[string]bool)
+)
+
+func makeSyntheticTree(dir string, t syntheticTree) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for i := 0; i < t.files; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%d", i)), make([]byte, i%4096), 0o644); err != nil {
+			return err
+		}
+	}
+	if t.depth == 0 {
+		return nil
+	}
+	for i := 0; i < t.fanout; i++ {
+		sub := t
+		sub.depth--
+		if err := makeSyntheticTree(filepath.Join(dir, fmt.Sprintf("dir%d", i)), sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syntheticTreePath returns the root of the synthetic tree with the given
+// name, generating it on first use. Trees are shared by all tests and
+// benchmarks of a test binary run.
+func syntheticTreePath(tb testing.TB, name string) string {
+	syntheticTreeMu.Lock()
+	defer syntheticTreeMu.Unlock()
+	if syntheticTreeDir == "" {
+		dir, err := os.MkdirTemp("", "gls-bench-")
+		if err != nil {
+			tb.Fatalf("could not create synthetic tree directory: %v", err)
+		}
+		syntheticTreeDir = dir
+	}
+	path := filepath.Join(syntheticTreeDir, name)
+	if syntheticTreeMade[name] {
+		return path
+	}
+	for _, t := range syntheticTrees {
+		if t.name != name {
+			continue
+		}
+		if err := makeSyntheticTree(path, t); err != nil {
+			tb.Fatalf("could not create synthetic tree %q: %v", name, err)
+		}
+		syntheticTreeMade[name] = true
+		return path
+	}
+	tb.Fatalf("unknown synthetic tree %q", name)
+	return ""
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if syntheticTreeDir != "" {
+		os.RemoveAll(syntheticTreeDir)
+	}
+	os.Exit(code)
+}
+
+// peakSampler records the peak heap usage and goroutine count while a
+// benchmark runs.
+type peakSampler struct {
+	stop       chan struct{}
+	done       chan struct{}
+	heap       uint64
+	goroutines int
+}
+
+func startPeakSampler() *peakSampler {
+	s := &peakSampler{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		var ms runtime.MemStats
+		for {
+			runtime.ReadMemStats(&ms)
+			if ms.HeapInuse > s.heap {
+				s.heap = ms.HeapInuse
+			}
+			if g := runtime.NumGoroutine(); g > s.goroutines {
+				s.goroutines = g
+			}
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return s
+}
+
+func (s *peakSampler) report(b *testing.B) {
+	close(s.stop)
+	<-s.done
+	b.ReportMetric(float64(s.heap)/(1<<20), "peak-heap-MB")
+	b.ReportMetric(float64(s.goroutines), "peak-goroutines")
+}
+
+type walkFunc func(context.Context, string, *WalkOptions) (*types.Node, error)
+
+func benchmarkWalk(b *testing.B, path string, walk walkFunc, opts *WalkOptions) {
+	runtime.GC()
+	sampler := startPeakSampler()
+	b.ReportAllocs()
+	b.ResetTimer()
+	var entries int
+	for i := 0; i < b.N; i++ {
+		root, err := walk(context.Background(), path, opts)
+		if err != nil {
+			b.Fatalf("error walking %s: %v", path, err)
+		}
+		entries = root.FileCount()
+	}
+	b.StopTimer()
+	sampler.report(b)
+	b.ReportMetric(float64(entries)*float64(b.N)/b.Elapsed().Seconds(), "files/s")
+}
+
+func BenchmarkWalk(b *testing.B) {
+	for _, t := range syntheticTrees {
+		path := syntheticTreePath(b, t.name)
+		b.Run(t.name+"/unbounded", func(b *testing.B) {
+			benchmarkWalk(b, path, legacyWalk, &WalkOptions{})
+		})
+		for _, jobs := range []int{1, 4, 16, 64} {
+			b.Run(fmt.Sprintf("%s/jobs=%d", t.name, jobs), func(b *testing.B) {
+				benchmarkWalk(b, path, Walk, &WalkOptions{Jobs: jobs})
+			})
+		}
+	}
+}
+
+func TestWalkMatchesLegacyWalk(t *testing.T) {
+	for _, name := range []string{"deep", "balanced"} {
+		path := syntheticTreePath(t, name)
+		want, err := legacyWalk(context.Background(), path, &WalkOptions{})
+		if err != nil {
+			t.Fatalf("legacyWalk(%s): %v", name, err)
+		}
+		for _, jobs := range []int{1, 3, 16} {
+			got, err := Walk(context.Background(), path, &WalkOptions{Jobs: jobs})
+			if err != nil {
+				t.Fatalf("Walk(%s, jobs=%d): %v", name, jobs, err)
+			}
+			if got.Size != want.Size || got.SizeOnDisk != want.SizeOnDisk || got.FileCount() != want.FileCount() {
+				t.Errorf("Walk(%s, jobs=%d) = (%d, %d, %d files), want (%d, %d, %d files)", name, jobs,
+					got.Size, got.SizeOnDisk, got.FileCount(), want.Size, want.SizeOnDisk, want.FileCount())
+			}
+		}
+	}
+}
+
+func TestWalkCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Walk(ctx, syntheticTreePath(t, "balanced"), &WalkOptions{}); err != context.Canceled {
+		t.Errorf("Walk with cancelled context returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestWalkCountsHardLinksOnce(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 64*1024)
+	if err := os.WriteFile(filepath.Join(dir, "a"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []string{"b", "sub/c"} {
+		if err := os.Link(filepath.Join(dir, "a"), filepath.Join(dir, l)); err != nil {
+			t.Skipf("hard links not supported: %v", err)
+		}
+	}
+	root, err := Walk(context.Background(), dir, &WalkOptions{})
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	duplicates := 0
+	var fileSize int64
+	var visit func(n *types.Node)
+	visit = func(n *types.Node) {
+		if n.IsDir {
+			for _, c := range n.Children {
+				visit(c)
+			}
+			return
+		}
+		fileSize = n.Size
+		if n.Links != 3 {
+			t.Errorf("%s: Links = %d, want 3", n.Name, n.Links)
+		}
+		if n.DuplicateLink {
+			duplicates++
+		}
+	}
+	visit(root)
+	if duplicates != 2 {
+		t.Errorf("got %d duplicate links, want 2", duplicates)
+	}
+	if got, want := root.ApparentSize-root.Size, 2*fileSize; got != want {
+		t.Errorf("apparent size exceeds unique size by %d, want %d", got, want)
+	}
+}
+
+func TestWalkSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "real"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "real", "file"), make([]byte, 8192), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"real/loop": "..",
+		"linked":    "real",
+		"broken":    "does-not-exist",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Skipf("symbolic links not supported: %v", err)
+		}
+	}
+
+	for _, follow := range []bool{false, true} {
+		root, err := Walk(context.Background(), dir, &WalkOptions{FollowSymlinks: follow})
+		if err != nil {
+			t.Fatalf("Walk(follow=%t): %v", follow, err)
+		}
+		byName := make(map[string]*types.Node)
+		for _, c := range root.Children {
+			byName[c.Name] = c
+		}
+		broken := byName["broken"]
+		if broken == nil || !broken.BrokenLink || broken.DisplayName() != "broken -> does-not-exist (broken)" {
+			t.Errorf("follow=%t: broken link node = %+v", follow, broken)
+		}
+		linked := byName["linked"]
+		if linked == nil || linked.LinkTarget != "real" || linked.BrokenLink {
+			t.Fatalf("follow=%t: linked node = %+v", follow, linked)
+		}
+		if linked.IsDir != follow {
+			t.Errorf("follow=%t: linked.IsDir = %t", follow, linked.IsDir)
+		}
+		if follow && len(linked.Children) != 2 {
+			t.Errorf("follow=%t: linked has %d children, want 2", follow, len(linked.Children))
+		}
+		if !follow {
+			continue
+		}
+		// Whichever way the linked directory is reached first, it is only
+		// counted once.
+		real := byName["real"]
+		if real == nil {
+			t.Fatalf("follow=%t: real directory is missing", follow)
+		}
+		if real.DuplicateLink == linked.DuplicateLink {
+			t.Errorf("real.DuplicateLink = %t, linked.DuplicateLink = %t, want exactly one set", real.DuplicateLink, linked.DuplicateLink)
+		}
+	}
+}
+
+func TestWalkScanErrors(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	dir := t.TempDir()
+	private := filepath.Join(dir, "private")
+	if err := os.Mkdir(private, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(private, "file"), make([]byte, 64*1024), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(private, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(private, 0o755)
+
+	b := NewFileTreeBuilder(dir, WithSizeThreshold(1<<30))
+	if err := b.Build(); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	errs := b.ScanErrors()
+	if len(errs) != 1 || errs[0].Path != private || errs[0].Node.Name != "private" {
+		t.Fatalf("ScanErrors() = %v, want an error for %s", errs, private)
+	}
+	if errs[0].Node.Parent != b.Root() {
+		t.Errorf("the failed directory is not kept in the tree despite the size threshold")
+	}
+}
@@ -0,0 +1,465 @@
+// Package journal records the operations done on files, so that they can be
+// undone and redone later. Every change to the journal is appended to its
+// file as it happens, so a crash loses none of it.
+package journal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.sazak.io/gls/internal/cp"
+	"go.sazak.io/gls/internal/trash"
+)
+
+// Kind is the kind of an operation.
+type Kind string
+
+const (
+	// Create made the empty file Path.
+	Create Kind = "create"
+	// Copy copied Src to Path.
+	Copy Kind = "copy"
+	// Move moved Src to Path.
+	Move Kind = "move"
+	// Trash moved Path to the trash.
+	Trash Kind = "trash"
+	// Restore moved Path back from the trash.
+	Restore Kind = "restore"
+	// Remove deleted Path for good, which cannot be undone.
+	Remove Kind = "remove"
+)
+
+var (
+	ErrNothingToUndo = errors.New("nothing to undo")
+	ErrNothingToRedo = errors.New("nothing to redo")
+)
+
+// Op is an operation in the journal.
+type Op struct {
+	ID   int       `json:"id"`
+	Kind Kind      `json:"kind"`
+	Time time.Time `json:"time"`
+	// Src is the path a copy or move read from, and Path the path the
+	// operation made, changed or removed. Both are absolute.
+	Src  string `json:"src,omitempty"`
+	Path string `json:"path"`
+	// TrashDir and TrashName locate the entry in the trash of a trash or
+	// restore operation.
+	TrashDir  string `json:"trash_dir,omitempty"`
+	TrashName string `json:"trash_name,omitempty"`
+	// Merged is set for a copy onto or into what was at Path before, which
+	// undoing would throw away too.
+	Merged bool `json:"merged,omitempty"`
+	// Undone is set while the operation is undone.
+	Undone bool `json:"undone,omitempty"`
+	// Failed is why undoing or redoing the operation failed, after which it
+	// is skipped like a removal.
+	Failed string `json:"failed,omitempty"`
+}
+
+// Created returns the operation of creating the file at path.
+func Created(path string) Op {
+	return Op{Kind: Create, Path: path}
+}
+
+// Copied returns the operation of copying src to dst.
+func Copied(src, dst string) Op {
+	return Op{Kind: Copy, Src: src, Path: dst}
+}
+
+// Moved returns the operation of moving src to dst.
+func Moved(src, dst string) Op {
+	return Op{Kind: Move, Src: src, Path: dst}
+}
+
+// Trashed returns the operation of moving path to the trash entry e.
+func Trashed(path string, e *trash.Entry) Op {
+	return Op{Kind: Trash, Path: path, TrashDir: e.Dir, TrashName: e.Name}
+}
+
+// Restored returns the operation of restoring the trash entry e.
+func Restored(e *trash.Entry) Op {
+	return Op{Kind: Restore, Path: e.Path, TrashDir: e.Dir, TrashName: e.Name}
+}
+
+// Removed returns the operation of deleting path for good.
+func Removed(path string) Op {
+	return Op{Kind: Remove, Path: path}
+}
+
+// Reversible reports whether the operation can be undone and redone.
+func (op *Op) Reversible() bool {
+	return op.Kind != Remove && !op.Merged && op.Failed == ""
+}
+
+// Paths returns the paths the operation touches, to bring views of them up
+// to date after undoing or redoing it.
+func (op *Op) Paths() []string {
+	var paths []string
+	if op.Src != "" {
+		paths = append(paths, op.Src)
+	}
+	paths = append(paths, op.Path)
+	if e := op.trashEntry(); e != nil {
+		paths = append(paths, e.File(), e.Info())
+	}
+	return paths
+}
+
+func (op *Op) trashEntry() *trash.Entry {
+	if op.TrashName == "" {
+		return nil
+	}
+	return &trash.Entry{Name: op.TrashName, Path: op.Path, Dir: op.TrashDir}
+}
+
+// String describes the operation.
+func (op *Op) String() string {
+	switch op.Kind {
+	case Create:
+		return fmt.Sprintf("create %s", op.Path)
+	case Copy:
+		return fmt.Sprintf("copy %s to %s", op.Src, op.Path)
+	case Move:
+		return fmt.Sprintf("move %s to %s", op.Src, op.Path)
+	case Trash:
+		return fmt.Sprintf("move %s to the trash", op.Path)
+	case Restore:
+		return fmt.Sprintf("restore %s from the trash", op.Path)
+	case Remove:
+		return fmt.Sprintf("delete %s for good", op.Path)
+	}
+	return fmt.Sprintf("%s %s", op.Kind, op.Path)
+}
+
+// event is a line of the journal file.
+type event struct {
+	Event string `json:"event"`
+	ID    int    `json:"id,omitempty"`
+	Op    *Op    `json:"op,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+const (
+	eventDo   = "do"
+	eventUndo = "undo"
+	eventRedo = "redo"
+	eventFail = "fail"
+)
+
+// Journal is a list of operations, the oldest first. Operations recorded
+// after some were undone replace those, which cannot be redone anymore. It
+// is safe for concurrent use.
+type Journal struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	ops  []*Op
+	// lastID is the highest id given to an operation so far.
+	lastID int
+	// can is the trash that things made by the operations are moved to when
+	// they are undone, and that trashed files are restored from. Without
+	// one they are deleted, and trashing cannot be undone.
+	can *trash.Can
+}
+
+// DefaultDir returns the directory journals are kept in by default,
+// $XDG_STATE_HOME/gls/journal.
+func DefaultDir() (string, error) {
+	state := os.Getenv("XDG_STATE_HOME")
+	if state == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		state = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(state, "gls", "journal"), nil
+}
+
+// SessionName returns the name of the journal file of a session started at
+// t.
+func SessionName(t time.Time) string {
+	return fmt.Sprintf("%s-%d.jsonl", t.Format("20060102-150405"), os.Getpid())
+}
+
+// Open returns the journal kept in the file at path, reading the
+// operations already in it. The file and its directory are only created
+// once an operation is recorded. can is the trash used to undo operations,
+// and may be nil.
+func Open(path string, can *trash.Can) (*Journal, error) {
+	j := &Journal{path: path, can: can}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var ev event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			// A line cut short by a crash.
+			continue
+		}
+		j.apply(ev)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Path returns the path of the journal file.
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+// Ops returns copies of the operations in the journal, the most recent
+// first.
+func (j *Journal) Ops() []Op {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	ops := make([]Op, 0, len(j.ops))
+	for i := len(j.ops) - 1; i >= 0; i-- {
+		ops = append(ops, *j.ops[i])
+	}
+	return ops
+}
+
+// Record adds the operation op, which was just done, to the journal, and
+// returns it with its id and time set. Paths are made absolute.
+func (j *Journal) Record(op Op) (Op, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var err error
+	if op.Path, err = filepath.Abs(op.Path); err != nil {
+		return op, err
+	}
+	if op.Src != "" {
+		if op.Src, err = filepath.Abs(op.Src); err != nil {
+			return op, err
+		}
+	}
+	op.ID = j.lastID + 1
+	op.Time = time.Now()
+	op.Undone, op.Failed = false, ""
+	ev := event{Event: eventDo, Op: &op}
+	if err := j.write(ev); err != nil {
+		return op, err
+	}
+	j.apply(ev)
+	return op, nil
+}
+
+// Undo undoes the most recent operation that is done and reversible, and
+// returns it with the paths it touched.
+func (j *Journal) Undo() (Op, []string, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var op *Op
+	for i := len(j.ops) - 1; i >= 0; i-- {
+		if !j.ops[i].Undone && j.ops[i].Reversible() {
+			op = j.ops[i]
+			break
+		}
+	}
+	if op == nil {
+		return Op{}, nil, ErrNothingToUndo
+	}
+	undone := *op
+	if err := j.undo(&undone); err != nil {
+		return *op, op.Paths(), j.fail(op, fmt.Errorf("could not undo %s: %v", op, err))
+	}
+	undone.Undone = true
+	paths := append(op.Paths(), undone.Paths()...)
+	*op = undone
+	return undone, paths, j.write(event{Event: eventUndo, ID: op.ID, Op: &undone})
+}
+
+// Redo redoes the oldest operation that is undone and reversible, and
+// returns it with the paths it touched.
+func (j *Journal) Redo() (Op, []string, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var op *Op
+	for _, o := range j.ops {
+		if o.Undone && o.Reversible() {
+			op = o
+			break
+		}
+	}
+	if op == nil {
+		return Op{}, nil, ErrNothingToRedo
+	}
+	redone := *op
+	if err := j.redo(&redone); err != nil {
+		return *op, op.Paths(), j.fail(op, fmt.Errorf("could not redo %s: %v", op, err))
+	}
+	redone.Undone = false
+	paths := append(op.Paths(), redone.Paths()...)
+	*op = redone
+	return redone, paths, j.write(event{Event: eventRedo, ID: op.ID, Op: &redone})
+}
+
+// fail records that op could not be undone or redone, with err, so that it
+// is skipped from now on, and returns err.
+func (j *Journal) fail(op *Op, err error) error {
+	op.Failed = err.Error()
+	if writeErr := j.write(event{Event: eventFail, ID: op.ID, Error: op.Failed}); writeErr != nil {
+		return fmt.Errorf("%v, and could not record it: %v", err, writeErr)
+	}
+	return err
+}
+
+// apply applies the event ev, read from the journal file or just written to
+// it, to the operations.
+func (j *Journal) apply(ev event) {
+	if ev.Event == eventDo {
+		if ev.Op == nil {
+			return
+		}
+		// A new operation replaces the undone ones.
+		ops := j.ops[:0]
+		for _, op := range j.ops {
+			if !op.Undone {
+				ops = append(ops, op)
+			}
+		}
+		op := *ev.Op
+		j.ops = append(ops, &op)
+		if op.ID > j.lastID {
+			j.lastID = op.ID
+		}
+		return
+	}
+	for _, op := range j.ops {
+		if op.ID != ev.ID {
+			continue
+		}
+		switch ev.Event {
+		case eventUndo, eventRedo:
+			if ev.Op != nil {
+				*op = *ev.Op
+			}
+		case eventFail:
+			op.Failed = ev.Error
+		}
+		return
+	}
+}
+
+// write appends ev to the journal file, creating it if needed.
+func (j *Journal) write(ev event) error {
+	if j.f == nil {
+		if err := os.MkdirAll(filepath.Dir(j.path), 0o700); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return err
+		}
+		j.f = f
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// undo undoes op, updating where its things went.
+func (j *Journal) undo(op *Op) error {
+	switch op.Kind {
+	case Create, Copy:
+		return j.discard(op.Path)
+	case Move:
+		return cp.Move(op.Src, op.Path)
+	case Trash:
+		if j.can == nil {
+			return errors.New("no trash to restore from")
+		}
+		return j.can.Restore(op.trashEntry())
+	case Restore:
+		return j.trash(op)
+	}
+	return fmt.Errorf("cannot undo %s", op.Kind)
+}
+
+// redo does op again, updating where its things went.
+func (j *Journal) redo(op *Op) error {
+	switch op.Kind {
+	case Create:
+		f, err := os.OpenFile(op.Path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	case Copy:
+		if _, err := os.Lstat(op.Path); err == nil {
+			return fmt.Errorf("%s already exists", op.Path)
+		}
+		return cp.Tree(context.Background(), op.Path, op.Src)
+	case Move:
+		return cp.Move(op.Path, op.Src)
+	case Trash:
+		return j.trash(op)
+	case Restore:
+		if j.can == nil {
+			return errors.New("no trash to restore from")
+		}
+		return j.can.Restore(op.trashEntry())
+	}
+	return fmt.Errorf("cannot redo %s", op.Kind)
+}
+
+// trash moves op.Path to the trash, recording the entry in op.
+func (j *Journal) trash(op *Op) error {
+	if j.can == nil {
+		return errors.New("no trash to move to")
+	}
+	e, err := j.can.Put(op.Path)
+	if err != nil {
+		return err
+	}
+	op.TrashDir, op.TrashName = e.Dir, e.Name
+	return nil
+}
+
+// discard gets rid of path, which an operation made, moving it to the trash
+// if there is one.
+func (j *Journal) discard(path string) error {
+	if _, err := os.Lstat(path); err != nil {
+		return err
+	}
+	if j.can != nil {
+		_, err := j.can.Put(path)
+		return err
+	}
+	return os.RemoveAll(path)
+}
@@ -0,0 +1,227 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.sazak.io/gls/internal/mount"
+	"go.sazak.io/gls/internal/trash"
+)
+
+var testStart = time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+
+func newTestJournal(t *testing.T) (*Journal, *trash.Can) {
+	t.Helper()
+	can, err := trash.New(trash.WithHome(filepath.Join(t.TempDir(), "Trash")), trash.WithMounts(mount.Table{}))
+	if err != nil {
+		t.Skipf("no trash: %v", err)
+	}
+	j, err := Open(filepath.Join(t.TempDir(), "state", SessionName(testStart)), can)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j, can
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func record(t *testing.T, j *Journal, op Op) Op {
+	t.Helper()
+	op, err := j.Record(op)
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	return op
+}
+
+func undo(t *testing.T, j *Journal) Op {
+	t.Helper()
+	op, _, err := j.Undo()
+	if err != nil {
+		t.Fatalf("Undo: %v", err)
+	}
+	return op
+}
+
+func redo(t *testing.T, j *Journal) Op {
+	t.Helper()
+	op, _, err := j.Redo()
+	if err != nil {
+		t.Fatalf("Redo: %v", err)
+	}
+	return op
+}
+
+func TestUndoRedo(t *testing.T) {
+	j, can := newTestJournal(t)
+	dir := t.TempDir()
+	created := filepath.Join(dir, "created")
+	src, copied := filepath.Join(dir, "src"), filepath.Join(dir, "copied")
+	moved := filepath.Join(dir, "sub", "moved")
+	trashed := filepath.Join(dir, "trashed")
+	writeFile(t, created, "")
+	writeFile(t, filepath.Join(src, "x"), "x")
+	writeFile(t, filepath.Join(dir, "copied", "x"), "x")
+	writeFile(t, moved, "m")
+	writeFile(t, trashed, "t")
+
+	record(t, j, Created(created))
+	record(t, j, Copied(src, copied))
+	record(t, j, Moved(filepath.Join(dir, "before"), moved))
+	e, err := can.Put(trashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record(t, j, Trashed(trashed, e))
+	record(t, j, Removed(filepath.Join(dir, "gone")))
+
+	// The removal is skipped, and the rest is undone the most recent first.
+	assert.Equal(t, Trash, undo(t, j).Kind)
+	assert.True(t, exists(trashed))
+	assert.Equal(t, Move, undo(t, j).Kind)
+	assert.True(t, exists(filepath.Join(dir, "before")))
+	assert.False(t, exists(moved))
+	assert.Equal(t, Copy, undo(t, j).Kind)
+	assert.False(t, exists(copied))
+	assert.True(t, exists(src))
+	assert.Equal(t, Create, undo(t, j).Kind)
+	assert.False(t, exists(created))
+	if _, _, err := j.Undo(); err != ErrNothingToUndo {
+		t.Errorf("Undo = %v, want %v", err, ErrNothingToUndo)
+	}
+	// What undoing created and copied is in the trash.
+	entries, err := can.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, entries, 2)
+
+	// Redo goes the other way round.
+	assert.Equal(t, Create, redo(t, j).Kind)
+	assert.True(t, exists(created))
+	assert.Equal(t, Copy, redo(t, j).Kind)
+	assert.True(t, exists(filepath.Join(copied, "x")))
+	assert.Equal(t, Move, redo(t, j).Kind)
+	assert.True(t, exists(moved))
+	op := redo(t, j)
+	assert.Equal(t, Trash, op.Kind)
+	assert.False(t, exists(trashed))
+	assert.True(t, exists(filepath.Join(op.TrashDir, "files", op.TrashName)))
+	if _, _, err := j.Redo(); err != ErrNothingToRedo {
+		t.Errorf("Redo = %v, want %v", err, ErrNothingToRedo)
+	}
+
+	// Undoing the trashing again restores the entry it went to on redo.
+	undo(t, j)
+	assert.True(t, exists(trashed))
+}
+
+func TestRecordDropsUndone(t *testing.T) {
+	j, _ := newTestJournal(t)
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	writeFile(t, a, "")
+	first := record(t, j, Created(a))
+	undo(t, j)
+	writeFile(t, b, "")
+	second := record(t, j, Created(b))
+	assert.Greater(t, second.ID, first.ID)
+
+	ops := j.Ops()
+	if assert.Len(t, ops, 1) {
+		assert.Equal(t, b, ops[0].Path)
+	}
+	if _, _, err := j.Redo(); err != ErrNothingToRedo {
+		t.Errorf("Redo = %v, want %v", err, ErrNothingToRedo)
+	}
+}
+
+func TestUndoFailure(t *testing.T) {
+	j, _ := newTestJournal(t)
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	writeFile(t, a, "")
+	record(t, j, Created(a))
+	record(t, j, Moved(filepath.Join(dir, "src"), b))
+
+	// b is not there to move back, so the move is skipped from now on.
+	if _, _, err := j.Undo(); err == nil {
+		t.Fatalf("Undo: wanted an error for a missing file")
+	}
+	ops := j.Ops()
+	assert.NotEmpty(t, ops[0].Failed)
+	assert.False(t, ops[0].Reversible())
+	assert.Equal(t, Create, undo(t, j).Kind)
+}
+
+func TestOpenReplays(t *testing.T) {
+	j, can := newTestJournal(t)
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	writeFile(t, a, "a")
+	record(t, j, Created(filepath.Join(dir, "c")))
+	record(t, j, Moved(b, a))
+	undo(t, j)
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// A line cut short by a crash is left out.
+	f, err := os.OpenFile(j.Path(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"event":"do","op":{"id":9,`)
+	f.Close()
+
+	reopened, err := Open(j.Path(), can)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer reopened.Close()
+	want, got := j.Ops(), reopened.Ops()
+	if assert.Len(t, got, len(want)) {
+		for i := range want {
+			assert.True(t, want[i].Time.Equal(got[i].Time), "time of op %d", want[i].ID)
+			want[i].Time, got[i].Time = time.Time{}, time.Time{}
+		}
+		assert.Equal(t, want, got)
+	}
+	assert.Equal(t, Move, redo(t, reopened).Kind)
+	assert.True(t, exists(a))
+	assert.Equal(t, 3, record(t, reopened, Created(b)).ID)
+}
+
+func TestMergedCopyIsKept(t *testing.T) {
+	j, _ := newTestJournal(t)
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "dst")
+	writeFile(t, filepath.Join(dst, "x"), "x")
+	op := Copied(filepath.Join(dir, "src"), dst)
+	op.Merged = true
+	record(t, j, op)
+
+	// Undoing would throw away what was at dst before the copy.
+	assert.False(t, j.Ops()[0].Reversible())
+	if _, _, err := j.Undo(); err != ErrNothingToUndo {
+		t.Errorf("Undo = %v, want %v", err, ErrNothingToUndo)
+	}
+	assert.True(t, exists(filepath.Join(dst, "x")))
+}
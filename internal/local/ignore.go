@@ -7,46 +7,91 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 const (
-	defaultIgnoreFile        = ".glsignore"
+	// DefaultIgnoreFile is the name of the ignore files of gls, read from
+	// the working directory and, during a scan, from the scanned folders.
+	DefaultIgnoreFile = ".glsignore"
+	// GitIgnoreFile is the name of the ignore files of git.
+	GitIgnoreFile = ".gitignore"
+
 	regexDirectoryRulePrefix = "re:dir:"
 	regexFileRulePrefix      = "re:"
+	// regexPathPrefix follows the prefixes of regexes that are matched
+	// against the relative path instead of the name.
+	regexPathPrefix = "path:"
 )
 
 var (
-	replacements = []struct {
-		from string
-		to   string
-	}{
-		// NOTE: The replacement ordering is VERY IMPORTANT!
-		{from: `\`, to: `\\`},
-		{from: `.`, to: `\.`},
-		{from: `*`, to: `.*`},
-	}
+	// neverMatch is the regex of patterns git considers malformed, which
+	// match nothing.
+	neverMatch = regexp.MustCompile(`[^\x00-\x{10FFFF}]`)
 )
 
-type ignoreRule struct {
+// Rule is a line of an ignore file. Rules follow the gitignore syntax,
+// except for lines with the re: and re:dir: prefixes, which are regular
+// expressions matched against the name of files and directories,
+// respectively, or against their relative path with re:path: and
+// re:dir:path:.
+type Rule struct {
+	// File is the ignore file the rule was read from, Line its line number
+	// in the file, counting from 1, and Pattern the line itself.
+	File    string
+	Line    int
+	Pattern string
+
 	isDirRule bool
-	re        *regexp.Regexp
+	// isFileRule is set on re: rules, which only match files.
+	isFileRule bool
+	// onName is set on regex rules matched against the last element of the
+	// path.
+	onName bool
+	negate bool
+	re     *regexp.Regexp
+}
+
+func (r *Rule) matches(path string, isDir bool) bool {
+	if (r.isDirRule && !isDir) || (r.isFileRule && isDir) {
+		return false
+	}
+	if r.onName {
+		path = path[strings.LastIndexByte(path, '/')+1:]
+	}
+	return r.re.MatchString(path)
 }
 
 type IgnoreChecker struct {
-	ruleFiles map[string]struct{}
-	rules     []ignoreRule
+	// ruleFiles are the files the rules are read from, in order.
+	ruleFiles []string
+	// inline are the rules given by WithRules, added after those of the
+	// rule files.
+	inline []inlineRules
+	rules  []*Rule
+	// dir is the path of the directory the rules of a checker made by
+	// Scoped were found in, relative to the scan root. The rules only apply
+	// below it, and take precedence over the rules of parent.
+	dir    string
+	parent *IgnoreChecker
+}
+
+type inlineRules struct {
+	source   string
+	patterns []string
 }
 
 type IgnoreCheckerOption func(*IgnoreChecker)
 
 func NewIgnoreChecker(opts ...IgnoreCheckerOption) (*IgnoreChecker, error) {
 	c := &IgnoreChecker{
-		ruleFiles: make(map[string]struct{}),
+		ruleFiles: []string{DefaultIgnoreFile},
 		rules:     nil,
 	}
-	c.ruleFiles[defaultIgnoreFile] = struct{}{}
 	for _, o := range opts {
 		o(c)
 	}
@@ -56,17 +101,127 @@ func NewIgnoreChecker(opts ...IgnoreCheckerOption) (*IgnoreChecker, error) {
 	return c, nil
 }
 
+// WithRuleFile adds the rules of the ignore file at path. Rules of later
+// files take precedence over earlier ones, as later lines of a file do
+// over earlier lines.
 func WithRuleFile(path string) IgnoreCheckerOption {
 	return func(ic *IgnoreChecker) {
-		if _, ok := ic.ruleFiles[path]; !ok {
-			ic.ruleFiles[path] = struct{}{}
+		for _, f := range ic.ruleFiles {
+			if f == path {
+				return
+			}
 		}
+		ic.ruleFiles = append(ic.ruleFiles, path)
+	}
+}
+
+// WithRules adds the given rules, as if they were the lines of an ignore
+// file named source, for example rules given on the command line. They take
+// precedence over the rules of the ignore files.
+func WithRules(source string, patterns ...string) IgnoreCheckerOption {
+	return func(ic *IgnoreChecker) {
+		ic.inline = append(ic.inline, inlineRules{source: source, patterns: patterns})
+	}
+}
+
+// CompileRules returns a checker with only the given rules, as if they were
+// the lines of an ignore file named source. Unlike NewIgnoreChecker, it
+// reads no ignore files.
+func CompileRules(source string, patterns ...string) (*IgnoreChecker, error) {
+	ic := &IgnoreChecker{}
+	if err := ic.addRules(source, strings.NewReader(strings.Join(patterns, "\n"))); err != nil {
+		return nil, err
+	}
+	return ic, nil
+}
+
+// Scoped returns a checker with the rules of the ignore file at path, read
+// from r, on top of the rules of ic, which may be nil. dir is the directory
+// of the ignore file relative to the scan root. Like the rules of a
+// .gitignore file, the new rules match paths relative to dir, only apply
+// below it, and take precedence over the rules of ic.
+func (ic *IgnoreChecker) Scoped(dir, path string, r io.Reader) (*IgnoreChecker, error) {
+	scoped := &IgnoreChecker{
+		ruleFiles: []string{path},
+		dir:       dir,
+		parent:    ic,
+	}
+	if err := scoped.addRules(path, r); err != nil {
+		return nil, err
+	}
+	if len(scoped.rules) == 0 {
+		return ic, nil
 	}
+	return scoped, nil
 }
 
+// ShouldIgnore reports whether the file or directory at path, relative to
+// the root of the scan and separated by slashes, is ignored. The last rule
+// matching path decides, so negated rules can bring back what earlier rules
+// ignore. The parent directories of path are not checked: like git, a scan
+// is expected not to look into ignored directories at all.
 func (ic *IgnoreChecker) ShouldIgnore(path string, isDir bool) bool {
-	for _, r := range ic.rules {
-		if ((r.isDirRule && isDir) || (!r.isDirRule && !isDir)) && r.re.MatchString(path) {
+	_, ignored := ic.Match(path, isDir)
+	return ignored
+}
+
+// Match is like ShouldIgnore, but also returns the rule deciding whether
+// path is ignored, or nil if no rule matches it.
+func (ic *IgnoreChecker) Match(path string, isDir bool) (rule *Rule, ignored bool) {
+	for c := ic; c != nil; c = c.parent {
+		rel := path
+		if c.dir != "" {
+			if !strings.HasPrefix(path, c.dir+"/") {
+				continue
+			}
+			rel = path[len(c.dir)+1:]
+		}
+		for i := len(c.rules) - 1; i >= 0; i-- {
+			if c.rules[i].matches(rel, isDir) {
+				return c.rules[i], !c.rules[i].negate
+			}
+		}
+	}
+	return nil, false
+}
+
+// Rules returns the rules of the checker, including those of the checkers
+// it was scoped from, in the order they were read.
+func (ic *IgnoreChecker) Rules() []*Rule {
+	var chain []*IgnoreChecker
+	for c := ic; c != nil; c = c.parent {
+		chain = append(chain, c)
+	}
+	var rules []*Rule
+	for i := len(chain) - 1; i >= 0; i-- {
+		rules = append(rules, chain[i].rules...)
+	}
+	return rules
+}
+
+// HasRuleFile reports whether the rules of the ignore file at path are
+// part of the checker already, comparing absolute paths, so that the ignore
+// file of the working directory is not read again when it is found in the
+// scanned folders.
+func (ic *IgnoreChecker) HasRuleFile(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for c := ic; c != nil; c = c.parent {
+		for _, f := range c.ruleFiles {
+			if fAbs, err := filepath.Abs(f); err == nil && fAbs == abs {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasRules reports whether the checker can ignore anything at all.
+func (ic *IgnoreChecker) HasRules() bool {
+	for c := ic; c != nil; c = c.parent {
+		if len(c.rules) > 0 {
 			return true
 		}
 	}
@@ -76,65 +231,243 @@ func (ic *IgnoreChecker) ShouldIgnore(path string, isDir bool) bool {
 func (ic *IgnoreChecker) Dump() string {
 	s := ""
 	for i, r := range ic.rules {
-		s += fmt.Sprintf("rule #%d: '%s', isDirRule: %t\n", i, r.re.String(), r.isDirRule)
+		s += fmt.Sprintf("rule #%d: '%s' (%s:%d), regex: '%s', isDirRule: %t, negate: %t\n", i, r.Pattern, r.File, r.Line, r.re.String(), r.isDirRule, r.negate)
 	}
 	return s
 }
 
 func (ic *IgnoreChecker) generateRules() error {
-	ic.rules = make([]ignoreRule, 0)
-	for path := range ic.ruleFiles {
+	ic.rules = make([]*Rule, 0)
+	for _, path := range ic.ruleFiles {
 		b, err := ioutil.ReadFile(path)
 		if err != nil {
-			if path == defaultIgnoreFile && os.IsNotExist(err) {
+			if path == DefaultIgnoreFile && os.IsNotExist(err) {
 				// Default ignore file does not exist is not an error case
 				continue
 			}
 			return err
 		}
-		r := bufio.NewReader(bytes.NewReader(b))
-		for {
-			line, _, err := r.ReadLine()
-			if err != nil {
-				if err != io.EOF {
-					return fmt.Errorf("error while reading line from %q: %v", path, err)
-				}
-				break // io.EOF means we've read all of this file.
-			}
-			reStr, isDirRule := convertIgnorePatternToRegex(string(line))
-			re, err := regexp.Compile(reStr)
-			if err != nil {
-				return fmt.Errorf("error while compiling regex rule %q from ignore file %q: %v", reStr, path, err)
-			}
-			ic.rules = append(ic.rules, ignoreRule{
-				re:        re,
-				isDirRule: isDirRule,
-			})
+		if err := ic.addRules(path, bytes.NewReader(b)); err != nil {
+			return err
+		}
+	}
+	for _, in := range ic.inline {
+		if err := ic.addRules(in.source, strings.NewReader(strings.Join(in.patterns, "\n"))); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func convertIgnorePatternToRegex(pattern string) (s string, isDirRule bool) {
-	isDirRule = false
-	s = pattern
-	if strings.HasPrefix(s, regexDirectoryRulePrefix) {
-		isDirRule = true
-		s = strings.TrimPrefix(s, regexDirectoryRulePrefix)
-		return
+// addRules adds the rules read from r, which is the ignore file at path.
+func (ic *IgnoreChecker) addRules(path string, r io.Reader) error {
+	br := bufio.NewReader(r)
+	for lineNo := 1; ; lineNo++ {
+		line, _, err := br.ReadLine()
+		if err != nil {
+			if err != io.EOF {
+				return fmt.Errorf("error while reading line from %q: %v", path, err)
+			}
+			return nil // io.EOF means we've read all of this file.
+		}
+		rule, ok, err := parseIgnoreRule(string(line))
+		if err != nil {
+			return fmt.Errorf("error while compiling rule %q from ignore file %q: %v", line, path, err)
+		}
+		if ok {
+			rule.File, rule.Line = path, lineNo
+			ic.rules = append(ic.rules, rule)
+		}
 	}
-	if strings.HasPrefix(s, regexFileRulePrefix) {
-		isDirRule = false
-		s = strings.TrimPrefix(s, regexFileRulePrefix)
-		return
+}
+
+// parseIgnoreRule parses a line of an ignore file. It returns false for
+// blank lines and comments.
+func parseIgnoreRule(line string) (rule *Rule, ok bool, err error) {
+	rule = &Rule{Pattern: line}
+	line = trimTrailingSpaces(strings.TrimSuffix(line, "\r"))
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false, nil
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	reStr, isDirRule, isRegex, onName := convertIgnorePatternToRegex(line)
+	rule.isDirRule = isDirRule
+	rule.isFileRule = isRegex && !isDirRule
+	rule.onName = onName
+	if rule.re, err = regexp.Compile(reStr); err != nil {
+		if isRegex {
+			return rule, false, err
+		}
+		// Like invalid character classes, which git ignores.
+		rule.re = neverMatch
+	}
+	return rule, true, nil
+}
+
+// trimTrailingSpaces removes the spaces at the end of line, unless they are
+// escaped with a backslash.
+func trimTrailingSpaces(line string) string {
+	end := len(line)
+	for end > 0 && line[end-1] == ' ' {
+		end--
+	}
+	if end < len(line) && end > 0 && line[end-1] == '\\' {
+		// Keep the escaped space.
+		end++
 	}
-	for _, r := range replacements {
-		s = strings.ReplaceAll(s, r.from, r.to)
+	return line[:end]
+}
+
+// convertIgnorePatternToRegex converts a gitignore pattern, or a regex with
+// a re: or re:dir: prefix, to a regex matching the paths it applies to.
+// onName is set if the regex is matched against the name only, which it is
+// unless the prefix is followed by path:.
+func convertIgnorePatternToRegex(pattern string) (s string, isDirRule, isRegex, onName bool) {
+	for _, prefix := range []string{regexDirectoryRulePrefix, regexFileRulePrefix} {
+		if !strings.HasPrefix(pattern, prefix) {
+			continue
+		}
+		s = strings.TrimPrefix(pattern, prefix)
+		onName = !strings.HasPrefix(s, regexPathPrefix)
+		return strings.TrimPrefix(s, regexPathPrefix), prefix == regexDirectoryRulePrefix, true, onName
 	}
-	if s[len(s)-1] == '/' {
+	if strings.HasSuffix(pattern, "/") {
 		isDirRule = true
-		s = s[:len(s)-1]
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	// A pattern with a slash other than at its end is relative to the root,
+	// while one without matches a name at any depth.
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	s, ok := globToRegex(pattern)
+	if !ok {
+		return neverMatch.String(), isDirRule, false, false
+	}
+	if anchored {
+		return "^" + s + "$", isDirRule, false, false
+	}
+	return "^(?:.*/)?" + s + "$", isDirRule, false, false
+}
+
+// globToRegex converts a glob in the syntax of git's wildmatch, matching
+// paths separated by slashes, to a regex. Wildcards never match a slash,
+// except for ** as a whole path component. It returns false for globs that
+// end in an escaping backslash, which match nothing.
+func globToRegex(glob string) (string, bool) {
+	p := []rune(glob)
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; c {
+		case '\\':
+			if i+1 == len(p) {
+				return "", false
+			}
+			i++
+			b.WriteString(regexp.QuoteMeta(string(p[i])))
+		case '*':
+			j := i
+			for j < len(p) && p[j] == '*' {
+				j++
+			}
+			atStart := i == 0 || p[i-1] == '/'
+			atEnd := j == len(p) || p[j] == '/'
+			switch {
+			case j-i < 2 || !atStart || !atEnd:
+				// Any other run of stars is a single one.
+				b.WriteString(`[^/]*`)
+			case j == len(p):
+				// Everything below, or everything at all on its own.
+				b.WriteString(`.*`)
+			default:
+				// Zero or more directories.
+				b.WriteString(`(?:.*/)?`)
+				j++
+			}
+			i = j - 1
+		case '?':
+			b.WriteString(`[^/]`)
+		case '[':
+			class, n := bracketToRegex(p[i:])
+			if n == 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			b.WriteString(class)
+			i += n - 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String(), true
+}
+
+// bracketToRegex converts the bracket expression at the start of p to a
+// regex character class, and returns the number of runes it spans, or zero
+// if it is not terminated.
+func bracketToRegex(p []rune) (string, int) {
+	var b strings.Builder
+	b.WriteString("[")
+	i := 1
+	negate := i < len(p) && (p[i] == '!' || p[i] == '^')
+	if negate {
+		b.WriteString("^")
+		i++
+	}
+	// dash is set when the last rune written is a - that can start a range.
+	dash := false
+	for first := true; i < len(p); i, first = i+1, false {
+		c := p[i]
+		wasDash := dash
+		dash = false
+		switch {
+		case c == ']' && !first:
+			if negate {
+				// Negated classes never match a slash either. It goes last,
+				// since a - before it would make a range.
+				if wasDash {
+					s := b.String()
+					b.Reset()
+					b.WriteString(s[:len(s)-1] + `\-`)
+				}
+				b.WriteString(`/`)
+			}
+			b.WriteString("]")
+			return b.String(), i + 1
+		case c == '[' && i+1 < len(p) && p[i+1] == ':':
+			end := strings.Index(string(p[i+2:]), ":]")
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			name := []rune(string(p[i+2:])[:end])
+			// An unknown class name makes the regex invalid, and with it
+			// the pattern, as in git.
+			b.WriteString("[:" + string(name) + ":]")
+			i += 2 + len(name) + 1
+		case c == '\\' && i+1 < len(p):
+			i++
+			b.WriteString(escapeClassRune(p[i]))
+		case c == '\\' || c == '[' || c == ']' || c == '^':
+			b.WriteString(escapeClassRune(c))
+		case c == '/':
+			// Never matches, like the wildcards.
+		default:
+			// Including - for ranges.
+			b.WriteRune(c)
+			dash = c == '-'
+		}
+	}
+	return "", 0
+}
+
+// escapeClassRune returns c escaped for use as a literal in a regex
+// character class.
+func escapeClassRune(c rune) string {
+	if c < utf8.RuneSelf && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+		return `\` + string(c)
 	}
-	s += "$"
-	return
+	return string(c)
 }
@@ -1,6 +1,8 @@
 package local
 
 import (
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -10,103 +12,520 @@ func TestConvertIgnorePatternToRegex(t *testing.T) {
 		str       string
 		wantRe    string
 		wantIsDir bool
+		// wantIsRegex is set on the cases with the re: and re:dir: prefixes,
+		// and wantOnPath on those matched against the path.
+		wantIsRegex bool
+		wantOnPath  bool
 	}{
 		{
 			desc:      "simple file path",
 			str:       "simple/file/path",
-			wantRe:    `simple/file/path$`,
+			wantRe:    `^simple/file/path$`,
 			wantIsDir: false,
 		},
 		{
 			desc:      "simple directory path",
 			str:       "simple/dir/path/",
-			wantRe:    `simple/dir/path$`,
+			wantRe:    `^simple/dir/path$`,
 			wantIsDir: true,
 		},
 		{
 			desc:      "simpe file path with dot",
 			str:       "simple/file/path/with.dot",
-			wantRe:    `simple/file/path/with\.dot$`,
+			wantRe:    `^simple/file/path/with\.dot$`,
 			wantIsDir: false,
 		},
 		{
 			desc:      "simple directory path with dot",
 			str:       "simple/dir/path/with/.dot/",
-			wantRe:    `simple/dir/path/with/\.dot$`,
+			wantRe:    `^simple/dir/path/with/\.dot$`,
 			wantIsDir: true,
 		},
 		{
 			desc:      "only file name with wildcard",
 			str:       "*.mp4",
-			wantRe:    `.*\.mp4$`,
+			wantRe:    `^(?:.*/)?[^/]*\.mp4$`,
 			wantIsDir: false,
 		},
 		{
 			desc:      "directory path with special characters",
 			str:       `~/too-secret\ directory/.dir/`,
-			wantRe:    `~/too-secret\\ directory/\.dir$`,
+			wantRe:    `^~/too-secret directory/\.dir$`,
 			wantIsDir: true,
 		},
 		{
 			desc:      "directory path with special characters and wildcard",
 			str:       "~/too-secret/.dir_*/",
-			wantRe:    `~/too-secret/\.dir_.*$`,
+			wantRe:    `^~/too-secret/\.dir_[^/]*$`,
 			wantIsDir: true,
 		},
 		/// With re: and re:dir: prefixes
 		{
-			desc:      "prefixed simple file path",
-			str:       `re:simple/file/path$`,
-			wantRe:    `simple/file/path$`,
-			wantIsDir: false,
+			desc:        "prefixed simple file path",
+			str:         `re:simple/file/path$`,
+			wantRe:      `simple/file/path$`,
+			wantIsDir:   false,
+			wantIsRegex: true,
 		},
 		{
-			desc:      "prefixed simple directory path",
-			str:       `re:dir:simple/dir/path$`,
-			wantRe:    `simple/dir/path$`,
-			wantIsDir: true,
+			desc:        "prefixed simple directory path",
+			str:         `re:dir:simple/dir/path$`,
+			wantRe:      `simple/dir/path$`,
+			wantIsDir:   true,
+			wantIsRegex: true,
 		},
 		{
-			desc:      "prefixed simpe file path with dot",
-			str:       `re:simple/file/path/with\.dot$`,
-			wantRe:    `simple/file/path/with\.dot$`,
-			wantIsDir: false,
+			desc:        "prefixed simpe file path with dot",
+			str:         `re:simple/file/path/with\.dot$`,
+			wantRe:      `simple/file/path/with\.dot$`,
+			wantIsDir:   false,
+			wantIsRegex: true,
 		},
 		{
-			desc:      "prefixed simple directory path with dot",
-			str:       `re:dir:simple/dir/path/with/\.dot$`,
-			wantRe:    `simple/dir/path/with/\.dot$`,
-			wantIsDir: true,
+			desc:        "prefixed simple directory path with dot",
+			str:         `re:dir:simple/dir/path/with/\.dot$`,
+			wantRe:      `simple/dir/path/with/\.dot$`,
+			wantIsDir:   true,
+			wantIsRegex: true,
 		},
 		{
-			desc:      "prefixed only file name with wildcard",
-			str:       `re:^.*\.mp4$`,
-			wantRe:    `^.*\.mp4$`,
-			wantIsDir: false,
+			desc:        "prefixed only file name with wildcard",
+			str:         `re:^.*\.mp4$`,
+			wantRe:      `^.*\.mp4$`,
+			wantIsDir:   false,
+			wantIsRegex: true,
 		},
 		{
-			desc:      "prefixed directory path with special characters",
-			str:       `re:dir:~/too-secret\\ directory/\.dir$`,
-			wantRe:    `~/too-secret\\ directory/\.dir$`,
-			wantIsDir: true,
+			desc:        "prefixed directory path with special characters",
+			str:         `re:dir:~/too-secret\\ directory/\.dir$`,
+			wantRe:      `~/too-secret\\ directory/\.dir$`,
+			wantIsDir:   true,
+			wantIsRegex: true,
 		},
 		{
-			desc:      "prefixed directory path with special characters and wildcard",
-			str:       `re:dir:~/too-secret/\.dir_.*$`,
-			wantRe:    `~/too-secret/\.dir_.*$`,
-			wantIsDir: true,
+			desc:        "prefixed directory path with special characters and wildcard",
+			str:         `re:dir:~/too-secret/\.dir_.*$`,
+			wantRe:      `~/too-secret/\.dir_.*$`,
+			wantIsDir:   true,
+			wantIsRegex: true,
+		},
+		/// With re:path: and re:dir:path: prefixes
+		{
+			desc:        "prefixed file path matched against the path",
+			str:         `re:path:^simple/file/path$`,
+			wantRe:      `^simple/file/path$`,
+			wantIsDir:   false,
+			wantIsRegex: true,
+			wantOnPath:  true,
+		},
+		{
+			desc:        "prefixed directory path matched against the path",
+			str:         `re:dir:path:(^|/)simple/dir$`,
+			wantRe:      `(^|/)simple/dir$`,
+			wantIsDir:   true,
+			wantIsRegex: true,
+			wantOnPath:  true,
 		},
 	}
 	for _, c := range cases {
 		c := c
 		t.Run(c.desc, func(t *testing.T) {
-			gotRe, gotIsDir := convertIgnorePatternToRegex(c.str)
+			gotRe, gotIsDir, gotIsRegex, gotOnName := convertIgnorePatternToRegex(c.str)
 			if gotRe != c.wantRe {
 				t.Errorf("regex: wanted: '%s', got: '%s'", c.wantRe, gotRe)
 			}
 			if gotIsDir != c.wantIsDir {
 				t.Errorf("isDir: wanted: %t, got: %t", c.wantIsDir, gotIsDir)
 			}
+			if gotIsRegex != c.wantIsRegex {
+				t.Errorf("isRegex: wanted: %t, got: %t", c.wantIsRegex, gotIsRegex)
+			}
+			if wantOnName := c.wantIsRegex && !c.wantOnPath; gotOnName != wantOnName {
+				t.Errorf("onName: wanted: %t, got: %t", wantOnName, gotOnName)
+			}
+		})
+	}
+}
+
+// TestGlobToRegex runs the pathname-aware wildmatch cases of git's
+// t3070-wildmatch.sh.
+func TestGlobToRegex(t *testing.T) {
+	cases := []struct {
+		text    string
+		pattern string
+		want    bool
+	}{
+		// Basic wildmatch features
+		{"foo", "foo", true},
+		{"foo", "bar", false},
+		{"foo", "???", true},
+		{"foo", "??", false},
+		{"foo", "*", true},
+		{"foo", "f*", true},
+		{"foo", "*f", false},
+		{"foo", "*foo*", true},
+		{"foobar", "*ob*a*r*", true},
+		{"aaaaaaabababab", "*ab", true},
+		{"foo*", `foo\*`, true},
+		{"foobar", `foo\*bar`, false},
+		{`f\oo`, `f\\oo`, true},
+		{"ball", "*[al]?", true},
+		{"ten", "[ten]", false},
+		{"ten", "**[!te]", true},
+		{"ten", "**[!ten]", false},
+		{"ten", "t[a-g]n", true},
+		{"ten", "t[!a-g]n", false},
+		{"ton", "t[!a-g]n", true},
+		{"ton", "t[^a-g]n", true},
+		{"a]b", "a[]]b", true},
+		{"a-b", "a[]-]b", true},
+		{"a]b", "a[]-]b", true},
+		{"aab", "a[]-]b", false},
+		{"aab", "a[]a-]b", true},
+		{"]", "]", true},
+		// Extended slash-matching features
+		{"foo/baz/bar", "foo*bar", false},
+		{"foo/baz/bar", "foo**bar", false},
+		{"foobazbar", "foo**bar", true},
+		{"foo/baz/bar", "foo/**/bar", true},
+		{"foo/baz/bar", "foo/**/**/bar", true},
+		{"foo/b/a/z/bar", "foo/**/bar", true},
+		{"foo/b/a/z/bar", "foo/**/**/bar", true},
+		{"foo/bar", "foo/**/bar", true},
+		{"foo/bar", "foo/**/**/bar", true},
+		{"foo/bar", "foo?bar", false},
+		{"foo/bar", "foo[/]bar", false},
+		{"foo/bar", "f[^eiu][^eiu][^eiu][^eiu][^eiu]r", false},
+		{"foo-bar", "f[^eiu][^eiu][^eiu][^eiu][^eiu]r", true},
+		{"foo", "**/foo", true},
+		{"XXX/foo", "**/foo", true},
+		{"bar/baz/foo", "**/foo", true},
+		{"bar/baz/foo", "*/foo", false},
+		{"foo/bar/baz", "**/bar*", false},
+		{"deep/foo/bar/baz", "**/bar/*", true},
+		{"deep/foo/bar/baz/", "**/bar/**", true},
+		{"deep/foo/bar", "**/bar/*", false},
+		{"deep/foo/bar/", "**/bar/**", true},
+		{"foo/bar/baz", "**/bar**", false},
+		{"foo/bar/baz/x", "*/bar/**", true},
+		{"deep/foo/bar/baz/x", "*/bar/**", false},
+		{"deep/foo/bar/baz/x", "**/bar/*/*", true},
+		// Various additional tests
+		{"acrt", "a[c-c]st", false},
+		{"acrt", "a[c-c]rt", true},
+		{"]", "[!]-]", false},
+		{"a", "[!]-]", true},
+		{"", `\`, false},
+		{`\`, `\`, false},
+		{"XXX/\\", `*/\`, false},
+		{"XXX/\\", `*/\\`, true},
+		{"foo", "foo", true},
+		{"@foo", "@foo", true},
+		{"foo", "@foo", false},
+		{"[ab]", `\[ab]`, true},
+		{"[ab]", "[[]ab]", true},
+		{"[ab]", "[[:]ab]", true},
+		{"[ab]", "[[::]ab]", false},
+		{"[ab]", "[[:digit]ab]", true},
+		{"[ab]", `[\[:]ab]`, true},
+		{"?a?b", `\??\?b`, true},
+		{"abc", `\a\b\c`, true},
+		{"foo/bar/baz/to", "**/t[o]", true},
+		// Character class tests
+		{"a1B", "[[:alpha:]][[:digit:]][[:upper:]]", true},
+		{"a", "[[:digit:][:upper:][:space:]]", false},
+		{"A", "[[:digit:][:upper:][:space:]]", true},
+		{"1", "[[:digit:][:upper:][:space:]]", true},
+		{"1", "[[:digit:][:upper:][:spaci:]]", false},
+		{" ", "[[:digit:][:upper:][:space:]]", true},
+		{".", "[[:digit:][:upper:][:space:]]", false},
+		{".", "[[:digit:][:punct:][:space:]]", true},
+		{"5", "[[:xdigit:]]", true},
+		{"f", "[[:xdigit:]]", true},
+		{"D", "[[:xdigit:]]", true},
+		{"_", "[[:alnum:][:alpha:][:blank:][:cntrl:][:digit:][:graph:][:lower:][:print:][:punct:][:space:][:upper:][:xdigit:]]", true},
+		{".", "[^[:alnum:][:alpha:][:blank:][:cntrl:][:digit:][:lower:][:space:][:upper:][:xdigit:]]", true},
+		{"5", "[a-c[:digit:]x-z]", true},
+		{"b", "[a-c[:digit:]x-z]", true},
+		{"y", "[a-c[:digit:]x-z]", true},
+		{"q", "[a-c[:digit:]x-z]", false},
+		// Additional tests, including some malformed wildmatch patterns
+		{"]", `[\\-^]`, true},
+		{"[", `[\\-^]`, false},
+		{"-", `[\-_]`, true},
+		{"]", `[\]]`, true},
+		{`\]`, `[\]]`, false},
+		{`\`, `[\]]`, false},
+		{"ab", "a[]b", false},
+		{"a[]b", "a[]b", true},
+		{"ab[", "ab[", true},
+		{"ab", "[!", false},
+		{"ab", "[-", false},
+		{"-", "[-]", true},
+		{"-", "[a-", false},
+		{"-", "[!a-", false},
+		{"-", "[--A]", true},
+		{"5", "[--A]", true},
+		{" ", "[ --]", true},
+		{"$", "[ --]", true},
+		{"-", "[ --]", true},
+		{"0", "[ --]", false},
+		{"-", "[---]", true},
+		{"-", "[------]", true},
+		{"j", "[a-e-n]", false},
+		{"-", "[a-e-n]", true},
+		{"a", "[!------]", true},
+		{"[", "[]-a]", false},
+		{"^", "[]-a]", true},
+		{"^", "[!]-a]", false},
+		{"[", "[!]-a]", true},
+		{"^", "[a^bc]", true},
+		{"-b]", "[a-]b]", true},
+		{`\`, `[\]`, false},
+		{`\`, `[\\]`, true},
+		{`\`, `[!\\]`, false},
+		{"G", `[A-\\]`, true},
+		{"aaabbb", "b*a", false},
+		{"aabcaa", "*ba*", false},
+		{",", "[,]", true},
+		{",", `[\\,]`, true},
+		{`\`, `[\\,]`, true},
+		{"-", "[,-.]", true},
+		{"+", "[,-.]", false},
+		{"-.]", "[,-.]", false},
+		{"2", `[\1-\3]`, true},
+		{"3", `[\1-\3]`, true},
+		{"4", `[\1-\3]`, false},
+		{`\`, `[[-\]]`, true},
+		{"[", `[[-\]]`, true},
+		{"]", `[[-\]]`, true},
+		{"-", `[[-\]]`, false},
+		// Recursion and the abort code
+		{"-adobe-courier-bold-o-normal--12-120-75-75-m-70-iso8859-1", "-*-*-*-*-*-*-12-*-*-*-m-*-*-*", true},
+		{"-adobe-courier-bold-o-normal--12-120-75-75-X-70-iso8859-1", "-*-*-*-*-*-*-12-*-*-*-m-*-*-*", false},
+		{"-adobe-courier-bold-o-normal--12-120-75-75-/-70-iso8859-1", "-*-*-*-*-*-*-12-*-*-*-m-*-*-*", false},
+		{"XXX/adobe/courier/bold/o/normal//12/120/75/75/m/70/iso8859/1", "XXX/*/*/*/*/*/*/12/*/*/*/m/*/*/*", true},
+		{"XXX/adobe/courier/bold/o/normal//12/120/75/75/X/70/iso8859/1", "XXX/*/*/*/*/*/*/12/*/*/*/m/*/*/*", false},
+		{"abcd/abcdefg/abcdefghijk/abcdefghijklmnop.txt", "**/*a*b*g*n*t", true},
+		{"abcd/abcdefg/abcdefghijk/abcdefghijklmnop.txtz", "**/*a*b*g*n*t", false},
+		{"foo", "*/*/*", false},
+		{"foo/bar", "*/*/*", false},
+		{"foo/bba/arr", "*/*/*", true},
+		{"foo/bb/aa/rr", "*/*/*", false},
+		{"foo/bb/aa/rr", "**/**/**", true},
+		{"abcXdefXghi", "*X*i", true},
+		{"ab/cXd/efXg/hi", "*X*i", false},
+		{"ab/cXd/efXg/hi", "*/*X*/*/*i", true},
+		{"ab/cXd/efXg/hi", "**/*X*/**/*i", true},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.pattern+" "+c.text, func(t *testing.T) {
+			s, ok := globToRegex(c.pattern)
+			got := false
+			if ok {
+				if re, err := regexp.Compile("^" + s + "$"); err == nil {
+					got = re.MatchString(c.text)
+				}
+			}
+			if got != c.want {
+				t.Errorf("matching %q against %q (regex %q): wanted: %t, got: %t", c.text, c.pattern, s, c.want, got)
+			}
+		})
+	}
+}
+
+func TestShouldIgnore(t *testing.T) {
+	const rules = `# comments and blank lines are skipped
+
+one
+ignored-*
+top-level-dir/
+*.log
+!important.log
+/anchored
+a/**/z
+doc/*.txt
+\#hash
+\!bang
+trailing\ 
+spaces   
+re:path:^build/.*\.o$
+re:dir:(^|/)node_modules$
+re:^tmp[0-9]+$
+re:dir:path:^web/dist$
+`
+	cases := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{path: "one", want: true},
+		{path: "a/one", want: true},
+		{path: "a/one", isDir: true, want: true},
+		{path: "one-more", want: false},
+		{path: "ignored-and-untracked", want: true},
+		{path: "sub/ignored-too", isDir: true, want: true},
+		{path: "top-level-dir", isDir: true, want: true},
+		{path: "sub/top-level-dir", isDir: true, want: true},
+		{path: "top-level-dir", isDir: false, want: false},
+		{path: "debug.log", want: true},
+		{path: "sub/debug.log", want: true},
+		{path: "important.log", want: false},
+		{path: "sub/important.log", want: false},
+		{path: "anchored", want: true},
+		{path: "sub/anchored", want: false},
+		{path: "a/z", want: true},
+		{path: "a/b/c/z", isDir: true, want: true},
+		{path: "b/a/z", want: false},
+		{path: "doc/notes.txt", want: true},
+		{path: "doc/sub/notes.txt", want: false},
+		{path: "#hash", want: true},
+		{path: "!bang", want: true},
+		{path: "bang", want: false},
+		{path: "trailing ", want: true},
+		{path: "trailing", want: false},
+		{path: "spaces", want: true},
+		{path: "build/main.o", want: true},
+		{path: "build/main.o", isDir: true, want: false},
+		{path: "src/build/main.o", want: false},
+		{path: "web/node_modules", isDir: true, want: true},
+		{path: "web/node_modules", want: false},
+		{path: "tmp12", want: true},
+		{path: "sub/tmp12", want: true},
+		{path: "tmp12/file", want: false},
+		{path: "web/dist", isDir: true, want: true},
+		{path: "sub/web/dist", isDir: true, want: false},
+	}
+	ic := &IgnoreChecker{}
+	if err := ic.addRules("test", strings.NewReader(rules)); err != nil {
+		t.Fatalf("addRules: %v", err)
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.path, func(t *testing.T) {
+			if got := ic.ShouldIgnore(c.path, c.isDir); got != c.want {
+				t.Errorf("ShouldIgnore(%q, %t): wanted: %t, got: %t", c.path, c.isDir, c.want, got)
+			}
 		})
 	}
 }
+
+func TestParseIgnoreRuleInvalidRegex(t *testing.T) {
+	if _, _, err := parseIgnoreRule("re:dir:(unclosed"); err == nil {
+		t.Error("wanted an error for an invalid regex rule, got none")
+	}
+	rule, ok, err := parseIgnoreRule("[[:nonsense:]]")
+	if err != nil || !ok {
+		t.Fatalf("wanted malformed globs to be accepted, got ok: %t, err: %v", ok, err)
+	}
+	if rule.matches("n", false) {
+		t.Error("wanted a malformed glob to match nothing")
+	}
+}
+
+func TestScoped(t *testing.T) {
+	var ic *IgnoreChecker
+	ic, err := ic.Scoped("", ".glsignore", strings.NewReader("*.log\nbuild/\n"))
+	if err != nil {
+		t.Fatalf("Scoped: %v", err)
+	}
+	ic, err = ic.Scoped("pkg", "pkg/.glsignore", strings.NewReader("!debug.log\n/out\n"))
+	if err != nil {
+		t.Fatalf("Scoped: %v", err)
+	}
+	same, err := ic.Scoped("pkg/sub", "pkg/sub/.glsignore", strings.NewReader("# nothing\n"))
+	if err != nil {
+		t.Fatalf("Scoped: %v", err)
+	}
+	if same != ic {
+		t.Errorf("wanted an ignore file without rules to leave the checker as it is")
+	}
+	cases := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{path: "app.log", want: true},
+		{path: "debug.log", want: true},
+		{path: "pkg/app.log", want: true},
+		{path: "pkg/debug.log", want: false},
+		{path: "pkg/sub/debug.log", want: false},
+		{path: "pkg2/debug.log", want: true},
+		{path: "out", want: false},
+		{path: "pkg/out", want: true},
+		{path: "pkg/sub/out", want: false},
+		{path: "pkg/build", isDir: true, want: true},
+	}
+	for _, c := range cases {
+		if got := ic.ShouldIgnore(c.path, c.isDir); got != c.want {
+			t.Errorf("ShouldIgnore(%q, %t): wanted: %t, got: %t", c.path, c.isDir, c.want, got)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	ic := &IgnoreChecker{}
+	if err := ic.addRules("rules", strings.NewReader("# logs\n*.log\n\n!keep.log\n")); err != nil {
+		t.Fatalf("addRules: %v", err)
+	}
+	cases := []struct {
+		path        string
+		wantLine    int
+		wantIgnored bool
+	}{
+		{path: "debug.log", wantLine: 2, wantIgnored: true},
+		{path: "keep.log", wantLine: 4, wantIgnored: false},
+		{path: "main.go", wantLine: 0, wantIgnored: false},
+	}
+	for _, c := range cases {
+		rule, ignored := ic.Match(c.path, false)
+		line := 0
+		if rule != nil {
+			line = rule.Line
+			if rule.File != "rules" {
+				t.Errorf("Match(%q): wanted rule from %q, got %q", c.path, "rules", rule.File)
+			}
+		}
+		if line != c.wantLine || ignored != c.wantIgnored {
+			t.Errorf("Match(%q): wanted line %d, ignored %t, got line %d, ignored %t", c.path, c.wantLine, c.wantIgnored, line, ignored)
+		}
+	}
+}
+
+func TestWithRules(t *testing.T) {
+	ic, err := NewIgnoreChecker(WithRules("-exclude", "*.tmp", "!keep.tmp", "re:dir:^cache$"))
+	if err != nil {
+		t.Fatalf("NewIgnoreChecker: %v", err)
+	}
+	cases := []struct {
+		path     string
+		isDir    bool
+		want     bool
+		wantLine int
+	}{
+		{path: "a.tmp", want: true, wantLine: 1},
+		{path: "sub/keep.tmp", want: false, wantLine: 2},
+		{path: "cache", isDir: true, want: true, wantLine: 3},
+		{path: "cache", want: false},
+	}
+	for _, c := range cases {
+		rule, got := ic.Match(c.path, c.isDir)
+		if got != c.want {
+			t.Errorf("Match(%q, %t): wanted: %t, got: %t", c.path, c.isDir, c.want, got)
+		}
+		line := 0
+		if rule != nil {
+			line = rule.Line
+			if rule.File != "-exclude" {
+				t.Errorf("Match(%q, %t): wanted rule of -exclude, got: %s", c.path, c.isDir, rule.File)
+			}
+		}
+		if line != c.wantLine {
+			t.Errorf("Match(%q, %t): wanted rule on line %d, got: %d", c.path, c.isDir, c.wantLine, line)
+		}
+	}
+	if _, err := CompileRules("-include", "re:("); err == nil {
+		t.Errorf("CompileRules: wanted an error for an invalid regex")
+	}
+}
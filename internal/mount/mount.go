@@ -0,0 +1,54 @@
+package mount
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Point is a mounted file system.
+type Point struct {
+	Path   string
+	FsType string
+	Source string
+}
+
+// Table maps the absolute paths of mount points to the file systems
+// mounted on them.
+type Table map[string]Point
+
+// LoadTable reads the mount points of the running system.
+func LoadTable() (Table, error) {
+	points, err := points()
+	if err != nil {
+		return nil, err
+	}
+	t := make(Table, len(points))
+	for _, p := range points {
+		// Later entries are mounted over earlier ones on the same path.
+		t[p.Path] = p
+	}
+	return t, nil
+}
+
+// Lookup returns the file system mounted exactly on the absolute path.
+func (t Table) Lookup(path string) (Point, bool) {
+	p, ok := t[filepath.Clean(path)]
+	return p, ok
+}
+
+// Containing returns the mount point of the file system that holds the
+// absolute path, that is the longest mount point which is a prefix of it.
+func (t Table) Containing(path string) (Point, bool) {
+	path = filepath.Clean(path)
+	var best Point
+	found := false
+	for mp, p := range t {
+		if mp != path && mp != "/" && !strings.HasPrefix(path, mp+"/") {
+			continue
+		}
+		if !found || len(mp) > len(best.Path) {
+			best, found = p, true
+		}
+	}
+	return best, found
+}
@@ -0,0 +1,41 @@
+package mount
+
+import (
+	"syscall"
+)
+
+// mntNoWait makes getfsstat return cached information instead of
+// refreshing every file system.
+const mntNoWait = 2
+
+func points() ([]Point, error) {
+	n, err := syscall.Getfsstat(nil, mntNoWait)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]syscall.Statfs_t, n)
+	n, err = syscall.Getfsstat(buf, mntNoWait)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]Point, 0, n)
+	for _, st := range buf[:n] {
+		points = append(points, Point{
+			Path:   cString(st.Mntonname[:]),
+			FsType: cString(st.Fstypename[:]),
+			Source: cString(st.Mntfromname[:]),
+		})
+	}
+	return points, nil
+}
+
+func cString(b []int8) string {
+	s := make([]byte, 0, len(b))
+	for _, c := range b {
+		if c == 0 {
+			break
+		}
+		s = append(s, byte(c))
+	}
+	return string(s)
+}
@@ -0,0 +1,77 @@
+package mount
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const mountInfoFile = "/proc/self/mountinfo"
+
+func points() ([]Point, error) {
+	f, err := os.Open(mountInfoFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var points []Point
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		p, err := parseMountInfoLine(s.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", mountInfoFile, err)
+		}
+		points = append(points, p)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// parseMountInfoLine parses a line of /proc/self/mountinfo, which looks like
+//
+//	36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+//
+// where the fifth field is the mount point, and the fields after the
+// separating dash are the file system type and source.
+func parseMountInfoLine(line string) (Point, error) {
+	fields := strings.Fields(line)
+	sep := -1
+	for i := 6; i < len(fields); i++ {
+		if fields[i] == "-" {
+			sep = i
+			break
+		}
+	}
+	if len(fields) < 5 || sep < 0 || sep+2 >= len(fields) {
+		return Point{}, fmt.Errorf("malformed line %q", line)
+	}
+	return Point{
+		Path:   unescapeOctal(fields[4]),
+		FsType: fields[sep+1],
+		Source: unescapeOctal(fields[sep+2]),
+	}, nil
+}
+
+// unescapeOctal decodes the \ooo escapes the kernel uses for spaces, tabs,
+// newlines and backslashes in mount paths.
+func unescapeOctal(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,64 @@
+package mount
+
+import (
+	"testing"
+)
+
+func TestParseMountInfoLine(t *testing.T) {
+	cases := []struct {
+		desc string
+		line string
+		want Point
+	}{
+		{
+			desc: "without optional fields",
+			line: "23 28 0:22 / /proc rw,relatime - proc proc rw",
+			want: Point{Path: "/proc", FsType: "proc", Source: "proc"},
+		},
+		{
+			desc: "with optional fields",
+			line: "36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 shared:2 - ext3 /dev/root rw,errors=continue",
+			want: Point{Path: "/mnt2", FsType: "ext3", Source: "/dev/root"},
+		},
+		{
+			desc: "escaped space in path",
+			line: `40 28 0:45 / /media/usb\040disk rw - vfat /dev/sdb1 rw`,
+			want: Point{Path: "/media/usb disk", FsType: "vfat", Source: "/dev/sdb1"},
+		},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.desc, func(t *testing.T) {
+			got, err := parseMountInfoLine(c.line)
+			if err != nil {
+				t.Fatalf("parseMountInfoLine(%q): %v", c.line, err)
+			}
+			if got != c.want {
+				t.Errorf("parseMountInfoLine(%q) = %+v, want %+v", c.line, got, c.want)
+			}
+		})
+	}
+	if _, err := parseMountInfoLine("23 28 0:22 / /proc rw,relatime"); err == nil {
+		t.Errorf("parseMountInfoLine accepted a line without separator")
+	}
+}
+
+func TestTableContaining(t *testing.T) {
+	table := Table{
+		"/":         {Path: "/", FsType: "ext4"},
+		"/home":     {Path: "/home", FsType: "xfs"},
+		"/home/nfs": {Path: "/home/nfs", FsType: "nfs"},
+	}
+	cases := map[string]string{
+		"/etc/passwd":     "/",
+		"/home":           "/home",
+		"/home/user/file": "/home",
+		"/home/nfsfoo":    "/home",
+		"/home/nfs/x":     "/home/nfs",
+	}
+	for path, want := range cases {
+		if got, ok := table.Containing(path); !ok || got.Path != want {
+			t.Errorf("Containing(%q) = %q, %t, want %q", path, got.Path, ok, want)
+		}
+	}
+}
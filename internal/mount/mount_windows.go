@@ -0,0 +1,7 @@
+package mount
+
+// points returns no mount points, since Windows volumes are not mounted
+// into a single tree.
+func points() ([]Point, error) {
+	return nil, nil
+}
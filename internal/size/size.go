@@ -1,13 +1,55 @@
 package size
 
 import (
+	"errors"
 	"io/fs"
 )
 
+// ErrNoOwner is returned by DiskUsage.GetOwner for files whose owner is not
+// known.
+var ErrNoOwner = errors.New("file owner not known")
+
+// FileID identifies a file independent of the links pointing to it.
+type FileID struct {
+	Device uint64
+	Inode  uint64
+}
+
 type DiskUsage interface {
 	GetSize(f fs.FileInfo) (int64, error)
 	GetSizeOnDisk(f fs.FileInfo) (int64, error)
+	GetFileID(f fs.FileInfo) (FileID, error)
+	GetLinkCount(f fs.FileInfo) (uint64, error)
+	// GetOwner returns the user id of the owner of the file.
+	GetOwner(f fs.FileInfo) (uint32, error)
 }
 
 type FsInfo struct {
 }
+
+// ApparentInfo is the DiskUsage of files that only know their size, such as
+// files in memory or in archives. The size on disk is the size, and every
+// file is its only link.
+type ApparentInfo struct {
+}
+
+func (ApparentInfo) GetSize(f fs.FileInfo) (int64, error) {
+	return f.Size(), nil
+}
+
+func (ApparentInfo) GetSizeOnDisk(f fs.FileInfo) (int64, error) {
+	return f.Size(), nil
+}
+
+// GetFileID returns the zero FileID, which identifies no file.
+func (ApparentInfo) GetFileID(f fs.FileInfo) (FileID, error) {
+	return FileID{}, nil
+}
+
+func (ApparentInfo) GetLinkCount(f fs.FileInfo) (uint64, error) {
+	return 1, nil
+}
+
+func (ApparentInfo) GetOwner(f fs.FileInfo) (uint32, error) {
+	return 0, ErrNoOwner
+}
@@ -23,3 +23,27 @@ func (fsInfo FsInfo) GetSizeOnDisk(fInfo fs.FileInfo) (int64, error) {
 	}
 	return (st.Blocks * internal.UNIXSizeOfBlock), nil
 }
+
+func (fsInfo FsInfo) GetFileID(fInfo fs.FileInfo) (FileID, error) {
+	st, ok := fInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return FileID{}, fmt.Errorf("could not cast %T to syscall.Stat_t", fInfo.Sys())
+	}
+	return FileID{Device: uint64(st.Dev), Inode: st.Ino}, nil
+}
+
+func (fsInfo FsInfo) GetLinkCount(fInfo fs.FileInfo) (uint64, error) {
+	st, ok := fInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("could not cast %T to syscall.Stat_t", fInfo.Sys())
+	}
+	return uint64(st.Nlink), nil
+}
+
+func (fsInfo FsInfo) GetOwner(fInfo fs.FileInfo) (uint32, error) {
+	st, ok := fInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("could not cast %T to syscall.Stat_t", fInfo.Sys())
+	}
+	return st.Uid, nil
+}
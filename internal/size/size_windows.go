@@ -15,3 +15,18 @@ func (fsInfo FsInfo) GetSizeOnDisk(fInfo fs.FileInfo) (int64, error) {
 	sizeOnDisk := (size + internal.NSClusterSize - 1) / internal.NSClusterSize * internal.NSClusterSize
 	return sizeOnDisk, nil
 }
+
+// GetFileID returns the zero FileID, since hard links are not tracked on
+// Windows.
+func (fsInfo FsInfo) GetFileID(fInfo fs.FileInfo) (FileID, error) {
+	return FileID{}, nil
+}
+
+func (fsInfo FsInfo) GetLinkCount(fInfo fs.FileInfo) (uint64, error) {
+	return 1, nil
+}
+
+// GetOwner returns ErrNoOwner, since files have no user ids on Windows.
+func (fsInfo FsInfo) GetOwner(fInfo fs.FileInfo) (uint32, error) {
+	return 0, ErrNoOwner
+}
@@ -0,0 +1,396 @@
+package snapshot
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.sazak.io/gls/internal/types"
+)
+
+// The binary format starts with binaryMagic, followed by a gzip stream of
+//
+//	version  uvarint
+//	path     string
+//	time     varint, Unix nanoseconds
+//	root     node
+//
+// where a node is a list of fields terminated by tagEnd, the number of its
+// children as a uvarint and the children themselves, the hidden ones last
+// with flagHidden set since version 2. Every field is a tag,
+// the length of its value and the value, so readers skip fields they do not
+// know. Strings are their length followed by their bytes, and numbers are
+// varints or uvarints.
+const binaryMagic = "GLSSNAP\x00"
+
+const (
+	tagEnd = iota
+	tagName
+	tagMode
+	tagSize
+	tagSizeOnDisk
+	tagApparentSize
+	tagApparentSizeOnDisk
+	tagLinks
+	tagFlags
+	tagModTime
+	tagLinkTarget
+	tagFsType
+	tagScanError
+	tagArchive
+	tagUncompressedSize
+	tagStatsFiles
+	tagStatsDirs
+	tagStatsDepth
+	tagStatsLargestFile
+	tagStatsLargestSize
+	tagStatsNewest
+	tagStatsOldest
+	tagStatsOwnSizeOnDisk
+)
+
+const (
+	flagDir = 1 << iota
+	flagDuplicateLink
+	flagBrokenLink
+	flagMountPoint
+	flagVirtual
+	flagHidden
+)
+
+type binaryWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (bw *binaryWriter) uvarint(v uint64) {
+	if bw.err != nil {
+		return
+	}
+	n := binary.PutUvarint(bw.buf[:], v)
+	_, bw.err = bw.w.Write(bw.buf[:n])
+}
+
+func (bw *binaryWriter) varint(v int64) {
+	if bw.err != nil {
+		return
+	}
+	n := binary.PutVarint(bw.buf[:], v)
+	_, bw.err = bw.w.Write(bw.buf[:n])
+}
+
+func (bw *binaryWriter) string(s string) {
+	bw.uvarint(uint64(len(s)))
+	if bw.err != nil {
+		return
+	}
+	_, bw.err = bw.w.WriteString(s)
+}
+
+func (bw *binaryWriter) uvarintField(tag int, v uint64) {
+	if v == 0 {
+		return
+	}
+	bw.uvarint(uint64(tag))
+	n := binary.PutUvarint(bw.buf[:], v)
+	bw.uvarint(uint64(n))
+	bw.uvarint(v)
+}
+
+func (bw *binaryWriter) varintField(tag int, v int64) {
+	if v == 0 {
+		return
+	}
+	bw.uvarint(uint64(tag))
+	n := binary.PutVarint(bw.buf[:], v)
+	bw.uvarint(uint64(n))
+	bw.varint(v)
+}
+
+func (bw *binaryWriter) stringField(tag int, s string) {
+	if s == "" {
+		return
+	}
+	bw.uvarint(uint64(tag))
+	bw.string(s)
+}
+
+func (bw *binaryWriter) node(n *types.Node, hidden bool) {
+	bw.stringField(tagName, n.Name)
+	bw.uvarintField(tagMode, uint64(n.Mode))
+	bw.varintField(tagSize, n.Size)
+	bw.varintField(tagSizeOnDisk, n.SizeOnDisk)
+	bw.varintField(tagApparentSize, n.ApparentSize)
+	bw.varintField(tagApparentSizeOnDisk, n.ApparentSizeOnDisk)
+	bw.uvarintField(tagLinks, n.Links)
+	var flags uint64
+	if n.IsDir {
+		flags |= flagDir
+	}
+	if n.DuplicateLink {
+		flags |= flagDuplicateLink
+	}
+	if n.BrokenLink {
+		flags |= flagBrokenLink
+	}
+	if n.MountPoint {
+		flags |= flagMountPoint
+	}
+	if n.Virtual {
+		flags |= flagVirtual
+	}
+	if hidden {
+		flags |= flagHidden
+	}
+	bw.uvarintField(tagFlags, flags)
+	if !n.LastModification.IsZero() {
+		bw.varintField(tagModTime, n.LastModification.UnixNano())
+	}
+	bw.stringField(tagLinkTarget, n.LinkTarget)
+	bw.stringField(tagFsType, n.FsType)
+	bw.stringField(tagScanError, n.ScanError)
+	bw.stringField(tagArchive, n.Archive)
+	bw.varintField(tagUncompressedSize, n.UncompressedSize)
+	if st := n.Stats; st != nil {
+		bw.varintField(tagStatsFiles, st.Files)
+		bw.varintField(tagStatsDirs, st.Dirs)
+		bw.varintField(tagStatsDepth, int64(st.Depth))
+		bw.stringField(tagStatsLargestFile, st.LargestFile)
+		bw.varintField(tagStatsLargestSize, st.LargestSize)
+		if !st.Newest.IsZero() {
+			bw.varintField(tagStatsNewest, st.Newest.UnixNano())
+		}
+		if !st.Oldest.IsZero() {
+			bw.varintField(tagStatsOldest, st.Oldest.UnixNano())
+		}
+		bw.varintField(tagStatsOwnSizeOnDisk, st.OwnSizeOnDisk)
+	}
+	bw.uvarint(tagEnd)
+	bw.uvarint(uint64(len(n.Children) + len(n.Hidden)))
+	for _, c := range n.Children {
+		bw.node(c, false)
+	}
+	for _, c := range n.Hidden {
+		bw.node(c, true)
+	}
+}
+
+func writeBinary(w io.Writer, s *Snapshot) error {
+	if _, err := io.WriteString(w, binaryMagic); err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(w)
+	bw := &binaryWriter{w: bufio.NewWriter(zw)}
+	bw.uvarint(Version)
+	bw.string(s.Path)
+	bw.varint(s.ScannedAt.UnixNano())
+	bw.node(s.Root, false)
+	if bw.err != nil {
+		return bw.err
+	}
+	if err := bw.w.Flush(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+type binaryReader struct {
+	r *bufio.Reader
+	// version is the format version of the snapshot being read.
+	version uint64
+}
+
+func (br *binaryReader) uvarint() (uint64, error) {
+	return binary.ReadUvarint(br.r)
+}
+
+func (br *binaryReader) varint() (int64, error) {
+	return binary.ReadVarint(br.r)
+}
+
+func (br *binaryReader) bytes() ([]byte, error) {
+	n, err := br.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > 1<<20 {
+		return nil, fmt.Errorf("field of %d bytes is too long", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(br.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// node reads a node, and whether it is a hidden child of parent.
+func (br *binaryReader) node(parent *types.Node) (n *types.Node, hidden bool, err error) {
+	n = &types.Node{Parent: parent}
+	for {
+		tag, err := br.uvarint()
+		if err != nil {
+			return nil, false, err
+		}
+		if tag == tagEnd {
+			break
+		}
+		value, err := br.bytes()
+		if err != nil {
+			return nil, false, err
+		}
+		if err := setField(n, tag, value); err != nil {
+			return nil, false, err
+		}
+		if tag == tagFlags {
+			// Validated by setField.
+			flags, _ := binary.Uvarint(value)
+			hidden = br.version >= 2 && flags&flagHidden != 0
+		}
+	}
+	count, err := br.uvarint()
+	if err != nil {
+		return nil, false, err
+	}
+	for i := uint64(0); i < count; i++ {
+		c, cHidden, err := br.node(n)
+		if err != nil {
+			return nil, false, err
+		}
+		if cHidden {
+			n.Hidden = append(n.Hidden, c)
+		} else {
+			n.Children = append(n.Children, c)
+		}
+	}
+	return n, hidden, nil
+}
+
+// setField decodes the value of a field into n, ignoring unknown tags.
+func setField(n *types.Node, tag uint64, value []byte) error {
+	uvarint := func() (uint64, error) {
+		v, k := binary.Uvarint(value)
+		if k <= 0 {
+			return 0, fmt.Errorf("malformed value of field %d", tag)
+		}
+		return v, nil
+	}
+	varint := func() (int64, error) {
+		v, k := binary.Varint(value)
+		if k <= 0 {
+			return 0, fmt.Errorf("malformed value of field %d", tag)
+		}
+		return v, nil
+	}
+	stats := func() *types.Stats {
+		if n.Stats == nil {
+			n.Stats = &types.Stats{}
+		}
+		return n.Stats
+	}
+	var err error
+	switch tag {
+	case tagName:
+		n.Name = string(value)
+	case tagMode:
+		var mode uint64
+		mode, err = uvarint()
+		n.Mode = os.FileMode(mode)
+	case tagSize:
+		n.Size, err = varint()
+	case tagSizeOnDisk:
+		n.SizeOnDisk, err = varint()
+	case tagApparentSize:
+		n.ApparentSize, err = varint()
+	case tagApparentSizeOnDisk:
+		n.ApparentSizeOnDisk, err = varint()
+	case tagLinks:
+		n.Links, err = uvarint()
+	case tagFlags:
+		var flags uint64
+		flags, err = uvarint()
+		n.IsDir = flags&flagDir != 0
+		n.DuplicateLink = flags&flagDuplicateLink != 0
+		n.BrokenLink = flags&flagBrokenLink != 0
+		n.MountPoint = flags&flagMountPoint != 0
+		n.Virtual = flags&flagVirtual != 0
+	case tagModTime:
+		var nsec int64
+		nsec, err = varint()
+		n.LastModification = time.Unix(0, nsec)
+	case tagLinkTarget:
+		n.LinkTarget = string(value)
+	case tagFsType:
+		n.FsType = string(value)
+	case tagScanError:
+		n.ScanError = string(value)
+	case tagArchive:
+		n.Archive = string(value)
+	case tagUncompressedSize:
+		n.UncompressedSize, err = varint()
+	case tagStatsFiles:
+		stats().Files, err = varint()
+	case tagStatsDirs:
+		stats().Dirs, err = varint()
+	case tagStatsDepth:
+		var depth int64
+		depth, err = varint()
+		stats().Depth = int(depth)
+	case tagStatsLargestFile:
+		stats().LargestFile = string(value)
+	case tagStatsLargestSize:
+		stats().LargestSize, err = varint()
+	case tagStatsNewest:
+		var nsec int64
+		nsec, err = varint()
+		stats().Newest = time.Unix(0, nsec)
+	case tagStatsOldest:
+		var nsec int64
+		nsec, err = varint()
+		stats().Oldest = time.Unix(0, nsec)
+	case tagStatsOwnSizeOnDisk:
+		stats().OwnSizeOnDisk, err = varint()
+	}
+	return err
+}
+
+func readBinary(r *bufio.Reader) (*Snapshot, error) {
+	if _, err := r.Discard(len(binaryMagic)); err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	br := &binaryReader{r: bufio.NewReader(zr)}
+	version, err := br.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	br.version = version
+	if version > Version {
+		return nil, fmt.Errorf("unsupported snapshot version %d, newest known is %d", version, Version)
+	}
+	path, err := br.bytes()
+	if err != nil {
+		return nil, err
+	}
+	nsec, err := br.varint()
+	if err != nil {
+		return nil, err
+	}
+	root, _, err := br.node(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		Path:      string(path),
+		ScannedAt: time.Unix(0, nsec),
+		Root:      root,
+	}, nil
+}
@@ -0,0 +1,149 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.sazak.io/gls/internal/types"
+)
+
+type jsonSnapshot struct {
+	Version   int       `json:"version"`
+	Path      string    `json:"path"`
+	ScannedAt time.Time `json:"scanned_at"`
+	Root      *jsonNode `json:"root"`
+}
+
+type jsonNode struct {
+	Name               string      `json:"name"`
+	Mode               uint32      `json:"mode"`
+	Size               int64       `json:"size"`
+	SizeOnDisk         int64       `json:"size_on_disk"`
+	ApparentSize       int64       `json:"apparent_size"`
+	ApparentSizeOnDisk int64       `json:"apparent_size_on_disk"`
+	Links              uint64      `json:"links,omitempty"`
+	DuplicateLink      bool        `json:"duplicate_link,omitempty"`
+	LinkTarget         string      `json:"link_target,omitempty"`
+	BrokenLink         bool        `json:"broken_link,omitempty"`
+	MountPoint         bool        `json:"mount_point,omitempty"`
+	FsType             string      `json:"fs_type,omitempty"`
+	ScanError          string      `json:"scan_error,omitempty"`
+	Archive            string      `json:"archive,omitempty"`
+	UncompressedSize   int64       `json:"uncompressed_size,omitempty"`
+	Virtual            bool        `json:"virtual,omitempty"`
+	IsDir              bool        `json:"is_dir,omitempty"`
+	LastModification   time.Time   `json:"last_modification"`
+	Children           []*jsonNode `json:"children,omitempty"`
+	Hidden             []*jsonNode `json:"hidden,omitempty"`
+	Stats              *jsonStats  `json:"stats,omitempty"`
+}
+
+type jsonStats struct {
+	Files         int64     `json:"files"`
+	Dirs          int64     `json:"dirs"`
+	Depth         int       `json:"depth"`
+	LargestFile   string    `json:"largest_file,omitempty"`
+	LargestSize   int64     `json:"largest_size,omitempty"`
+	Newest        time.Time `json:"newest"`
+	Oldest        time.Time `json:"oldest"`
+	OwnSizeOnDisk int64     `json:"own_size_on_disk"`
+}
+
+func toJSONNode(n *types.Node) *jsonNode {
+	jn := &jsonNode{
+		Name:               n.Name,
+		Mode:               uint32(n.Mode),
+		Size:               n.Size,
+		SizeOnDisk:         n.SizeOnDisk,
+		ApparentSize:       n.ApparentSize,
+		ApparentSizeOnDisk: n.ApparentSizeOnDisk,
+		Links:              n.Links,
+		DuplicateLink:      n.DuplicateLink,
+		LinkTarget:         n.LinkTarget,
+		BrokenLink:         n.BrokenLink,
+		MountPoint:         n.MountPoint,
+		FsType:             n.FsType,
+		ScanError:          n.ScanError,
+		Archive:            n.Archive,
+		UncompressedSize:   n.UncompressedSize,
+		Virtual:            n.Virtual,
+		IsDir:              n.IsDir,
+		LastModification:   n.LastModification,
+	}
+	if st := n.Stats; st != nil {
+		js := jsonStats(*st)
+		jn.Stats = &js
+	}
+	for _, c := range n.Children {
+		jn.Children = append(jn.Children, toJSONNode(c))
+	}
+	for _, c := range n.Hidden {
+		jn.Hidden = append(jn.Hidden, toJSONNode(c))
+	}
+	return jn
+}
+
+func (jn *jsonNode) toNode(parent *types.Node) *types.Node {
+	n := &types.Node{
+		Name:               jn.Name,
+		Mode:               os.FileMode(jn.Mode),
+		Size:               jn.Size,
+		SizeOnDisk:         jn.SizeOnDisk,
+		ApparentSize:       jn.ApparentSize,
+		ApparentSizeOnDisk: jn.ApparentSizeOnDisk,
+		Links:              jn.Links,
+		DuplicateLink:      jn.DuplicateLink,
+		LinkTarget:         jn.LinkTarget,
+		BrokenLink:         jn.BrokenLink,
+		MountPoint:         jn.MountPoint,
+		FsType:             jn.FsType,
+		ScanError:          jn.ScanError,
+		Archive:            jn.Archive,
+		UncompressedSize:   jn.UncompressedSize,
+		Virtual:            jn.Virtual,
+		IsDir:              jn.IsDir,
+		LastModification:   jn.LastModification,
+		Parent:             parent,
+	}
+	if jn.Stats != nil {
+		st := types.Stats(*jn.Stats)
+		n.Stats = &st
+	}
+	for _, c := range jn.Children {
+		n.Children = append(n.Children, c.toNode(n))
+	}
+	for _, c := range jn.Hidden {
+		n.Hidden = append(n.Hidden, c.toNode(n))
+	}
+	return n
+}
+
+func writeJSON(w io.Writer, s *Snapshot) error {
+	return json.NewEncoder(w).Encode(&jsonSnapshot{
+		Version:   Version,
+		Path:      s.Path,
+		ScannedAt: s.ScannedAt,
+		Root:      toJSONNode(s.Root),
+	})
+}
+
+func readJSON(r io.Reader) (*Snapshot, error) {
+	var js jsonSnapshot
+	if err := json.NewDecoder(r).Decode(&js); err != nil {
+		return nil, err
+	}
+	if js.Version > Version {
+		return nil, fmt.Errorf("unsupported snapshot version %d, newest known is %d", js.Version, Version)
+	}
+	if js.Root == nil {
+		return nil, fmt.Errorf("snapshot has no root node")
+	}
+	return &Snapshot{
+		Path:      js.Path,
+		ScannedAt: js.ScannedAt,
+		Root:      js.Root.toNode(nil),
+	}, nil
+}
@@ -0,0 +1,110 @@
+// Package snapshot saves scanned file trees to files and loads them back,
+// so a tree can be browsed without access to the scanned file system.
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.sazak.io/gls/internal/types"
+)
+
+// Version is the snapshot format version written by this package. Version 2
+// added the hidden children of directories, which older readers would take
+// for visible ones.
+const Version = 2
+
+type Format int
+
+const (
+	Binary Format = iota
+	JSON
+)
+
+func (f Format) String() string {
+	switch f {
+	case Binary:
+		return "binary"
+	case JSON:
+		return "json"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// Snapshot is a scanned tree along with where and when it was scanned.
+type Snapshot struct {
+	Path      string
+	ScannedAt time.Time
+	Root      *types.Node
+}
+
+// FormatForFile picks the format of a snapshot file by its extension: JSON
+// for .json files and the compact binary format for everything else.
+func FormatForFile(name string) Format {
+	if strings.EqualFold(filepath.Ext(name), ".json") {
+		return JSON
+	}
+	return Binary
+}
+
+// Write encodes s to w in the given format.
+func Write(w io.Writer, s *Snapshot, f Format) error {
+	switch f {
+	case Binary:
+		return writeBinary(w, s)
+	case JSON:
+		return writeJSON(w, s)
+	}
+	return fmt.Errorf("unknown snapshot format %v", f)
+}
+
+// Read decodes a snapshot in either format from r.
+func Read(r io.Reader) (*Snapshot, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(binaryMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.Equal(head, []byte(binaryMagic)) {
+		return readBinary(br)
+	}
+	return readJSON(br)
+}
+
+// Save writes s to the named file, in the format FormatForFile picks.
+func Save(name string, s *Snapshot) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := Write(w, s, FormatForFile(name)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load reads the snapshot in the named file.
+func Load(name string) (*Snapshot, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not read snapshot %s: %v", name, err)
+	}
+	return s, nil
+}
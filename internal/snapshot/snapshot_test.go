@@ -0,0 +1,181 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.sazak.io/gls/internal/types"
+)
+
+func testTree() *types.Node {
+	mtime := time.Date(2022, 10, 1, 12, 30, 0, 123, time.UTC)
+	root := &types.Node{
+		Name:               "root",
+		Mode:               os.ModeDir | 0o755,
+		Size:               8192 + 100,
+		SizeOnDisk:         8192 + 4096,
+		ApparentSize:       8192 + 200,
+		ApparentSizeOnDisk: 8192 + 8192,
+		IsDir:              true,
+		LastModification:   mtime,
+		MountPoint:         true,
+		FsType:             "ext4",
+	}
+	children := []*types.Node{
+		{Name: "file", Mode: 0o644, Size: 100, SizeOnDisk: 4096, ApparentSize: 100, ApparentSizeOnDisk: 4096, Links: 2, LastModification: mtime},
+		{Name: "link", Mode: 0o644, Size: 100, SizeOnDisk: 4096, ApparentSize: 100, ApparentSizeOnDisk: 4096, Links: 2, DuplicateLink: true, LastModification: mtime},
+		{Name: "dangling", Mode: os.ModeSymlink | 0o777, LinkTarget: "nowhere", BrokenLink: true, LastModification: mtime},
+		{Name: "empty dir", Mode: os.ModeDir | 0o700, Size: 4096, SizeOnDisk: 4096, ApparentSize: 4096, ApparentSizeOnDisk: 4096, IsDir: true, LastModification: mtime},
+		{Name: "private", Mode: os.ModeDir | 0o700, Size: 4096, SizeOnDisk: 4096, ApparentSize: 4096, ApparentSizeOnDisk: 4096, IsDir: true, ScanError: "permission denied", LastModification: mtime},
+	}
+	for _, c := range children {
+		c.Parent = root
+		root.Children = append(root.Children, c)
+	}
+	archive := &types.Node{Name: "logs.zip", Mode: 0o644, Size: 300, SizeOnDisk: 4096, ApparentSize: 300, ApparentSizeOnDisk: 4096, Links: 1, Archive: "zip", UncompressedSize: 5000, LastModification: mtime, Parent: root}
+	archive.Children = []*types.Node{
+		{Name: "app.log", Mode: 0o644, Size: 5000, SizeOnDisk: 250, ApparentSize: 5000, ApparentSizeOnDisk: 250, Links: 1, Virtual: true, LastModification: mtime, Parent: archive},
+	}
+	root.Children = append(root.Children, archive)
+	root.Hidden = []*types.Node{
+		{Name: "cache", Mode: os.ModeDir | 0o755, Size: 10, SizeOnDisk: 4096, ApparentSize: 10, ApparentSizeOnDisk: 4096, IsDir: true, LastModification: mtime, Parent: root,
+			Stats: &types.Stats{Files: 3, Dirs: 1, Depth: 2, LargestFile: "sub/blob", LargestSize: 8, Newest: mtime, Oldest: mtime.AddDate(-1, 0, 0), OwnSizeOnDisk: 4096}},
+	}
+	root.Stats = &types.Stats{Files: 4, Dirs: 3, Depth: 3, LargestFile: "cache/sub/blob", LargestSize: 4096, Newest: mtime, Oldest: mtime.AddDate(-1, 0, 0)}
+	return root
+}
+
+// utcStats returns a copy of s with its times in UTC.
+func utcStats(s *types.Stats) *types.Stats {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	c.Newest, c.Oldest = c.Newest.UTC(), c.Oldest.UTC()
+	return &c
+}
+
+// flatten lists the nodes of a tree in preorder with their parents cleared,
+// so trees can be compared with reflect.DeepEqual.
+func flatten(n *types.Node) []types.Node {
+	flat := []types.Node{{
+		Name:               n.Name,
+		Mode:               n.Mode,
+		Size:               n.Size,
+		SizeOnDisk:         n.SizeOnDisk,
+		ApparentSize:       n.ApparentSize,
+		ApparentSizeOnDisk: n.ApparentSizeOnDisk,
+		Links:              n.Links,
+		DuplicateLink:      n.DuplicateLink,
+		LinkTarget:         n.LinkTarget,
+		BrokenLink:         n.BrokenLink,
+		MountPoint:         n.MountPoint,
+		FsType:             n.FsType,
+		ScanError:          n.ScanError,
+		Archive:            n.Archive,
+		UncompressedSize:   n.UncompressedSize,
+		Virtual:            n.Virtual,
+		IsDir:              n.IsDir,
+		LastModification:   n.LastModification.UTC(),
+		Stats:              utcStats(n.Stats),
+	}}
+	for _, c := range n.Children {
+		if c.Parent != n {
+			panic("child with wrong parent: " + c.Name)
+		}
+		flat = append(flat, flatten(c)...)
+	}
+	for _, c := range n.Hidden {
+		if c.Parent != n {
+			panic("hidden child with wrong parent: " + c.Name)
+		}
+		hidden := flatten(c)
+		hidden[0].Name = "(hidden) " + hidden[0].Name
+		flat = append(flat, hidden...)
+	}
+	return flat
+}
+
+func TestRoundTrip(t *testing.T) {
+	want := &Snapshot{
+		Path:      "/home/user",
+		ScannedAt: time.Date(2022, 10, 2, 8, 0, 0, 0, time.UTC),
+		Root:      testTree(),
+	}
+	for _, f := range []Format{Binary, JSON} {
+		t.Run(f.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, want, f); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			got, err := Read(&buf)
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if got.Path != want.Path || !got.ScannedAt.Equal(want.ScannedAt) {
+				t.Errorf("got path %q scanned at %v, want %q at %v", got.Path, got.ScannedAt, want.Path, want.ScannedAt)
+			}
+			if g, w := flatten(got.Root), flatten(want.Root); !reflect.DeepEqual(g, w) {
+				t.Errorf("got tree\n%+v\nwant\n%+v", g, w)
+			}
+		})
+	}
+}
+
+func TestReadSkipsUnknownFields(t *testing.T) {
+	var buf bytes.Buffer
+	bw := &binaryWriter{w: bufio.NewWriter(&buf)}
+	bw.stringField(tagName, "root")
+	bw.stringField(1000, "from a newer version")
+	bw.uvarint(tagEnd)
+	bw.uvarint(0)
+	bw.w.Flush()
+	n, _, err := (&binaryReader{r: bufio.NewReader(&buf), version: Version}).node(nil)
+	if err != nil {
+		t.Fatalf("node: %v", err)
+	}
+	if n.Name != "root" {
+		t.Errorf("got name %q, want %q", n.Name, "root")
+	}
+}
+
+func TestLoadVersion1(t *testing.T) {
+	// Written by gls before hidden children were saved, from a scan of
+	// dir/a.txt and b.
+	s, err := Load("testdata/v1.gls")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if s.Path != "/tmp/v1src" {
+		t.Errorf("got path %q, want %q", s.Path, "/tmp/v1src")
+	}
+	flat := flatten(s.Root)
+	var names []string
+	for i := range flat {
+		names = append(names, flat[i].Name)
+	}
+	if want := []string{"v1src", "dir", "a.txt", "b"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got nodes %q, want %q", names, want)
+	}
+	if len(s.Root.Hidden) != 0 {
+		t.Errorf("got %d hidden children, want none", len(s.Root.Hidden))
+	}
+}
+
+func TestFormatForFile(t *testing.T) {
+	for name, want := range map[string]Format{
+		"out.gls":      Binary,
+		"out":          Binary,
+		"out.json":     JSON,
+		"dir/OUT.JSON": JSON,
+		"out.json.gls": Binary,
+	} {
+		if got := FormatForFile(name); got != want {
+			t.Errorf("FormatForFile(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
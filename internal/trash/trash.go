@@ -0,0 +1,394 @@
+// Package trash moves files to the trash of the user instead of deleting
+// them, and restores or purges them later, as laid out by the FreeDesktop.org
+// Trash specification.
+package trash
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.sazak.io/gls/internal/mount"
+	"go.sazak.io/gls/internal/size"
+)
+
+const (
+	infoSuffix = ".trashinfo"
+	// dateLayout is the layout of the deletion dates, in local time.
+	dateLayout = "2006-01-02T15:04:05"
+)
+
+// Can is the trash of the user, made of the home trash and the trash
+// directories at the top of the other mounted file systems. Files are
+// moved to the trash directory on their own file system, so that they are
+// renamed and never copied.
+type Can struct {
+	home   string
+	uid    int
+	mounts mount.Table
+	usage  size.DiskUsage
+}
+
+// Option configures a Can.
+type Option func(*Can)
+
+// WithHome sets the directory of the home trash, which is
+// $XDG_DATA_HOME/Trash by default.
+func WithHome(dir string) Option {
+	return func(c *Can) {
+		c.home = dir
+	}
+}
+
+// WithMounts sets the mounted file systems whose top directories hold the
+// trash directories of files outside the file system of the home trash.
+// The mount points of the running system are used by default.
+func WithMounts(t mount.Table) Option {
+	return func(c *Can) {
+		c.mounts = t
+	}
+}
+
+// Entry is a file or folder in the trash.
+type Entry struct {
+	// Name is the name of the entry in its trash directory.
+	Name string
+	// Path is the absolute path the entry was deleted from.
+	Path string
+	// DeletedAt is when the entry was moved to the trash.
+	DeletedAt time.Time
+	IsDir     bool
+	// Dir is the trash directory holding the entry.
+	Dir string
+}
+
+// File returns the path of the trashed file or folder.
+func (e *Entry) File() string {
+	return filepath.Join(e.Dir, "files", e.Name)
+}
+
+// Info returns the path of the file describing the entry.
+func (e *Entry) Info() string {
+	return filepath.Join(e.Dir, "info", e.Name+infoSuffix)
+}
+
+// New returns the trash of the user running the program.
+func New(opts ...Option) (*Can, error) {
+	if runtime.GOOS == "windows" {
+		return nil, errors.New("the trash is not supported on windows")
+	}
+	c := &Can{
+		uid:   os.Getuid(),
+		usage: size.FsInfo{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.home == "" {
+		dataHome := os.Getenv("XDG_DATA_HOME")
+		if dataHome == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("could not find the home trash: %v", err)
+			}
+			dataHome = filepath.Join(home, ".local", "share")
+		}
+		c.home = filepath.Join(dataHome, "Trash")
+	}
+	if c.mounts == nil {
+		// Without the mount points only the file system of the home trash
+		// can be trashed to.
+		c.mounts, _ = mount.LoadTable()
+	}
+	return c, nil
+}
+
+// Put moves the file or folder at path to the trash.
+func (c *Can) Put(path string) (*Entry, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Lstat(abs)
+	if err != nil {
+		return nil, err
+	}
+	dir, top, err := c.dirFor(abs)
+	if err != nil {
+		return nil, err
+	}
+	// Paths in the trash directories of other file systems are relative to
+	// their top directory, so that they still hold when it is mounted
+	// elsewhere.
+	infoPath := abs
+	if top != "" {
+		if infoPath, err = filepath.Rel(top, abs); err != nil {
+			return nil, err
+		}
+	}
+	e := &Entry{Path: abs, DeletedAt: time.Now(), IsDir: fi.IsDir(), Dir: dir}
+	if err := e.writeInfo(filepath.Base(abs), infoPath); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(abs, e.File()); err != nil {
+		os.Remove(e.Info())
+		return nil, fmt.Errorf("could not move %s to the trash: %v", abs, err)
+	}
+	return e, nil
+}
+
+// writeInfo picks a name for e, not taken in its trash directory yet, and
+// writes its info file, which reserves the name.
+func (e *Entry) writeInfo(base, path string) error {
+	text := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+		(&url.URL{Path: path}).EscapedPath(), e.DeletedAt.Format(dateLayout))
+	for i := 1; ; i++ {
+		e.Name = base
+		if i > 1 {
+			e.Name = fmt.Sprintf("%s.%d", base, i)
+		}
+		f, err := os.OpenFile(e.Info(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := os.Lstat(e.File()); err == nil {
+			// A file left behind without its info.
+			f.Close()
+			os.Remove(e.Info())
+			continue
+		}
+		_, err = f.WriteString(text)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(e.Info())
+		}
+		return err
+	}
+}
+
+// dirFor returns the trash directory for the file at the absolute path, and
+// the top directory of its file system if that is not the one of the home
+// trash.
+func (c *Can) dirFor(abs string) (dir, top string, err error) {
+	parent, err := os.Lstat(filepath.Dir(abs))
+	if err != nil {
+		return "", "", err
+	}
+	if err := makeTrashDir(c.home); err != nil {
+		return "", "", fmt.Errorf("could not create the home trash: %v", err)
+	}
+	home, err := os.Lstat(c.home)
+	if err != nil {
+		return "", "", err
+	}
+	if c.sameDevice(parent, home) {
+		return c.home, "", nil
+	}
+	p, ok := c.mounts.Containing(abs)
+	if !ok {
+		return "", "", fmt.Errorf("could not find the file system of %s", abs)
+	}
+	dir, err = c.topDir(p.Path)
+	if err != nil {
+		return "", "", fmt.Errorf("no trash on the file system of %s: %v", abs, err)
+	}
+	return dir, p.Path, nil
+}
+
+func (c *Can) sameDevice(a, b os.FileInfo) bool {
+	idA, errA := c.usage.GetFileID(a)
+	idB, errB := c.usage.GetFileID(b)
+	return errA == nil && errB == nil && idA.Device == idB.Device
+}
+
+// topDir returns the trash directory of the user at the top directory top
+// of a file system, creating it if needed. The shared top/.Trash is used if
+// the administrator set it up, and top/.Trash-$uid otherwise.
+func (c *Can) topDir(top string) (string, error) {
+	if shared, ok := c.sharedDir(top); ok {
+		dir := filepath.Join(shared, strconv.Itoa(c.uid))
+		if err := makeTrashDir(dir); err == nil {
+			return dir, nil
+		}
+	}
+	dir := c.userDir(top)
+	if err := makeTrashDir(dir); err != nil {
+		return "", err
+	}
+	// Refuse trash directories that were replaced by a link to elsewhere.
+	if fi, err := os.Lstat(dir); err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+	return dir, nil
+}
+
+// sharedDir returns top/.Trash, and whether it is fit to hold the trash of
+// every user: a directory, not a link, with the sticky bit set.
+func (c *Can) sharedDir(top string) (string, bool) {
+	shared := filepath.Join(top, ".Trash")
+	fi, err := os.Lstat(shared)
+	return shared, err == nil && fi.IsDir() && fi.Mode()&os.ModeSticky != 0
+}
+
+func (c *Can) userDir(top string) string {
+	return filepath.Join(top, fmt.Sprintf(".Trash-%d", c.uid))
+}
+
+func makeTrashDir(dir string) error {
+	for _, sub := range []string{"files", "info"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirs returns the trash directories of the user that exist, the home trash
+// first.
+func (c *Can) dirs() []dirTop {
+	dirs := []dirTop{{dir: c.home}}
+	seen := map[string]bool{c.home: true}
+	add := func(dir, top string) {
+		if seen[dir] {
+			return
+		}
+		if fi, err := os.Lstat(filepath.Join(dir, "info")); err == nil && fi.IsDir() {
+			seen[dir] = true
+			dirs = append(dirs, dirTop{dir: dir, top: top})
+		}
+	}
+	for top := range c.mounts {
+		if shared, ok := c.sharedDir(top); ok {
+			add(filepath.Join(shared, strconv.Itoa(c.uid)), top)
+		}
+		add(c.userDir(top), top)
+	}
+	return dirs
+}
+
+// dirTop is a trash directory, and the top directory of its file system
+// that the paths of its entries are relative to, which is empty for the
+// home trash.
+type dirTop struct {
+	dir, top string
+}
+
+// List returns the entries in all the trash directories of the user, the
+// most recently deleted first. Info files that cannot be parsed, or whose
+// file is gone, are left out.
+func (c *Can) List() ([]*Entry, error) {
+	var entries []*Entry
+	for _, d := range c.dirs() {
+		infos, err := os.ReadDir(filepath.Join(d.dir, "info"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, info := range infos {
+			name := strings.TrimSuffix(info.Name(), infoSuffix)
+			if name == info.Name() {
+				continue
+			}
+			e, err := readInfo(d, name)
+			if err != nil {
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].DeletedAt.After(entries[j].DeletedAt)
+	})
+	return entries, nil
+}
+
+// readInfo reads the entry name of the trash directory d.
+func readInfo(d dirTop, name string) (*Entry, error) {
+	e := &Entry{Name: name, Dir: d.dir}
+	fi, err := os.Lstat(e.File())
+	if err != nil {
+		return nil, err
+	}
+	e.IsDir = fi.IsDir()
+	f, err := os.Open(e.Info())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	inGroup := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inGroup = line == "[Trash Info]"
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !inGroup || !ok {
+			continue
+		}
+		switch key {
+		case "Path":
+			p, err := url.PathUnescape(value)
+			if err != nil {
+				return nil, err
+			}
+			if !filepath.IsAbs(p) {
+				if d.top == "" {
+					return nil, fmt.Errorf("relative path %q in the home trash", p)
+				}
+				p = filepath.Join(d.top, p)
+			}
+			e.Path = filepath.Clean(p)
+		case "DeletionDate":
+			if t, err := time.ParseInLocation(dateLayout, value, time.Local); err == nil {
+				e.DeletedAt = t
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if e.Path == "" {
+		return nil, fmt.Errorf("no path in %s", e.Info())
+	}
+	return e, nil
+}
+
+// Restore moves the entry back to where it was deleted from, which must not
+// be taken again. Missing folders above it are created.
+func (c *Can) Restore(e *Entry) error {
+	if _, err := os.Lstat(e.Path); err == nil {
+		return fmt.Errorf("%s already exists", e.Path)
+	}
+	if err := os.MkdirAll(filepath.Dir(e.Path), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(e.File(), e.Path); err != nil {
+		return err
+	}
+	return os.Remove(e.Info())
+}
+
+// Purge deletes the entry from the trash for good.
+func (c *Can) Purge(e *Entry) error {
+	if err := os.RemoveAll(e.File()); err != nil {
+		return err
+	}
+	return os.Remove(e.Info())
+}
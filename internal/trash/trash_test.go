@@ -0,0 +1,183 @@
+package trash
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.sazak.io/gls/internal/mount"
+)
+
+func newTestCan(t *testing.T, mounts mount.Table) (*Can, string) {
+	t.Helper()
+	if mounts == nil {
+		mounts = mount.Table{}
+	}
+	home := filepath.Join(t.TempDir(), "Trash")
+	c, err := New(WithHome(home), WithMounts(mounts))
+	if err != nil {
+		t.Skipf("no trash: %v", err)
+	}
+	return c, home
+}
+
+func writeFile(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(path), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPutRestore(t *testing.T) {
+	c, home := newTestCan(t, nil)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a b%.txt")
+	writeFile(t, file)
+	writeFile(t, filepath.Join(dir, "folder", "x"))
+
+	e, err := c.Put(file)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	assert.Equal(t, home, e.Dir)
+	assert.Equal(t, "a b%.txt", e.Name)
+	if _, err := os.Lstat(file); !os.IsNotExist(err) {
+		t.Errorf("Put left the file in place: %v", err)
+	}
+	info, err := os.ReadFile(e.Info())
+	if err != nil {
+		t.Fatalf("no info file: %v", err)
+	}
+	assert.Contains(t, string(info), "[Trash Info]\nPath="+filepath.ToSlash(dir)+"/a%20b%25.txt\nDeletionDate=")
+
+	// A file of the same name gets a name of its own in the trash.
+	writeFile(t, file)
+	e2, err := c.Put(file)
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	assert.Equal(t, "a b%.txt.2", e2.Name)
+	folder, err := c.Put(filepath.Join(dir, "folder"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	entries, err := c.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if assert.Len(t, entries, 3) {
+		for _, got := range entries {
+			switch got.Name {
+			case e.Name, e2.Name:
+				assert.Equal(t, file, got.Path)
+				assert.False(t, got.IsDir)
+			case folder.Name:
+				assert.Equal(t, filepath.Join(dir, "folder"), got.Path)
+				assert.True(t, got.IsDir)
+			default:
+				t.Errorf("unexpected entry %q", got.Name)
+			}
+			assert.WithinDuration(t, e.DeletedAt, got.DeletedAt, 2*time.Second)
+		}
+	}
+
+	writeFile(t, file)
+	if err := c.Restore(e); err == nil {
+		t.Errorf("Restore: wanted an error, %s is taken", file)
+	}
+	// Missing folders above a restored entry are created again.
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Restore(folder); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "folder", "x")); err != nil {
+		t.Errorf("folder was not restored: %v", err)
+	}
+	if err := c.Purge(e2); err != nil {
+		t.Fatalf("Purge: %v", err)
+	}
+	entries, err = c.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, e.Name, entries[0].Name)
+	}
+	for _, p := range []string{e2.File(), e2.Info(), folder.Info()} {
+		if _, err := os.Lstat(p); !os.IsNotExist(err) {
+			t.Errorf("%s is left: %v", p, err)
+		}
+	}
+}
+
+func TestListSkipsBrokenInfo(t *testing.T) {
+	c, home := newTestCan(t, nil)
+	if err := makeTrashDir(home); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(home, "files", "nopath"))
+	writeFile(t, filepath.Join(home, "info", "nopath.trashinfo"))
+	writeFile(t, filepath.Join(home, "info", "nofile.trashinfo"))
+	writeFile(t, filepath.Join(home, "files", "relative"))
+	if err := os.WriteFile(filepath.Join(home, "info", "relative.trashinfo"), []byte("[Trash Info]\nPath=x/y\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := c.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	assert.Empty(t, entries)
+}
+
+func TestTopDir(t *testing.T) {
+	top := t.TempDir()
+	c, _ := newTestCan(t, mount.Table{top: {Path: top}})
+	uid := strconv.Itoa(c.uid)
+
+	dir, err := c.topDir(top)
+	if err != nil {
+		t.Fatalf("topDir: %v", err)
+	}
+	assert.Equal(t, filepath.Join(top, ".Trash-"+uid), dir)
+
+	// An entry there has a path relative to the top directory.
+	e := &Entry{Dir: dir}
+	if err := e.writeInfo("x", filepath.Join("sub dir", "x")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, e.File())
+	entries, err := c.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, filepath.Join(top, "sub dir", "x"), entries[0].Path)
+		assert.Equal(t, dir, entries[0].Dir)
+	}
+
+	// A shared .Trash is used only with the sticky bit.
+	shared := filepath.Join(top, ".Trash")
+	if err := os.Mkdir(shared, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if dir, err = c.topDir(top); err != nil || !strings.HasPrefix(dir, filepath.Join(top, ".Trash-")) {
+		t.Errorf("topDir = %s, %v; wanted the user trash without the sticky bit", dir, err)
+	}
+	if err := os.Chmod(shared, 0o777|os.ModeSticky); err != nil {
+		t.Fatal(err)
+	}
+	if dir, err = c.topDir(top); err != nil || dir != filepath.Join(shared, uid) {
+		t.Errorf("topDir = %s, %v; wanted %s", dir, err, filepath.Join(shared, uid))
+	}
+}
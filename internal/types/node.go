@@ -2,14 +2,13 @@ package types
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"regexp"
-	"sort"
 	"strings"
 	"sync"
 	"time"
 
-	"go.sazak.io/gls/internal"
 	"go.sazak.io/gls/internal/analyzer"
 	"go.sazak.io/gls/internal/size"
 )
@@ -23,20 +22,158 @@ type Node struct {
 	SizeOnDisk int64
 	// Blocks           int64
 
+	// ApparentSize and ApparentSizeOnDisk count a hard-linked file once for
+	// every link to it, while Size and SizeOnDisk of a directory only count
+	// each inode once.
+	ApparentSize       int64
+	ApparentSizeOnDisk int64
+	// Links is the number of hard links to the file.
+	Links uint64
+	// DuplicateLink is set on a file whose inode was already counted through
+	// another hard link during the scan, so its size is not counted in the
+	// unique size of its parents.
+	DuplicateLink bool
+	// LinkTarget is the target of a symbolic link, and BrokenLink is set if
+	// that target does not exist.
+	LinkTarget string
+	BrokenLink bool
+	// MountPoint is set on directories another file system is mounted on,
+	// and FsType is the type of that file system if it is known.
+	MountPoint bool
+	FsType     string
+	// ScanError describes why the entry could not be scanned completely,
+	// for example why the contents of a directory could not be read. The
+	// sizes of such an entry only count what could be scanned.
+	ScanError string
+	// Archive is the format of an archive file whose entries are expanded
+	// into its children, and UncompressedSize the total size of those
+	// entries. The sizes of an archive are the sizes of the archive file.
+	Archive          string
+	UncompressedSize int64
+	// Virtual is set on the entries of archives, which do not exist on the
+	// file system. The size on disk of an entry is its compressed size.
+	Virtual bool
+	// Hidden are the children left out of Children for being ignored or
+	// below the size threshold, without entries of their own. Their sizes
+	// count in the sizes of n all the same.
+	Hidden []*Node
+	// Ghost is set on the nodes made by HiddenSummary, which stand for the
+	// hidden children of their parent.
+	Ghost bool
+	// Stats aggregate the entries below a directory. They are nil for
+	// files, and for directories that were not descended into.
+	Stats *Stats
+
 	IsDir            bool
 	LastModification time.Time
 	Children         []*Node
 	Parent           *Node
 }
 
+func (n *Node) IsSymlink() bool {
+	return n.Mode&os.ModeSymlink != 0
+}
+
+// IsContainer reports whether n has entries of its own, being a directory
+// or an expanded archive.
+func (n *Node) IsContainer() bool {
+	return n.IsDir || n.Archive != ""
+}
+
+// DisplayName returns the name of n, followed by the link target for
+// symbolic links and markers for mount points and scan errors.
+func (n *Node) DisplayName() string {
+	name := n.Name
+	if n.IsSymlink() {
+		name = fmt.Sprintf("%s -> %s", name, n.LinkTarget)
+		if n.BrokenLink {
+			name += " (broken)"
+		}
+	}
+	if n.MountPoint {
+		if n.FsType == "" {
+			name += " (mount)"
+		} else {
+			name = fmt.Sprintf("%s (mount: %s)", name, n.FsType)
+		}
+	}
+	if n.Archive != "" {
+		name = fmt.Sprintf("%s (%s archive)", name, n.Archive)
+	}
+	if n.ScanError != "" {
+		name = fmt.Sprintf("%s (error: %s)", name, n.ScanError)
+	}
+	return name
+}
+
+// IsHidden reports whether n is one of the hidden children of its parent.
+func (n *Node) IsHidden() bool {
+	if n.Parent == nil {
+		return false
+	}
+	for _, h := range n.Parent.Hidden {
+		if h == n {
+			return true
+		}
+	}
+	return false
+}
+
+// HiddenSummary returns a ghost node standing for all hidden children of n,
+// with their count as its name and their total sizes, or nil if n has no
+// hidden children.
+func (n *Node) HiddenSummary() *Node {
+	if len(n.Hidden) == 0 {
+		return nil
+	}
+	name := fmt.Sprintf("%d hidden items", len(n.Hidden))
+	if len(n.Hidden) == 1 {
+		name = "1 hidden item"
+	}
+	g := &Node{Name: name, Ghost: true, Parent: n}
+	for _, h := range n.Hidden {
+		g.Size += h.UniqueSize()
+		g.SizeOnDisk += h.UniqueSizeOnDisk()
+		g.ApparentSize += h.ApparentSize
+		g.ApparentSizeOnDisk += h.ApparentSizeOnDisk
+	}
+	return g
+}
+
+// UniqueSize returns the size n adds to the unique size of its parent.
+func (n *Node) UniqueSize() int64 {
+	if n.DuplicateLink {
+		return 0
+	}
+	return n.Size
+}
+
+// UniqueSizeOnDisk returns the size on disk n adds to the unique size of its
+// parent.
+func (n *Node) UniqueSizeOnDisk() int64 {
+	if n.DuplicateLink {
+		return 0
+	}
+	return n.SizeOnDisk
+}
+
+// FileCount returns the number of files in the tree under n. The count of a
+// directory with stats is taken from them, and includes hidden files.
 func (n *Node) FileCount() int {
 	if n == nil {
 		return 0
 	}
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	if n.IsDir {
+	if n.Stats != nil {
+		return int(n.Stats.Files)
+	}
+	if n.IsContainer() {
 		count := 0
+		if !n.IsDir {
+			// An archive counts itself besides its entries.
+			count++
+		}
 		for _, c := range n.Children {
 			count += c.FileCount()
 		}
@@ -67,14 +204,7 @@ func (n *Node) clone(opts *cloneOpts) (*Node, error) {
 	if n.Parent != nil {
 		return nil, fmt.Errorf("can only clone root node")
 	}
-	root := &Node{
-		Name:             n.Name,
-		Mode:             n.Mode,
-		Size:             n.Size,
-		IsDir:            n.IsDir,
-		LastModification: n.LastModification,
-		Parent:           nil,
-	}
+	root := n.shallowCopy(nil)
 	for _, child := range n.Children {
 		if c := child.cloneWithParent(root, opts); c != nil {
 			root.AddChild(c)
@@ -89,14 +219,7 @@ func (n *Node) cloneWithParent(root *Node, opts *cloneOpts) *Node {
 	if opts.discardFiles && !root.IsDir {
 		return nil
 	}
-	clone := &Node{
-		Name:             n.Name,
-		Mode:             n.Mode,
-		Size:             n.Size,
-		IsDir:            n.IsDir,
-		LastModification: n.LastModification,
-		Parent:           root,
-	}
+	clone := n.shallowCopy(root)
 	for _, child := range n.Children {
 		if c := child.cloneWithParent(clone, opts); c != nil {
 			clone.AddChild(c)
@@ -105,6 +228,38 @@ func (n *Node) cloneWithParent(root *Node, opts *cloneOpts) *Node {
 	return clone
 }
 
+// shallowCopy returns a copy of n without children, attached to parent.
+// The hidden children are copied along, without children of their own, so
+// that the copy still has its ghost row.
+func (n *Node) shallowCopy(parent *Node) *Node {
+	c := &Node{
+		Name:               n.Name,
+		Mode:               n.Mode,
+		Size:               n.Size,
+		SizeOnDisk:         n.SizeOnDisk,
+		ApparentSize:       n.ApparentSize,
+		ApparentSizeOnDisk: n.ApparentSizeOnDisk,
+		Links:              n.Links,
+		DuplicateLink:      n.DuplicateLink,
+		LinkTarget:         n.LinkTarget,
+		BrokenLink:         n.BrokenLink,
+		MountPoint:         n.MountPoint,
+		FsType:             n.FsType,
+		ScanError:          n.ScanError,
+		Archive:            n.Archive,
+		UncompressedSize:   n.UncompressedSize,
+		Virtual:            n.Virtual,
+		Stats:              n.Stats,
+		IsDir:              n.IsDir,
+		LastModification:   n.LastModification,
+		Parent:             parent,
+	}
+	for _, h := range n.Hidden {
+		c.Hidden = append(c.Hidden, h.shallowCopy(c))
+	}
+	return c
+}
+
 type TreeFilterOptions struct {
 	nameContains    string
 	re              *regexp.Regexp
@@ -196,8 +351,12 @@ func removeZeroWeightsFromSearchTree(n *Node, weights map[*Node]int) {
 func getSearchTreeWeight(n *Node, weights map[*Node]int, opts *TreeFilterOptions) int {
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	if n.IsDir {
+	if n.IsContainer() {
 		weight := 0
+		if !n.IsDir && opts.CheckNameContains(n.Name) && opts.CheckRegexMatches(n.Name) {
+			// The archive itself matches.
+			weight++
+		}
 		for _, child := range n.Children {
 			weight += getSearchTreeWeight(child, weights, opts)
 		}
@@ -214,9 +373,28 @@ func getSearchTreeWeight(n *Node, weights map[*Node]int, opts *TreeFilterOptions
 }
 
 func (n *Node) GetFileType(parentPath string) (string, error) {
+	if n.IsSymlink() {
+		if n.BrokenLink {
+			return fmt.Sprintf("broken symbolic link to %s", n.LinkTarget), nil
+		}
+		return fmt.Sprintf("symbolic link to %s", n.LinkTarget), nil
+	}
+	if n.MountPoint {
+		if n.FsType == "" {
+			return "mount point", nil
+		}
+		return fmt.Sprintf("mount point (%s)", n.FsType), nil
+	}
 	if n.IsDir {
 		return "directory", nil
 	}
+	if n.Archive != "" {
+		return fmt.Sprintf("%s archive", n.Archive), nil
+	}
+	if n.Virtual {
+		// Its contents cannot be read to tell its type.
+		return "file in archive", nil
+	}
 	typ, err := analyzer.AnalyzeFileType(n.RelativePath(parentPath))
 	if err != nil {
 		return "", err
@@ -224,35 +402,30 @@ func (n *Node) GetFileType(parentPath string) (string, error) {
 	return fmt.Sprintf("%s (%s)", typ.MIME.Value, typ.Extension), nil
 }
 
+// Remove deletes the file n from the file system for good, and detaches it
+// from its parent so that the sizes of all its ancestors go down by its
+// size.
 func (n *Node) Remove(parentPath string) error {
-	n.mu.Lock()
-	defer n.mu.Unlock()
 	if n.IsDir {
 		return fmt.Errorf("cannot remove directory %s", n.Name)
 	}
-	n.Parent.RemoveChild(n)
-	return os.Remove(n.RelativePath(parentPath))
-}
-
-func (n *Node) RemoveChild(c *Node) {
-	n.mu.Lock()
-	defer n.mu.Unlock()
-	for i, child := range n.Children {
-		if child == c {
-			n.Children = append(n.Children[:i], n.Children[i+1:]...)
-			return
-		}
+	if n.Parent == nil {
+		return fmt.Errorf("cannot remove the root %s", n.Name)
 	}
+	if err := os.Remove(n.RelativePath(parentPath)); err != nil {
+		return err
+	}
+	return n.Parent.DetachChild(n)
 }
 
+// CreateChild creates the empty file fileName in the directory n, and
+// attaches a node for it to n.
 func (n *Node) CreateChild(fileName, parentPath string) error {
-	n.mu.Lock()
-	defer n.mu.Unlock()
 	if !n.IsDir {
 		return fmt.Errorf("cannot create file under a file (%s)", n.Name)
 	}
 	filePath := n.RelativePath(parentPath) + "/" + fileName
-	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+	if _, err := os.Lstat(filePath); !os.IsNotExist(err) {
 		return fmt.Errorf("file with path %s already exists", filePath)
 	}
 	f, err := os.Create(filePath)
@@ -260,35 +433,242 @@ func (n *Node) CreateChild(fileName, parentPath string) error {
 		return fmt.Errorf("could not create file %s: %v", filePath, err)
 	}
 	fInfo, err := f.Stat()
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("error while closing the newly created file %s: %v", filePath, closeErr)
+	}
 	if err != nil {
 		return fmt.Errorf("could not stat file %s: %v", filePath, err)
 	}
+	c, err := newFileNode(fInfo)
+	if err != nil {
+		return err
+	}
+	n.AttachChild(c)
+	return nil
+}
+
+// newFileNode returns a node for the file of the OS described by fInfo.
+func newFileNode(fInfo os.FileInfo) (*Node, error) {
 	var diskUsage size.DiskUsage = &size.FsInfo{}
-	size, err := diskUsage.GetSize(fInfo)
+	fileSize, err := diskUsage.GetSize(fInfo)
+	if err != nil {
+		return nil, err
+	}
+	sizeOnDisk, err := diskUsage.GetSizeOnDisk(fInfo)
 	if err != nil {
+		return nil, err
+	}
+	links, err := diskUsage.GetLinkCount(fInfo)
+	if err != nil {
+		return nil, err
+	}
+	return &Node{
+		Name:               fInfo.Name(),
+		Mode:               fInfo.Mode(),
+		Size:               fileSize,
+		SizeOnDisk:         sizeOnDisk,
+		ApparentSize:       fileSize,
+		ApparentSizeOnDisk: sizeOnDisk,
+		Links:              links,
+		IsDir:              fInfo.IsDir(),
+		LastModification:   fInfo.ModTime(),
+	}, nil
+}
+
+// ReplaceChild swaps the child old of n for new, and adds the resulting
+// size difference to n and all of its ancestors.
+func (n *Node) ReplaceChild(old, new *Node) error {
+	n.mu.Lock()
+	idx := -1
+	for i, child := range n.Children {
+		if child == old {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		n.mu.Unlock()
+		return fmt.Errorf("%s is not a child of %s", old.Name, n.Name)
+	}
+	n.Children[idx] = new
+	n.mu.Unlock()
+	old.Parent, new.Parent = nil, n
+	n.addSizeDelta(
+		new.UniqueSize()-old.UniqueSize(),
+		new.UniqueSizeOnDisk()-old.UniqueSizeOnDisk(),
+		new.ApparentSize-old.ApparentSize,
+		new.ApparentSizeOnDisk-old.ApparentSizeOnDisk,
+	)
+	n.updateStats()
+	return nil
+}
+
+// AttachChild adds c to the children of n, and adds its size to n and all of
+// its ancestors.
+func (n *Node) AttachChild(c *Node) {
+	n.mu.Lock()
+	n.Children = append(n.Children, c)
+	n.mu.Unlock()
+	c.Parent = n
+	n.addSizeDelta(c.UniqueSize(), c.UniqueSizeOnDisk(), c.ApparentSize, c.ApparentSizeOnDisk)
+	n.updateStats()
+}
+
+// DetachChild removes c from the children or hidden children of n, and
+// subtracts its size from n and all of its ancestors.
+func (n *Node) DetachChild(c *Node) error {
+	n.mu.Lock()
+	removed := false
+	for _, entries := range []*[]*Node{&n.Children, &n.Hidden} {
+		for i, child := range *entries {
+			if child == c {
+				*entries = append((*entries)[:i], (*entries)[i+1:]...)
+				removed = true
+				break
+			}
+		}
+	}
+	if !removed {
+		n.mu.Unlock()
+		return fmt.Errorf("%s is not a child of %s", c.Name, n.Name)
+	}
+	n.mu.Unlock()
+	c.Parent = nil
+	n.addSizeDelta(-c.UniqueSize(), -c.UniqueSizeOnDisk(), -c.ApparentSize, -c.ApparentSizeOnDisk)
+	n.updateStats()
+	return nil
+}
+
+// MoveTo detaches n from its parent and attaches it to the directory dst,
+// so that its size moves from the ancestors of its old place to those of
+// the new one.
+func (n *Node) MoveTo(dst *Node) error {
+	if n.Parent == nil {
+		return fmt.Errorf("cannot move the root %s", n.Name)
+	}
+	if !dst.IsDir {
+		return fmt.Errorf("cannot move %s under a file (%s)", n.Name, dst.Name)
+	}
+	for a := dst; a != nil; a = a.Parent {
+		if a == n {
+			return fmt.Errorf("cannot move %s into itself", n.Name)
+		}
+	}
+	if err := n.Parent.DetachChild(n); err != nil {
 		return err
 	}
-	n.Children = append(n.Children, &Node{
-		Name:             fInfo.Name(),
-		Mode:             fInfo.Mode(),
-		Size:             size,
-		SizeOnDisk:       size * internal.UNIXSizeOfBlock,
-		IsDir:            fInfo.IsDir(),
-		LastModification: fInfo.ModTime(),
-		Parent:           n,
-	})
-	if err = f.Close(); err != nil && err != os.ErrClosed {
-		return fmt.Errorf("error while closing the newly created file %s: %v", filePath, err)
+	dst.AttachChild(n)
+	return nil
+}
+
+// Rename renames n to name, which must not be taken by another entry of
+// its directory, and updates the stats of its ancestors, which refer to
+// their largest files by path.
+func (n *Node) Rename(name string) error {
+	if n.Parent == nil {
+		return fmt.Errorf("cannot rename the root %s", n.Name)
+	}
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, '/') {
+		return fmt.Errorf("invalid name %q", name)
 	}
+	if name == n.Name {
+		return nil
+	}
+	p := n.Parent
+	for _, entries := range [][]*Node{p.Children, p.Hidden} {
+		for _, c := range entries {
+			if c.Name == name {
+				return fmt.Errorf("%s already exists in %s", name, p.Name)
+			}
+		}
+	}
+	p.mu.Lock()
+	n.Name = name
+	p.mu.Unlock()
+	p.updateStats()
 	return nil
 }
 
-func (n *Node) IncrementSize(size int64) {
+// Resize sets the size and size on disk of the file n, and adds the
+// differences to all of its ancestors. The sizes of a directory follow
+// from its entries, so a changed directory is rescanned and swapped in with
+// ReplaceChild instead.
+func (n *Node) Resize(size, sizeOnDisk int64) error {
+	if n.IsDir {
+		return fmt.Errorf("cannot resize directory %s", n.Name)
+	}
 	n.mu.Lock()
-	defer n.mu.Unlock()
-	n.Size += size
+	oldSize, oldSizeOnDisk := n.UniqueSize(), n.UniqueSizeOnDisk()
+	oldApparentSize, oldApparentSizeOnDisk := n.ApparentSize, n.ApparentSizeOnDisk
+	n.Size, n.SizeOnDisk = size, sizeOnDisk
+	n.ApparentSize, n.ApparentSizeOnDisk = size, sizeOnDisk
+	n.mu.Unlock()
+	if n.Parent == nil {
+		return nil
+	}
+	n.Parent.addSizeDelta(
+		n.UniqueSize()-oldSize,
+		n.UniqueSizeOnDisk()-oldSizeOnDisk,
+		size-oldApparentSize,
+		sizeOnDisk-oldApparentSizeOnDisk,
+	)
+	n.Parent.updateStats()
+	return nil
 }
 
+// SetDuplicateLink sets whether the size of n is counted for another link to
+// the same file, and adds the resulting difference in unique size to all of
+// its ancestors.
+func (n *Node) SetDuplicateLink(dup bool) {
+	n.mu.Lock()
+	oldSize, oldSizeOnDisk := n.UniqueSize(), n.UniqueSizeOnDisk()
+	n.DuplicateLink = dup
+	n.mu.Unlock()
+	if n.Parent == nil {
+		return
+	}
+	n.Parent.addSizeDelta(n.UniqueSize()-oldSize, n.UniqueSizeOnDisk()-oldSizeOnDisk, 0, 0)
+}
+
+// addSizeDelta adds the given size differences to n and all of its
+// ancestors.
+func (n *Node) addSizeDelta(size, sizeOnDisk, apparentSize, apparentSizeOnDisk int64) {
+	for a := n; a != nil; a = a.Parent {
+		a.mu.Lock()
+		a.Size += size
+		a.SizeOnDisk += sizeOnDisk
+		a.ApparentSize += apparentSize
+		a.ApparentSizeOnDisk += apparentSizeOnDisk
+		a.mu.Unlock()
+	}
+}
+
+// Lookup returns the descendant of n reached by following the given child
+// names, or nil if there is no such node.
+func (n *Node) Lookup(names ...string) *Node {
+	curr := n
+	for _, name := range names {
+		var next *Node
+		curr.mu.Lock()
+		for _, c := range curr.Children {
+			if c.Name == name {
+				next = c
+				break
+			}
+		}
+		curr.mu.Unlock()
+		if next == nil {
+			return nil
+		}
+		curr = next
+	}
+	return curr
+}
+
+// AddChild appends child to the children of n without changing any sizes,
+// for building trees whose sizes are known already. Changes to a built tree
+// go through AttachChild, DetachChild, ReplaceChild, MoveTo and Resize,
+// which keep the sizes of the ancestors right.
 func (n *Node) AddChild(child *Node) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
@@ -296,28 +676,55 @@ func (n *Node) AddChild(child *Node) {
 }
 
 func (n *Node) SortChildrenBySizeOnDisk() {
-	for _, c := range n.Children {
-		c.SortChildrenBySizeOnDisk()
-	}
-	sort.Slice(n.Children, func(i, j int) bool {
-		return n.Children[i].SizeOnDisk > n.Children[j].SizeOnDisk
-	})
+	n.SortChildren(SortBySizeOnDisk)
+}
+
+// PrintOptions control how Fprint lists a tree.
+type PrintOptions struct {
+	Formatter SizeFormatter
+	// ShowHidden lists the hidden children of every node one by one instead
+	// of summarizing them in a ghost entry.
+	ShowHidden bool
+	// Dim greys out hidden children and ghost entries with ANSI escape
+	// codes.
+	Dim bool
 }
 
 func (n *Node) Print() {
-	n.printWithLevel(0, NoFormat)
+	n.PrintWithSizeFormatter(NoFormat)
 }
 
 func (n *Node) PrintWithSizeFormatter(f SizeFormatter) {
-	n.printWithLevel(0, f)
+	n.Fprint(os.Stdout, PrintOptions{Formatter: f})
+}
+
+// Fprint writes the tree under n to w, one line per node, indented by
+// depth.
+func (n *Node) Fprint(w io.Writer, opts PrintOptions) {
+	if opts.Formatter == nil {
+		opts.Formatter = NoFormat
+	}
+	n.fprintWithLevel(w, 0, &opts, false)
 }
 
-func (n *Node) printWithLevel(level int, f SizeFormatter) {
+func (n *Node) fprintWithLevel(w io.Writer, level int, opts *PrintOptions, dim bool) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
-	fmt.Println(n.infoWithLevel(level, f))
+	line := n.infoWithLevel(level, opts.Formatter)
+	if dim && opts.Dim {
+		// Faint, then back to normal intensity.
+		line = "\x1b[2m" + line + "\x1b[22m"
+	}
+	fmt.Fprintln(w, line)
 	for _, child := range n.Children {
-		child.printWithLevel(level+1, f)
+		child.fprintWithLevel(w, level+1, opts, dim)
+	}
+	if opts.ShowHidden {
+		for _, h := range n.Hidden {
+			h.fprintWithLevel(w, level+1, opts, true)
+		}
+	} else if g := n.HiddenSummary(); g != nil {
+		g.fprintWithLevel(w, level+1, opts, true)
 	}
 }
 
@@ -330,7 +737,10 @@ func (n *Node) InfoWithSizeFormatter(f SizeFormatter) string {
 }
 
 func (n *Node) infoWithLevel(level int, f SizeFormatter) string {
-	return fmt.Sprintf("%s%s [%s]", strings.Repeat("  ", level), n.Name, f(n.SizeOnDisk))
+	if n.Ghost {
+		return fmt.Sprintf("%s<%s, %s>", strings.Repeat("  ", level), n.Name, f(n.SizeOnDisk))
+	}
+	return fmt.Sprintf("%s%s [%s]", strings.Repeat("  ", level), n.DisplayName(), f(n.SizeOnDisk))
 }
 
 func (n *Node) RelativePath(parent string) string {
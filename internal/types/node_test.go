@@ -0,0 +1,310 @@
+package types
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dirSize is the size of a directory itself in the trees of the tests.
+const dirSize = 4096
+
+var testTime = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func file(name string, size int64) *Node {
+	return &Node{
+		Name:               name,
+		Mode:               0o644,
+		Size:               size,
+		SizeOnDisk:         size,
+		ApparentSize:       size,
+		ApparentSizeOnDisk: size,
+		Links:              1,
+		LastModification:   testTime,
+	}
+}
+
+// dir returns a directory with the given children, with sizes and stats as
+// the scan would record them.
+func dir(name string, children ...*Node) *Node {
+	n := &Node{
+		Name:               name,
+		Mode:               os.ModeDir | 0o755,
+		Size:               dirSize,
+		SizeOnDisk:         dirSize,
+		ApparentSize:       dirSize,
+		ApparentSizeOnDisk: dirSize,
+		IsDir:              true,
+		Stats:              &Stats{OwnSizeOnDisk: dirSize},
+	}
+	for _, c := range children {
+		c.Parent = n
+		n.Size += c.UniqueSize()
+		n.SizeOnDisk += c.UniqueSizeOnDisk()
+		n.ApparentSize += c.ApparentSize
+		n.ApparentSizeOnDisk += c.ApparentSizeOnDisk
+		n.Stats.Add(c)
+		n.Children = append(n.Children, c)
+	}
+	return n
+}
+
+// testTree returns the tree
+//
+//	root/
+//	  a/
+//	    x (100)
+//	    b/
+//	      y (200)
+//	  z (50)
+func testTree() *Node {
+	return dir("root",
+		dir("a",
+			file("x", 100),
+			dir("b", file("y", 200)),
+		),
+		file("z", 50),
+	)
+}
+
+// checkInvariants checks that the sizes and stats of every directory under
+// n add up from its entries.
+func checkInvariants(t *testing.T, n *Node) {
+	t.Helper()
+	if !n.IsDir {
+		return
+	}
+	size, sizeOnDisk := int64(dirSize), int64(dirSize)
+	apparentSize, apparentSizeOnDisk := int64(dirSize), int64(dirSize)
+	want := Stats{OwnSizeOnDisk: dirSize}
+	for _, entries := range [][]*Node{n.Children, n.Hidden} {
+		for _, c := range entries {
+			if c.Parent != n {
+				t.Errorf("%s: child %s has parent %v", n.Name, c.Name, c.Parent)
+			}
+			size += c.UniqueSize()
+			sizeOnDisk += c.UniqueSizeOnDisk()
+			apparentSize += c.ApparentSize
+			apparentSizeOnDisk += c.ApparentSizeOnDisk
+			want.Add(c)
+			checkInvariants(t, c)
+		}
+	}
+	assert.Equal(t, size, n.Size, "size of %s", n.Name)
+	assert.Equal(t, sizeOnDisk, n.SizeOnDisk, "size on disk of %s", n.Name)
+	assert.Equal(t, apparentSize, n.ApparentSize, "apparent size of %s", n.Name)
+	assert.Equal(t, apparentSizeOnDisk, n.ApparentSizeOnDisk, "apparent size on disk of %s", n.Name)
+	if assert.NotNil(t, n.Stats, "stats of %s", n.Name) {
+		assert.Equal(t, want, *n.Stats, "stats of %s", n.Name)
+	}
+}
+
+func TestAttachDetachChild(t *testing.T) {
+	root := testTree()
+	checkInvariants(t, root)
+	b := root.Lookup("a", "b")
+
+	b.AttachChild(file("new", 1000))
+	checkInvariants(t, root)
+	assert.Equal(t, int64(3*dirSize+1350), root.Size)
+	assert.Equal(t, int64(4), root.Stats.Files)
+	assert.Equal(t, "a/b/new", root.Stats.LargestFile)
+
+	if err := root.Lookup("a").DetachChild(b); err != nil {
+		t.Fatalf("DetachChild: %v", err)
+	}
+	checkInvariants(t, root)
+	assert.Equal(t, int64(2*dirSize+150), root.Size)
+	assert.Equal(t, 2, root.Stats.Depth)
+	assert.Nil(t, b.Parent, "parent of the detached node")
+
+	if err := root.DetachChild(b); err == nil {
+		t.Errorf("DetachChild: wanted an error for a node that is not a child")
+	}
+}
+
+func TestDetachHiddenChild(t *testing.T) {
+	root := testTree()
+	a := root.Lookup("a")
+	hidden := file("ignored.log", 300)
+	a.AttachChild(hidden)
+	a.Children = a.Children[:len(a.Children)-1]
+	a.Hidden = append(a.Hidden, hidden)
+	checkInvariants(t, root)
+
+	if err := a.DetachChild(hidden); err != nil {
+		t.Fatalf("DetachChild: %v", err)
+	}
+	checkInvariants(t, root)
+	assert.Empty(t, a.Hidden)
+	assert.Equal(t, int64(3*dirSize+350), root.Size)
+}
+
+func TestNewFilteredTreeKeepsHidden(t *testing.T) {
+	root := testTree()
+	a := root.Lookup("a")
+	a.Hidden = append(a.Hidden, &Node{Name: "ignored.log", Size: 300, Parent: a})
+
+	opts, err := NewTreeFilterOpts("y", "", false, false)
+	if err != nil {
+		t.Fatalf("NewTreeFilterOpts: %v", err)
+	}
+	tree, err := root.NewFilteredTree(opts)
+	if err != nil {
+		t.Fatalf("NewFilteredTree: %v", err)
+	}
+	found := tree.Lookup("a")
+	if assert.NotNil(t, found) && assert.Len(t, found.Hidden, 1) {
+		h := found.Hidden[0]
+		assert.Equal(t, "ignored.log", h.Name)
+		assert.True(t, h.Parent == found, "hidden child of the copy")
+		assert.True(t, h.IsHidden())
+		assert.NotNil(t, found.HiddenSummary(), "ghost row")
+	}
+	assert.NotNil(t, tree.Lookup("a", "b", "y"))
+	assert.Nil(t, tree.Lookup("z"))
+	assert.Len(t, a.Hidden, 1, "original")
+}
+
+func TestMoveTo(t *testing.T) {
+	root := testTree()
+	a, b := root.Lookup("a"), root.Lookup("a", "b")
+
+	if err := root.Lookup("z").MoveTo(b); err != nil {
+		t.Fatalf("MoveTo: %v", err)
+	}
+	checkInvariants(t, root)
+	assert.Equal(t, int64(3*dirSize+350), root.Size)
+	assert.Equal(t, int64(2*dirSize+350), a.Size)
+	assert.Equal(t, int64(dirSize+250), b.Size)
+	assert.NotNil(t, b.Lookup("z"))
+
+	if err := a.MoveTo(b); err == nil {
+		t.Errorf("MoveTo: wanted an error for moving a directory into itself")
+	}
+	if err := b.MoveTo(root.Lookup("a", "x")); err == nil {
+		t.Errorf("MoveTo: wanted an error for moving under a file")
+	}
+	if err := root.MoveTo(b); err == nil {
+		t.Errorf("MoveTo: wanted an error for moving the root")
+	}
+	checkInvariants(t, root)
+}
+
+func TestRename(t *testing.T) {
+	root := testTree()
+	b := root.Lookup("a", "b")
+	if err := b.Rename("c"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	checkInvariants(t, root)
+	assert.Equal(t, "a/c/y", root.Stats.LargestFile)
+	assert.Same(t, b, root.Lookup("a", "c"))
+	assert.Nil(t, root.Lookup("a", "b"))
+
+	if err := b.Rename("x"); err == nil {
+		t.Errorf("Rename: wanted an error for a name that is taken")
+	}
+	if err := b.Rename("d/e"); err == nil {
+		t.Errorf("Rename: wanted an error for a name with a slash")
+	}
+	if err := root.Rename("other"); err == nil {
+		t.Errorf("Rename: wanted an error for the root")
+	}
+	assert.Equal(t, "c", b.Name)
+}
+
+func TestResize(t *testing.T) {
+	root := testTree()
+	y := root.Lookup("a", "b", "y")
+	if err := y.Resize(5000, 8192); err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+	checkInvariants(t, root)
+	assert.Equal(t, int64(3*dirSize+5150), root.Size)
+	assert.Equal(t, int64(3*dirSize+8342), root.SizeOnDisk)
+	assert.Equal(t, int64(8192), root.Stats.LargestSize)
+
+	// A duplicate link only counts towards the apparent sizes.
+	x := root.Lookup("a", "x")
+	if err := root.Lookup("a").DetachChild(x); err != nil {
+		t.Fatal(err)
+	}
+	x.DuplicateLink = true
+	root.Lookup("a").AttachChild(x)
+	before := root.Size
+	if err := x.Resize(700, 700); err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+	checkInvariants(t, root)
+	assert.Equal(t, before, root.Size)
+
+	if err := root.Lookup("a").Resize(1, 1); err == nil {
+		t.Errorf("Resize: wanted an error for a directory")
+	}
+}
+
+func TestReplaceChild(t *testing.T) {
+	root := testTree()
+	a := root.Lookup("a")
+	old := a.Lookup("b")
+	fresh := dir("b", file("y", 200), file("w", 10), dir("c"))
+	if err := a.ReplaceChild(old, fresh); err != nil {
+		t.Fatalf("ReplaceChild: %v", err)
+	}
+	checkInvariants(t, root)
+	assert.Equal(t, int64(4*dirSize+360), root.Size)
+	assert.Equal(t, int64(3), root.Stats.Dirs)
+	assert.Nil(t, old.Parent, "parent of the replaced node")
+}
+
+func TestSetDuplicateLink(t *testing.T) {
+	root := testTree()
+	y := root.Lookup("a", "b", "y")
+	y.SetDuplicateLink(true)
+	checkInvariants(t, root)
+	assert.Equal(t, int64(3*dirSize+150), root.Size)
+	assert.Equal(t, int64(3*dirSize+350), root.ApparentSize)
+
+	y.SetDuplicateLink(false)
+	checkInvariants(t, root)
+	assert.Equal(t, int64(3*dirSize+350), root.Size)
+}
+
+func TestCreateChildAndRemove(t *testing.T) {
+	tmp := t.TempDir()
+	root := dir(filepath.Base(tmp))
+	parentPath := tmp
+
+	if err := root.CreateChild("new.txt", parentPath); err != nil {
+		t.Fatalf("CreateChild: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "new.txt")); err != nil {
+		t.Errorf("CreateChild did not create the file: %v", err)
+	}
+	created := root.Lookup("new.txt")
+	if created == nil {
+		t.Fatalf("CreateChild did not attach the file")
+	}
+	checkInvariants(t, root)
+	if err := root.CreateChild("new.txt", parentPath); err == nil {
+		t.Errorf("CreateChild: wanted an error for an existing file")
+	}
+
+	if err := created.Remove(parentPath); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "new.txt")); !os.IsNotExist(err) {
+		t.Errorf("Remove did not remove the file: %v", err)
+	}
+	checkInvariants(t, root)
+	assert.Nil(t, root.Lookup("new.txt"))
+	assert.Equal(t, int64(dirSize), root.Size)
+
+	if err := root.Remove(parentPath); err == nil {
+		t.Errorf("Remove: wanted an error for a directory")
+	}
+}
@@ -0,0 +1,251 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Stats aggregate the entries below a directory. They count hidden entries
+// too, like the sizes of the directory, but not the entries of archives.
+type Stats struct {
+	// Files and Dirs are the numbers of files and directories below the
+	// directory, at any depth.
+	Files int64
+	Dirs  int64
+	// Depth is how deep the deepest entry below the directory is nested in
+	// it, 1 for entries of the directory itself and 0 if it is empty.
+	Depth int
+	// LargestFile is the path of the largest file below the directory,
+	// relative to it, and LargestSize its size on disk.
+	LargestFile string
+	LargestSize int64
+	// Newest and Oldest are the latest and earliest modification times of
+	// the files below the directory, zero if there are none.
+	Newest time.Time
+	Oldest time.Time
+	// OwnSizeOnDisk is the size on disk of the directory itself, without
+	// its entries.
+	OwnSizeOnDisk int64
+}
+
+// Add adds the child c of the directory to the stats.
+func (s *Stats) Add(c *Node) {
+	if !c.IsDir {
+		s.Files++
+		if s.Depth < 1 {
+			s.Depth = 1
+		}
+		s.addLargest(c.Name, c.SizeOnDisk)
+		s.addModTime(c.LastModification, c.LastModification)
+		return
+	}
+	s.Dirs++
+	cs := c.Stats
+	if cs == nil {
+		// A directory that was not descended into.
+		cs = &Stats{}
+	}
+	s.Files += cs.Files
+	s.Dirs += cs.Dirs
+	if s.Depth < cs.Depth+1 {
+		s.Depth = cs.Depth + 1
+	}
+	if cs.LargestFile != "" {
+		s.addLargest(c.Name+"/"+cs.LargestFile, cs.LargestSize)
+	}
+	s.addModTime(cs.Newest, cs.Oldest)
+}
+
+func (s *Stats) addLargest(path string, size int64) {
+	if s.LargestFile == "" || size > s.LargestSize {
+		s.LargestFile, s.LargestSize = path, size
+	}
+}
+
+func (s *Stats) addModTime(newest, oldest time.Time) {
+	if !newest.IsZero() && newest.After(s.Newest) {
+		s.Newest = newest
+	}
+	if !oldest.IsZero() && (s.Oldest.IsZero() || oldest.Before(s.Oldest)) {
+		s.Oldest = oldest
+	}
+}
+
+// ChildrenSizeOnDisk returns the size on disk of the entries of the
+// directory with the given total size on disk.
+func (s *Stats) ChildrenSizeOnDisk(total int64) int64 {
+	return total - s.OwnSizeOnDisk
+}
+
+// AggregateStats returns the stats of n if it is a directory, and the
+// stats of a directory holding only n otherwise, so that files and
+// directories can be compared.
+func (n *Node) AggregateStats() Stats {
+	if n.IsDir {
+		if n.Stats == nil {
+			return Stats{OwnSizeOnDisk: n.SizeOnDisk}
+		}
+		return *n.Stats
+	}
+	var s Stats
+	s.Add(n)
+	return s
+}
+
+// ComputeStats sets the stats of the directories in the tree under n that
+// have none, as the scan would have, for example for trees loaded from old
+// snapshots.
+func (n *Node) ComputeStats() {
+	if !n.IsDir {
+		return
+	}
+	for _, c := range n.Children {
+		c.ComputeStats()
+	}
+	for _, c := range n.Hidden {
+		c.ComputeStats()
+	}
+	if n.Stats == nil {
+		n.Stats = n.statsOfEntries()
+	}
+}
+
+// statsOfEntries returns the stats of the directory n computed from the
+// stats of its entries.
+func (n *Node) statsOfEntries() *Stats {
+	s := &Stats{OwnSizeOnDisk: n.SizeOnDisk}
+	for _, entries := range [][]*Node{n.Children, n.Hidden} {
+		for _, c := range entries {
+			s.Add(c)
+			s.OwnSizeOnDisk -= c.UniqueSizeOnDisk()
+		}
+	}
+	return s
+}
+
+// updateStats computes the stats of n and all of its ancestors again from
+// their entries, after an entry of n changed.
+func (n *Node) updateStats() {
+	for a := n; a != nil; a = a.Parent {
+		if !a.IsDir {
+			continue
+		}
+		a.mu.Lock()
+		a.Stats = a.statsOfEntries()
+		a.mu.Unlock()
+	}
+}
+
+// SortKey is an order the children of a node can be sorted in.
+type SortKey string
+
+const (
+	SortBySizeOnDisk SortKey = "size"
+	SortByName       SortKey = "name"
+	SortByFiles      SortKey = "files"
+	SortByDirs       SortKey = "dirs"
+	SortByDepth      SortKey = "depth"
+	SortByNewest     SortKey = "newest"
+	SortByOldest     SortKey = "oldest"
+	SortByLargest    SortKey = "largest"
+)
+
+// SortKeys are all sort keys, in the order the TUI cycles through them.
+var SortKeys = []SortKey{
+	SortBySizeOnDisk,
+	SortByName,
+	SortByFiles,
+	SortByDirs,
+	SortByDepth,
+	SortByNewest,
+	SortByOldest,
+	SortByLargest,
+}
+
+// ParseSortKey returns the sort key with the given name.
+func ParseSortKey(s string) (SortKey, error) {
+	for _, k := range SortKeys {
+		if string(k) == s {
+			return k, nil
+		}
+	}
+	names := make([]string, len(SortKeys))
+	for i, k := range SortKeys {
+		names[i] = string(k)
+	}
+	return "", fmt.Errorf("unknown sort key %q, wanted one of %s", s, strings.Join(names, ", "))
+}
+
+// Description describes the order of the sort key.
+func (k SortKey) Description() string {
+	switch k {
+	case SortByName:
+		return "name"
+	case SortByFiles:
+		return "most files"
+	case SortByDirs:
+		return "most folders"
+	case SortByDepth:
+		return "deepest nesting"
+	case SortByNewest:
+		return "newest modification"
+	case SortByOldest:
+		return "oldest modification"
+	case SortByLargest:
+		return "largest file"
+	}
+	return "size on disk"
+}
+
+// less reports whether a comes before b in the order of k. Entries that
+// are equal by k are ordered by size on disk.
+func (k SortKey) less(a, b *Node) bool {
+	if k == SortByName {
+		return a.Name < b.Name
+	}
+	sa, sb := a.AggregateStats(), b.AggregateStats()
+	switch k {
+	case SortByFiles:
+		if sa.Files != sb.Files {
+			return sa.Files > sb.Files
+		}
+	case SortByDirs:
+		if sa.Dirs != sb.Dirs {
+			return sa.Dirs > sb.Dirs
+		}
+	case SortByDepth:
+		if sa.Depth != sb.Depth {
+			return sa.Depth > sb.Depth
+		}
+	case SortByNewest:
+		if !sa.Newest.Equal(sb.Newest) {
+			return sa.Newest.After(sb.Newest)
+		}
+	case SortByOldest:
+		if !sa.Oldest.Equal(sb.Oldest) {
+			// Entries without files go last.
+			return !sa.Oldest.IsZero() && (sb.Oldest.IsZero() || sa.Oldest.Before(sb.Oldest))
+		}
+	case SortByLargest:
+		if sa.LargestSize != sb.LargestSize {
+			return sa.LargestSize > sb.LargestSize
+		}
+	}
+	return a.SizeOnDisk > b.SizeOnDisk
+}
+
+// SortChildren sorts the children and hidden children of every node in
+// the tree under n by k.
+func (n *Node) SortChildren(k SortKey) {
+	for _, c := range n.Children {
+		c.SortChildren(k)
+	}
+	sort.SliceStable(n.Children, func(i, j int) bool {
+		return k.less(n.Children[i], n.Children[j])
+	})
+	sort.SliceStable(n.Hidden, func(i, j int) bool {
+		return k.less(n.Hidden[i], n.Hidden[j])
+	})
+}
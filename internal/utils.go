@@ -5,17 +5,24 @@ import (
 	"os/exec"
 	"runtime"
 	"strconv"
+	"time"
 )
 
 func ParseByteSize(s string) (ByteSize, int64, error) {
 	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
 		return B, i, nil
 	}
+	if s == "" {
+		return 0, 0, fmt.Errorf("invalid formatting %q", s)
+	}
 	if s[len(s)-1] == 'b' || s[len(s)-1] == 'B' {
 		if i, err := strconv.ParseInt(s[:len(s)-1], 10, 64); err == nil {
 			return B, i, nil
 		}
 	}
+	if len(s) < 3 {
+		return 0, 0, fmt.Errorf("invalid formatting %q", s)
+	}
 	i, err := strconv.ParseInt(s[:len(s)-2], 10, 64)
 	if err != nil {
 		return 0, 0, fmt.Errorf("invalid formatting %q: %v", s, err)
@@ -36,6 +43,30 @@ func ParseByteSize(s string) (ByteSize, int64, error) {
 	return 0, 0, fmt.Errorf("invalid suffix: %s", suffix)
 }
 
+// ParseAge parses an age like 90d, 2w or 1y, in days, weeks or years of 365
+// days, or any duration time.ParseDuration accepts, like 36h.
+func ParseAge(s string) (time.Duration, error) {
+	units := map[byte]time.Duration{
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+		'y': 365 * 24 * time.Hour,
+	}
+	if len(s) > 1 {
+		if unit, ok := units[s[len(s)-1]]; ok {
+			i, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid age %q: %v", s, err)
+			}
+			return time.Duration(i) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid age %q: %v", s, err)
+	}
+	return d, nil
+}
+
 func OpenFile(path string) error {
 	switch runtime.GOOS {
 	case "windows":
@@ -47,6 +78,7 @@ func OpenFile(path string) error {
 	}
 }
 
-func OpenFileWithProgram(path, program string) error {
-	return exec.Command(program, path).Run()
+// OpenFilesWithProgram runs program with the paths as its arguments.
+func OpenFilesWithProgram(program string, paths ...string) error {
+	return exec.Command(program, paths...).Run()
 }
@@ -0,0 +1,238 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"go.sazak.io/gls/log"
+)
+
+const (
+	inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+		syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB | syscall.IN_DELETE_SELF |
+		syscall.IN_MOVE_SELF | syscall.IN_ONLYDIR | syscall.IN_DONT_FOLLOW
+)
+
+// inotifyWatcher is the native Watcher on Linux. Each watched directory
+// takes up one inotify watch descriptor. Directories added after the
+// watches or file descriptors ran out are polled instead, every
+// pollInterval.
+type inotifyWatcher struct {
+	fd   int
+	file *os.File
+	// addWatch is syscall.InotifyAddWatch, replaced in tests.
+	addWatch     func(fd int, path string, mask uint32) (int, error)
+	pollInterval time.Duration
+
+	// mu guards paths, the watched directory of each watch descriptor, and
+	// polled, which keeps the listings of the polled directories once there
+	// are any.
+	mu     sync.Mutex
+	paths  map[int32]string
+	polled *poller
+
+	events chan string
+	errors chan error
+	done   chan struct{}
+}
+
+func newNative(pollInterval time.Duration) (Watcher, error) {
+	w, err := newInotify(pollInterval, syscall.InotifyAddWatch)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func newInotify(pollInterval time.Duration, addWatch func(fd int, path string, mask uint32) (int, error)) (*inotifyWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify_init1: %v", err)
+	}
+	w := &inotifyWatcher{
+		fd: fd,
+		// A non-blocking descriptor is handled by the runtime poller, so
+		// closing the file unblocks a pending read, and a read deadline
+		// makes room for polling.
+		file:         os.NewFile(uintptr(fd), "inotify"),
+		addWatch:     addWatch,
+		pollInterval: pollInterval,
+		paths:        make(map[int32]string),
+		events:       make(chan string),
+		errors:       make(chan error),
+		done:         make(chan struct{}),
+	}
+	go w.readEvents()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Events() <-chan string {
+	return w.events
+}
+
+func (w *inotifyWatcher) Errors() <-chan error {
+	return w.errors
+}
+
+func (w *inotifyWatcher) Add(path string) error {
+	wd, err := w.addWatch(w.fd, path, inotifyMask)
+	if err == syscall.ENOSPC || err == syscall.EMFILE {
+		return w.poll(path, err)
+	}
+	if err != nil {
+		return fmt.Errorf("inotify_add_watch %s: %v", path, err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paths[int32(wd)] = path
+	return nil
+}
+
+// poll has the directory at path polled, since inotify ran out of resources
+// with err.
+func (w *inotifyWatcher) poll(path string, err error) error {
+	w.mu.Lock()
+	p, first := w.polled, w.polled == nil
+	if first {
+		p = &poller{interval: w.pollInterval, dirs: make(map[string]map[string]entryState)}
+		w.polled = p
+	}
+	w.mu.Unlock()
+	if first {
+		log.Warningf("Could not watch %s, polling it and the other directories inotify has no room for every %v: %v",
+			path, w.pollInterval, err)
+	}
+	if err := p.Add(path); err != nil {
+		return err
+	}
+	if first {
+		// Wake up readEvents to start polling.
+		return w.file.SetReadDeadline(time.Now())
+	}
+	return nil
+}
+
+// polledDirs returns the poller of the directories inotify had no room
+// for, or nil if there are none.
+func (w *inotifyWatcher) polledDirs() *poller {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.polled
+}
+
+func (w *inotifyWatcher) Close() error {
+	select {
+	case <-w.done:
+		return nil
+	default:
+	}
+	close(w.done)
+	return w.file.Close()
+}
+
+func (w *inotifyWatcher) readEvents() {
+	defer close(w.events)
+	defer close(w.errors)
+	var buf [syscall.SizeofInotifyEvent * 4096]byte
+	var nextPoll time.Time
+	for {
+		if p := w.polledDirs(); p != nil {
+			now := time.Now()
+			if nextPoll.IsZero() {
+				nextPoll = now.Add(p.interval)
+			}
+			if !now.Before(nextPoll) {
+				for _, path := range p.poll() {
+					if !w.send(path) {
+						return
+					}
+				}
+				nextPoll = now.Add(p.interval)
+			}
+			if err := w.file.SetReadDeadline(nextPoll); err != nil {
+				w.sendError(err)
+				return
+			}
+		}
+		n, err := w.file.Read(buf[:])
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			select {
+			case <-w.done:
+			default:
+				w.sendError(err)
+			}
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := string(trimNul(buf[nameStart : nameStart+int(ev.Len)]))
+			offset = nameStart + int(ev.Len)
+			if !w.handle(ev, name) {
+				return
+			}
+		}
+	}
+}
+
+// handle turns a raw event into a changed path, and reports whether the
+// watcher is still open.
+func (w *inotifyWatcher) handle(ev *syscall.InotifyEvent, name string) bool {
+	if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+		return w.sendError(ErrOverflow)
+	}
+	w.mu.Lock()
+	dir, ok := w.paths[ev.Wd]
+	if ev.Mask&syscall.IN_IGNORED != 0 {
+		delete(w.paths, ev.Wd)
+	}
+	w.mu.Unlock()
+	if !ok || ev.Mask&syscall.IN_IGNORED != 0 {
+		return true
+	}
+	path := dir
+	if name != "" {
+		path = dir + "/" + name
+	}
+	if ev.Mask&syscall.IN_ISDIR != 0 && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		if err := w.Add(path); err != nil && !w.sendError(err) {
+			return false
+		}
+	}
+	return w.send(path)
+}
+
+func (w *inotifyWatcher) send(path string) bool {
+	select {
+	case w.events <- path:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+func (w *inotifyWatcher) sendError(err error) bool {
+	select {
+	case w.errors <- err:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+func trimNul(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
@@ -0,0 +1,24 @@
+package watch
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInotifyWatcherOutOfWatches(t *testing.T) {
+	testWatcher(t, func(dir string) (Watcher, error) {
+		// Only dir gets a watch, so the directories created in it are
+		// polled.
+		w, err := newInotify(10*time.Millisecond, func(fd int, path string, mask uint32) (int, error) {
+			if path != dir {
+				return -1, syscall.ENOSPC
+			}
+			return syscall.InotifyAddWatch(fd, path, mask)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return w, w.Add(dir)
+	})
+}
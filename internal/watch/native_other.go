@@ -0,0 +1,13 @@
+//go:build !linux
+
+package watch
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+func newNative(pollInterval time.Duration) (Watcher, error) {
+	return nil, fmt.Errorf("no native file system notifications on %s", runtime.GOOS)
+}
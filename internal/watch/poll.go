@@ -0,0 +1,160 @@
+package watch
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// entryState is what the poller remembers about a directory entry to tell
+// whether it changed.
+type entryState struct {
+	isDir   bool
+	size    int64
+	modTime time.Time
+}
+
+// poller is the fallback Watcher, which lists every watched directory on
+// each tick and compares the entries with the previous listing.
+type poller struct {
+	interval time.Duration
+
+	// mu guards dirs, the last listing of each watched directory.
+	mu   sync.Mutex
+	dirs map[string]map[string]entryState
+
+	events chan string
+	errors chan error
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newPoller(dirs []string, interval time.Duration) (Watcher, error) {
+	p := &poller{
+		interval: interval,
+		dirs:     make(map[string]map[string]entryState),
+		events:   make(chan string),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+	}
+	for _, d := range dirs {
+		if err := p.Add(d); err != nil {
+			return nil, err
+		}
+	}
+	go p.run()
+	return p, nil
+}
+
+func (p *poller) Events() <-chan string {
+	return p.events
+}
+
+func (p *poller) Errors() <-chan error {
+	return p.errors
+}
+
+func (p *poller) Add(path string) error {
+	entries, err := listDir(path)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dirs[path] = entries
+	return nil
+}
+
+func (p *poller) Close() error {
+	p.once.Do(func() {
+		close(p.done)
+	})
+	return nil
+}
+
+func (p *poller) run() {
+	defer close(p.events)
+	defer close(p.errors)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+		for _, path := range p.poll() {
+			select {
+			case p.events <- path:
+			case <-p.done:
+				return
+			}
+		}
+	}
+}
+
+// poll lists all watched directories again and returns the paths of the
+// entries that changed since the last poll.
+func (p *poller) poll() []string {
+	p.mu.Lock()
+	dirs := make([]string, 0, len(p.dirs))
+	for d := range p.dirs {
+		dirs = append(dirs, d)
+	}
+	p.mu.Unlock()
+
+	var changed []string
+	for _, dir := range dirs {
+		entries, err := listDir(dir)
+		p.mu.Lock()
+		prev := p.dirs[dir]
+		if err != nil {
+			// The directory itself is gone, which its parent reports.
+			delete(p.dirs, dir)
+			p.mu.Unlock()
+			continue
+		}
+		p.dirs[dir] = entries
+		p.mu.Unlock()
+		for name, curr := range entries {
+			if old, ok := prev[name]; !ok || old != curr {
+				changed = append(changed, dir+"/"+name)
+				if curr.isDir && !ok {
+					p.Add(dir + "/" + name)
+				}
+			}
+		}
+		for name := range prev {
+			if _, ok := entries[name]; !ok {
+				changed = append(changed, dir+"/"+name)
+			}
+		}
+	}
+	return changed
+}
+
+func listDir(path string) (map[string]entryState, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]entryState, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			// Removed since the directory was read.
+			continue
+		}
+		if e.IsDir() {
+			// Changes inside a directory touch its modification time, but
+			// are reported through the directory's own listing.
+			states[e.Name()] = entryState{isDir: true}
+			continue
+		}
+		states[e.Name()] = entryState{
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		}
+	}
+	return states, nil
+}
@@ -0,0 +1,43 @@
+package watch
+
+import (
+	"errors"
+	"time"
+
+	"go.sazak.io/gls/log"
+)
+
+// ErrOverflow is reported when changes were lost, so the whole watched tree
+// has to be considered changed.
+var ErrOverflow = errors.New("watch: event queue overflowed")
+
+// Watcher reports the paths of files and directories below a set of watched
+// directories that were created, removed, renamed or modified. A rename is
+// reported as a change of both the old and the new path.
+type Watcher interface {
+	Events() <-chan string
+	Errors() <-chan error
+	// Add starts watching the directory at path.
+	Add(path string) error
+	Close() error
+}
+
+// New watches the given directories with the native file system
+// notifications of the platform, and falls back to polling them every
+// pollInterval if those are not available. Directories the notifications run
+// out of resources for are polled on their own.
+func New(dirs []string, pollInterval time.Duration) (Watcher, error) {
+	w, err := newNative(pollInterval)
+	if err != nil {
+		log.Warningf("Native file system notifications not available, polling every %v: %v", pollInterval, err)
+		return newPoller(dirs, pollInterval)
+	}
+	for _, d := range dirs {
+		if err := w.Add(d); err != nil {
+			log.Warningf("Could not watch %s, polling every %v instead: %v", d, pollInterval, err)
+			w.Close()
+			return newPoller(dirs, pollInterval)
+		}
+	}
+	return w, nil
+}
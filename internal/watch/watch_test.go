@@ -0,0 +1,74 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func expectEvent(t *testing.T, w Watcher, want string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case got := <-w.Events():
+			if got == want {
+				return
+			}
+		case err := <-w.Errors():
+			t.Fatalf("unexpected error: %v", err)
+		case <-timeout:
+			t.Fatalf("no event for %s", want)
+		}
+	}
+}
+
+func testWatcher(t *testing.T, newWatcher func(dir string) (Watcher, error)) {
+	dir := t.TempDir()
+	w, err := newWatcher(dir)
+	if err != nil {
+		t.Skipf("watcher not available: %v", err)
+	}
+	defer w.Close()
+
+	created := filepath.Join(dir, "created")
+	if err := os.WriteFile(created, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, w, created)
+
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, w, sub)
+	// New directories are watched without being added explicitly.
+	nested := filepath.Join(sub, "nested")
+	time.Sleep(50 * time.Millisecond)
+	if err := os.WriteFile(nested, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, w, nested)
+
+	if err := os.Remove(created); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, w, created)
+}
+
+func TestNativeWatcher(t *testing.T) {
+	testWatcher(t, func(dir string) (Watcher, error) {
+		w, err := newNative(10 * time.Millisecond)
+		if err != nil {
+			return nil, err
+		}
+		return w, w.Add(dir)
+	})
+}
+
+func TestPoller(t *testing.T) {
+	testWatcher(t, func(dir string) (Watcher, error) {
+		return newPoller([]string{dir}, 10*time.Millisecond)
+	})
+}